@@ -2,11 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -15,34 +21,62 @@ import (
 	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
 	"github.com/isitobservable/mcp-otel-proxy/internal/proxy"
 	"github.com/isitobservable/mcp-otel-proxy/internal/telemetry"
+	"github.com/isitobservable/mcp-otel-proxy/internal/traceview"
 )
 
 func main() {
-	cfg, err := config.Load()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate-config":
+			os.Exit(validateConfig(os.Args[2:]))
+		case "view":
+			os.Exit(view(os.Args[2:]))
+		}
+	}
+
+	configFile := flag.String("config", os.Getenv("CONFIG_FILE"), "YAML or JSON config file (env: CONFIG_FILE)")
+	flag.Parse()
+
+	cfg, err := config.LoadFile(*configFile)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Set up slog level for stdout logging during init
-	var logLevel slog.Level
-	switch cfg.LogLevel {
-	case "debug":
-		logLevel = slog.LevelDebug
-	case "warn":
-		logLevel = slog.LevelWarn
-	case "error":
-		logLevel = slog.LevelError
-	default:
-		logLevel = slog.LevelInfo
-	}
+	// The level can change on reload
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(parseLevel(cfg.LogLevel))
 
-	_, _ = fmt.Fprintf(os.Stdout, "mcp-otel-proxy starting (pre-init) upstream=%s port=%s otel=%s\n", cfg.UpstreamURL, cfg.ProxyPort, cfg.OTELEndpoint)
+	_, _ = fmt.Fprintf(os.Stdout, "mcp-otel-proxy starting (pre-init) upstreams=%d port=%s otel=%s\n", len(cfg.Upstreams), cfg.ProxyPort, cfg.OTELEndpoint)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Initialize OTel providers
-	providers, err := telemetry.InitOTel(ctx, cfg.OTELEndpoint, cfg.ServiceName, cfg.OTELInsecure)
+	providers, err := telemetry.InitOTel(ctx, telemetry.Options{
+		ServiceName:        cfg.ServiceName,
+		ResourceAttributes: cfg.ResourceAttributes,
+		TracesExporter:     cfg.TracesExporter,
+		MetricsExporters:   cfg.MetricsExporters,
+		LogsExporter:       cfg.LogsExporter,
+		OTLP: telemetry.ExporterOptions{
+			Protocol:              cfg.OTELProtocol,
+			Endpoint:              cfg.OTELEndpoint,
+			TracesEndpoint:        cfg.OTELTracesEndpoint,
+			MetricsEndpoint:       cfg.OTELMetricsEndpoint,
+			LogsEndpoint:          cfg.OTELLogsEndpoint,
+			Insecure:              cfg.OTELInsecure,
+			Headers:               cfg.OTELHeaders,
+			Compression:           cfg.OTELCompression,
+			CertificateFile:       cfg.OTELCertificate,
+			ClientCertificateFile: cfg.OTELClientCertificate,
+			ClientKeyFile:         cfg.OTELClientKey,
+		},
+		File: telemetry.FileOptions{
+			Dir:        cfg.FileExporterDir,
+			MaxBytes:   int64(cfg.FileExporterMaxSizeMB) << 20,
+			MaxBackups: cfg.FileExporterMaxBackups,
+		},
+	})
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to initialize OpenTelemetry: %v\n", err)
 		os.Exit(1)
@@ -66,7 +100,17 @@ func main() {
 	sessions := mcp.NewSessionStore(
 		time.Duration(cfg.SessionTTLSeconds)*time.Second,
 		func() { metrics.ActiveSessions.Add(ctx, 1) },
-		func() { metrics.ActiveSessions.Add(ctx, -1) },
+		func(s *mcp.Session, reason string) {
+			metrics.ActiveSessions.Add(ctx, -1)
+			metrics.SessionDuration.Record(ctx, time.Since(s.CreatedAt).Seconds(), telemetry.SessionEndAttrs(s, reason))
+			if saved := s.TokensSaved(); saved != 0 {
+				slog.InfoContext(ctx, "session compression savings",
+					"mcp.session.id", s.ID,
+					"mcp.proxy.session.end_reason", reason,
+					"tokens_saved", saved,
+				)
+			}
+		},
 	)
 
 	// Create proxy handler
@@ -80,7 +124,9 @@ func main() {
 	mux := http.NewServeMux()
 
 	// Health endpoints (no telemetry)
-	healthHandler := health.Handler(cfg.UpstreamURL)
+	var checks atomic.Pointer[[]health.Check]
+	checks.Store(upstreamChecks(cfg))
+	healthHandler := health.Handler(func() []health.Check { return *checks.Load() })
 	mux.Handle("GET /healthz", healthHandler)
 	mux.Handle("GET /readyz", healthHandler)
 
@@ -94,25 +140,125 @@ func main() {
 		WriteTimeout: 5 * time.Minute,
 		IdleTimeout:  60 * time.Second,
 	}
+	if cfg.TLSCertFile != "" {
+		tlsConfig, err := newTLSConfig(cfg.TLSClientCAFile)
+		if err != nil {
+			slog.Error("failed to configure TLS", "error", err)
+			os.Exit(1)
+		}
+		server.TLSConfig = tlsConfig
+	}
+
+	for _, up := range cfg.Upstreams {
+		slog.Info("upstream configured",
+			"upstream.name", up.Name,
+			"upstream.url", up.URL,
+			"upstream.command", up.Command,
+			"path.prefix", up.PathPrefix,
+			"compress.responses", up.CompressResponses,
+			"timeout.seconds", up.TimeoutSeconds,
+		)
+	}
 
 	slog.Info("mcp-otel-proxy starting",
 		"port", cfg.ProxyPort,
-		"upstream", cfg.UpstreamURL,
+		"upstreams", len(cfg.Upstreams),
 		"otel.endpoint", cfg.OTELEndpoint,
 		"otel.insecure", cfg.OTELInsecure,
+		"otel.protocol", cfg.OTELProtocol,
+		"otel.exporters.traces", cfg.TracesExporter,
+		"otel.exporters.metrics", strings.Join(cfg.MetricsExporters, ","),
+		"otel.exporters.logs", cfg.LogsExporter,
 		"service.name", cfg.ServiceName,
 		"context.propagation", cfg.ContextPropagation,
 		"capture.payload", cfg.CapturePayload,
 		"log.level", cfg.LogLevel,
 		"session.ttl", cfg.SessionTTLSeconds,
+		"session.injection", cfg.SessionInjection,
+		"client.identity", cfg.ClientIdentity,
+		"tls", cfg.TLSCertFile != "",
+		"config.file", cfg.Path,
 	)
 
+	if cfg.FileExporterEnabled() {
+		slog.Info("writing telemetry to files", "dir", cfg.FileExporterDir, "max.size.mb", cfg.FileExporterMaxSizeMB, "max.backups", cfg.FileExporterMaxBackups)
+	}
+
+	// Reload on SIGHUP, and when the config file changes
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	var fileChanged <-chan struct{}
+	if cfg.Path != "" {
+		fileChanged = config.Watch(ctx, cfg.Path, 2*time.Second)
+	}
+	go func() {
+		reload := func(trigger string) {
+			next, err := config.LoadFile(*configFile)
+			if err != nil {
+				slog.Error("config reload failed, keeping current configuration", "trigger", trigger, "error", err)
+				return
+			}
+			if err := proxyHandler.Reload(next); err != nil {
+				slog.Error("config reload failed, keeping current configuration", "trigger", trigger, "error", err)
+				return
+			}
+			// Compared against the startup configuration: these stay
+			// pending until the process restarts.
+			for _, setting := range config.RestartRequired(cfg, next) {
+				slog.Warn("config change takes effect after restart", "setting", setting)
+			}
+			logLevel.Set(parseLevel(next.LogLevel))
+			checks.Store(upstreamChecks(next))
+			slog.Info("configuration reloaded", "trigger", trigger, "upstreams", len(next.Upstreams))
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hupCh:
+				reload("signal")
+			case <-fileChanged:
+				reload("file")
+			}
+		}
+	}()
+
+	// Prometheus scrape and policy approval endpoints, on their own port so
+	// they are never exposed alongside MCP traffic
+	var adminServer *http.Server
+	if cfg.AdminEnabled() {
+		adminMux := http.NewServeMux()
+		if providers.MetricsHandler != nil {
+			adminMux.Handle("GET /metrics", providers.MetricsHandler)
+			slog.Info("prometheus metrics endpoint listening", "port", cfg.AdminPort, "path", "/metrics")
+		}
+		proxyHandler.RegisterApprovals(adminMux)
+		adminServer = &http.Server{
+			Addr:              ":" + cfg.AdminPort,
+			Handler:           adminMux,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func() {
+			if err := adminServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				slog.Error("admin server error", "error", err)
+				os.Exit(1)
+			}
+		}()
+		slog.Info("admin server listening", "port", cfg.AdminPort)
+	}
+
 	// Graceful shutdown
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
 
 	go func() {
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if cfg.TLSCertFile != "" {
+			err = server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
 			slog.Error("server error", "error", err)
 			os.Exit(1)
 		}
@@ -127,20 +273,142 @@ func main() {
 	if err := server.Shutdown(shutdownCtx); err != nil {
 		slog.Error("server shutdown error", "error", err)
 	}
+	if adminServer != nil {
+		_ = adminServer.Shutdown(shutdownCtx)
+	}
+	proxyHandler.Close()
+}
+
+// validateConfig implements the validate-config subcommand: it loads the
+// configuration the proxy would start with and reports every problem.
+func validateConfig(args []string) int {
+	fs := flag.NewFlagSet("validate-config", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "YAML or JSON config file (env: CONFIG_FILE)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		*configFile = fs.Arg(0)
+	}
+
+	cfg, err := config.LoadFile(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	source := "environment"
+	if cfg.Path != "" {
+		source = cfg.Path
+	}
+	fmt.Printf("configuration OK (%s): %d upstream(s), %d policy rule(s)\n", source, len(cfg.Upstreams), len(cfg.Policy.Rules))
+	return 0
+}
+
+// view implements the view subcommand: it prints trace files written by the
+// file exporter as per-session timelines of MCP calls.
+func view(args []string) int {
+	fs := flag.NewFlagSet("view", flag.ContinueOnError)
+	sessionPrefix := fs.String("session", "", "only show sessions whose ID starts with this prefix")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: mcp-otel-proxy view [-session ID] traces.jsonl...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var spans []*traceview.Span
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		fileSpans, err := traceview.Read(f)
+		_ = f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			return 1
+		}
+		spans = append(spans, fileSpans...)
+	}
+
+	sessions := traceview.Sessions(spans)
+	if *sessionPrefix != "" {
+		sessions = slices.DeleteFunc(sessions, func(s *traceview.Session) bool {
+			return !strings.HasPrefix(s.ID, *sessionPrefix)
+		})
+	}
+	if err := traceview.Render(os.Stdout, sessions); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// upstreamChecks returns one readiness check per configured upstream.
+func upstreamChecks(cfg *config.Config) *[]health.Check {
+	checks := make([]health.Check, 0, len(cfg.Upstreams))
+	for _, up := range cfg.Upstreams {
+		if up.Command != "" {
+			checks = append(checks, health.CommandCheck(up.Name, up.Command))
+			continue
+		}
+		checks = append(checks, health.UpstreamCheck(up.Name, up.URL))
+	}
+	return &checks
+}
+
+func parseLevel(level string) slog.Level {
+	switch level {
+	case "debug":
+		return slog.LevelDebug
+	case "warn":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// newTLSConfig returns the listener TLS configuration. With a client CA,
+// client certificates are verified when presented; they stay optional so
+// health probes can connect without one.
+func newTLSConfig(clientCAFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if clientCAFile == "" {
+		return tlsConfig, nil
+	}
+	pem, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", clientCAFile)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsConfig, nil
 }
 
 // levelHandler wraps a slog.Handler to filter by minimum level.
 type levelHandler struct {
-	level   slog.Level
+	level   slog.Leveler
 	handler slog.Handler
 }
 
-func newLevelHandler(level slog.Level, handler slog.Handler) *levelHandler {
+func newLevelHandler(level slog.Leveler, handler slog.Handler) *levelHandler {
 	return &levelHandler{level: level, handler: handler}
 }
 
 func (h *levelHandler) Enabled(_ context.Context, level slog.Level) bool {
-	return level >= h.level
+	return level >= h.level.Level()
 }
 
 func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
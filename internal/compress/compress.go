@@ -0,0 +1,127 @@
+package compress
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Compression formats, as named in configuration.
+const (
+	FormatMarkdown = "markdown"
+	FormatCSV      = "csv"
+	FormatYAML     = "yaml"
+	FormatKeyValue = "kv"
+	FormatTOON     = "toon"
+	// FormatAuto tries every other format and keeps the smallest output.
+	FormatAuto = "auto"
+)
+
+// Formats lists the format names New accepts.
+var Formats = []string{FormatMarkdown, FormatCSV, FormatYAML, FormatKeyValue, FormatTOON, FormatAuto}
+
+// Options tune how formats render nested data.
+type Options struct {
+	// FlattenDepth is how many levels of nested objects the table formats
+	// flatten into dotted columns such as metadata.name. Arrays of objects
+	// within those levels become sub-tables, and a single object becomes a
+	// key/value list. 0 collapses nested values to {...}.
+	FlattenDepth int
+}
+
+// Compressor re-encodes JSON tool output into a more compact text form.
+type Compressor interface {
+	// Compress returns the encoded content and the format used, or content
+	// unchanged and ok false when content is not JSON the format applies to.
+	Compress(content string) (out, format string, ok bool)
+}
+
+// encoder is one format. encode reports false for values it does not apply
+// to, such as a single object for a table format.
+type encoder struct {
+	name   string
+	encode func(v any, opts Options) (string, bool)
+	opts   Options
+}
+
+// encoders are tried in this order by auto, which keeps the earlier one on
+// a tie.
+var encoders = []encoder{
+	{name: FormatMarkdown, encode: encodeMarkdown},
+	{name: FormatCSV, encode: encodeCSV},
+	{name: FormatTOON, encode: encodeTOON},
+	{name: FormatYAML, encode: encodeYAML},
+	{name: FormatKeyValue, encode: encodeKeyValue},
+}
+
+// New returns the Compressor for a format name; "" means FormatMarkdown.
+func New(format string, opts Options) (Compressor, error) {
+	if opts.FlattenDepth < 0 {
+		return nil, fmt.Errorf("flatten depth must not be negative, got %d", opts.FlattenDepth)
+	}
+	if format == "" {
+		format = FormatMarkdown
+	}
+	if format == FormatAuto {
+		return auto{opts: opts}, nil
+	}
+	for _, e := range encoders {
+		if e.name == format {
+			e.opts = opts
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown compression format %q, must be one of %s", format, strings.Join(Formats, ", "))
+}
+
+func (e encoder) Compress(content string) (string, string, bool) {
+	v, ok := parse(content)
+	if !ok {
+		return content, "", false
+	}
+	out, ok := e.encode(v, e.opts)
+	if !ok {
+		return content, "", false
+	}
+	return out, e.name, true
+}
+
+// auto encodes content in every format that applies and keeps the smallest
+// output, provided it is smaller than content itself.
+type auto struct {
+	opts Options
+}
+
+func (a auto) Compress(content string) (string, string, bool) {
+	v, ok := parse(content)
+	if !ok {
+		return content, "", false
+	}
+	best, format := "", ""
+	for _, e := range encoders {
+		out, ok := e.encode(v, a.opts)
+		if ok && (format == "" || len(out) < len(best)) {
+			best, format = out, e.name
+		}
+	}
+	if format == "" || len(best) >= len(strings.TrimSpace(content)) {
+		return content, "", false
+	}
+	return best, format, true
+}
+
+// parse decodes content if it is a JSON object or array.
+func parse(content string) (any, bool) {
+	content = strings.TrimSpace(content)
+	if content == "" {
+		return nil, false
+	}
+	v, err := decode([]byte(content))
+	if err != nil {
+		return nil, false
+	}
+	switch v.(type) {
+	case *object, []any:
+		return v, true
+	}
+	return nil, false
+}
@@ -0,0 +1,178 @@
+package compress
+
+import (
+	"strings"
+	"testing"
+)
+
+const podList = `{"kind": "PodList", "items": [
+	{"name": "pod-a", "ready": true, "restarts": 0, "note": "a, b"},
+	{"name": "pod-b", "ready": false, "restarts": 3, "note": null}
+]}`
+
+func compressWith(t *testing.T, format, input string) (string, bool) {
+	t.Helper()
+	c, err := New(format, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, used, ok := c.Compress(input)
+	if ok && used != format && format != FormatAuto {
+		t.Errorf("expected format %q reported, got %q", format, used)
+	}
+	return out, ok
+}
+
+func TestNew_UnknownFormat(t *testing.T) {
+	if _, err := New("xml", Options{}); err == nil || !strings.Contains(err.Error(), "markdown, csv") {
+		t.Errorf("expected unknown format to list the valid ones, got %v", err)
+	}
+	if _, err := New("", Options{}); err != nil {
+		t.Errorf("expected empty format to mean markdown, got %v", err)
+	}
+}
+
+func TestCSV(t *testing.T) {
+	out, ok := compressWith(t, FormatCSV, podList)
+	if !ok {
+		t.Fatal("expected conversion to happen")
+	}
+	want := "kind: PodList\n\nname,ready,restarts,note\npod-a,true,0,\"a, b\"\npod-b,false,3,\n"
+	if out != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, out)
+	}
+	if _, ok := compressWith(t, FormatCSV, `{"name": "foo"}`); ok {
+		t.Error("expected no conversion for a single object")
+	}
+}
+
+func TestYAML(t *testing.T) {
+	out, ok := compressWith(t, FormatYAML, `{"name": "svc", "port": 8080, "ratio": 0.5, "zip": "01234", "spec": {"ports": [80, 443], "selector": {}}}`)
+	if !ok {
+		t.Fatal("expected conversion to happen")
+	}
+	want := "name: svc\nport: 8080\nratio: 0.5\nzip: \"01234\"\nspec:\n  ports:\n    - 80\n    - 443\n  selector: {}\n"
+	if out != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, out)
+	}
+}
+
+func TestKeyValue(t *testing.T) {
+	out, ok := compressWith(t, FormatKeyValue, `{"name": "svc", "labels": {"app": "web", "tier": ""}, "ports": [80, 443], "owner": null, "conditions": [{"type": "Ready"}]}`)
+	if !ok {
+		t.Fatal("expected conversion to happen")
+	}
+	want := "name=svc\nlabels.app=web\nlabels.tier=\"\"\nports=80,443\nowner=null\nconditions.0.type=Ready\n"
+	if out != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, out)
+	}
+	if _, ok := compressWith(t, FormatKeyValue, `[{"name": "a"}]`); ok {
+		t.Error("expected no conversion for an array")
+	}
+}
+
+func TestTOON(t *testing.T) {
+	out, ok := compressWith(t, FormatTOON, `{"kind": "PodList", "items": [
+		{"name": "pod-a", "ip": "10.0.0.1", "ready": true},
+		{"name": "pod-b", "ip": null, "ready": false}
+	], "meta": {"tags": ["a", "true", "-x"], "mixed": [1, {"id": 2, "ports": [80]}]}}`)
+	if !ok {
+		t.Fatal("expected conversion to happen")
+	}
+	want := "kind: PodList\n" +
+		"items[2]{name,ip,ready}:\n" +
+		"  pod-a,10.0.0.1,true\n" +
+		"  pod-b,null,false\n" +
+		"meta:\n" +
+		"  tags[3]: a,\"true\",\"-x\"\n" +
+		"  mixed[2]:\n" +
+		"    - 1\n" +
+		"    - id: 2\n" +
+		"      ports[1]: 80\n"
+	if out != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, out)
+	}
+}
+
+func TestAuto(t *testing.T) {
+	out, ok := compressWith(t, FormatAuto, podList)
+	if !ok {
+		t.Fatal("expected conversion to happen")
+	}
+	for _, format := range []string{FormatMarkdown, FormatYAML, FormatKeyValue, FormatTOON} {
+		if other, ok := compressWith(t, format, podList); ok && len(other) < len(out) {
+			t.Errorf("expected auto to pick the smallest output, %s is smaller:\n%s", format, other)
+		}
+	}
+
+	// Nothing is smaller than empty JSON
+	for _, input := range []string{`[]`, `{}`} {
+		if out, ok := compressWith(t, FormatAuto, input); ok {
+			t.Errorf("expected no conversion when no format is smaller, got:\n%s", out)
+		}
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	input := `[
+		{"metadata": {"name": "pod-a", "labels": {}}, "status": {"phase": "Running", "conditions": [{"type": "Ready", "status": "True"}, {"type": "Init", "status": "True"}]}},
+		{"metadata": {"name": "pod-b", "labels": {"app": "web"}}, "status": {"phase": "Pending", "conditions": [{"type": "Ready", "status": "False"}]}}
+	]`
+	c, err := New(FormatMarkdown, Options{FlattenDepth: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _, ok := c.Compress(input)
+	if !ok {
+		t.Fatal("expected conversion to happen")
+	}
+	want := "| metadata.name | metadata.labels | status.phase | metadata.labels.app |\n" +
+		"|---------------|-----------------|--------------|---------------------|\n" +
+		"| pod-a | {} | Running |   |\n" +
+		"| pod-b |   | Pending | web |\n" +
+		"\n" +
+		"status.conditions:\n" +
+		"| # | type | status |\n" +
+		"|---|------|--------|\n" +
+		"| 0.0 | Ready | True |\n" +
+		"| 0.1 | Init | True |\n" +
+		"| 1.0 | Ready | False |\n"
+	if out != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, out)
+	}
+
+	// One level flattens metadata but keeps deeper values collapsed
+	c, _ = New(FormatMarkdown, Options{FlattenDepth: 1})
+	out, _, _ = c.Compress(input)
+	if !strings.Contains(out, "| pod-b | {...} | Pending | [{...} x 1] |") {
+		t.Errorf("expected depth 1 to collapse labels and conditions, got:\n%s", out)
+	}
+}
+
+func TestFlatten_SingleObject(t *testing.T) {
+	input := `{"kind": "Pod", "metadata": {"name": "pod-a"}, "spec": {"containers": [{"name": "app", "ports": [{"port": 80}]}]}}`
+	if _, ok := compressWith(t, FormatMarkdown, input); ok {
+		t.Fatal("expected no conversion for a single object without flattening")
+	}
+
+	c, _ := New(FormatMarkdown, Options{FlattenDepth: 3})
+	out, _, ok := c.Compress(input)
+	if !ok {
+		t.Fatal("expected conversion to happen")
+	}
+	want := "kind: Pod\n" +
+		"metadata.name: pod-a\n" +
+		"\n" +
+		"spec.containers:\n" +
+		"| name |\n" +
+		"|------|\n" +
+		"| app |\n" +
+		"\n" +
+		"spec.containers.ports:\n" +
+		"| # | port |\n" +
+		"|---|------|\n" +
+		"| 0.0 | 80 |\n"
+	if out != want {
+		t.Errorf("expected:\n%s\ngot:\n%s", want, out)
+	}
+}
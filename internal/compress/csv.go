@@ -0,0 +1,29 @@
+package compress
+
+import (
+	"encoding/csv"
+	"strings"
+)
+
+func encodeCSV(v any, opts Options) (string, bool) {
+	return tabular(v, opts, renderCSV)
+}
+
+// renderCSV writes objects as CSV with a header row of their columns.
+// Cells are rendered as in markdown tables.
+func renderCSV(objects []*object) string {
+	columns := collectColumns(objects)
+
+	var sb strings.Builder
+	w := csv.NewWriter(&sb)
+	_ = w.Write(columns)
+	row := make([]string, len(columns))
+	for _, obj := range objects {
+		for i, col := range columns {
+			row[i] = renderValue(obj.values[col])
+		}
+		_ = w.Write(row)
+	}
+	w.Flush()
+	return sb.String()
+}
@@ -0,0 +1,79 @@
+package compress
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// object is a decoded JSON object that keeps its keys in source order.
+type object struct {
+	keys   []string
+	values map[string]any
+}
+
+// decode parses JSON like json.Unmarshal into an any, except that objects
+// decode to *object so tables and headers can follow the upstream's key
+// order, and numbers to json.Number so they are written as the upstream
+// wrote them. A repeated key keeps its first position and its last value.
+func decode(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	v, err := decodeValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid data after top-level value")
+	}
+	return v, nil
+}
+
+func decodeValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := &object{values: make(map[string]any)}
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			// The decoder only yields string tokens in key position
+			key := tok.(string)
+			val, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			if _, dup := obj.values[key]; !dup {
+				obj.keys = append(obj.keys, key)
+			}
+			obj.values[key] = val
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return obj, nil
+
+	case json.Delim('['):
+		arr := []any{}
+		for dec.More() {
+			val, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, val)
+		}
+		if _, err := dec.Token(); err != nil {
+			return nil, err
+		}
+		return arr, nil
+
+	default:
+		return tok, nil
+	}
+}
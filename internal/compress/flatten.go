@@ -0,0 +1,133 @@
+package compress
+
+import (
+	"strconv"
+	"strings"
+)
+
+// subTable is an array of objects nested in the rows of a table, rendered
+// after it. Rows carry a "#" column with the index path of the element
+// they came from, such as 0.1 for the second element in the first row.
+type subTable struct {
+	path string
+	rows []*object
+}
+
+// flattener flattens nested objects into dotted keys and collects nested
+// arrays of objects as sub-tables, in order of first appearance.
+type flattener struct {
+	tables []*subTable
+	byPath map[string]*subTable
+}
+
+// flattened renders v with up to depth levels of nesting flattened: an
+// array of objects as a table and a single object as "key: value" lines,
+// each followed by their sub-tables.
+func flattened(v any, depth int, render func([]*object) string) (string, bool) {
+	f := &flattener{byPath: make(map[string]*subTable)}
+	var sb strings.Builder
+
+	switch v := v.(type) {
+	case []any:
+		objects, ok := toObjectSlice(v)
+		if !ok {
+			return "", false
+		}
+		rows := make([]*object, len(objects))
+		for i, obj := range objects {
+			rows[i] = newObject()
+			f.flatten(rows[i], obj, "", "", strconv.Itoa(i), depth)
+		}
+		sb.WriteString(render(rows))
+
+	case *object:
+		if len(v.keys) == 0 {
+			return "", false
+		}
+		fields := newObject()
+		f.flatten(fields, v, "", "", "", depth)
+		for _, key := range fields.keys {
+			sb.WriteString(key)
+			sb.WriteString(": ")
+			sb.WriteString(renderValue(fields.values[key]))
+			sb.WriteString("\n")
+		}
+
+	default:
+		return "", false
+	}
+
+	for _, t := range f.tables {
+		if sb.Len() > 0 {
+			sb.WriteString("\n")
+		}
+		sb.WriteString(t.path)
+		sb.WriteString(":\n")
+		sb.WriteString(render(t.rows))
+	}
+	return sb.String(), true
+}
+
+// flatten adds the fields of obj to out, prefixing keys with prefix.
+// tablePath names the table out belongs to and rowKey is its index path;
+// both are empty for a top-level object, whose elements are not keyed.
+func (f *flattener) flatten(out, obj *object, prefix, tablePath, rowKey string, remaining int) {
+	for _, key := range obj.keys {
+		name := prefix + key
+		val := obj.values[key]
+		if remaining > 0 {
+			switch val := val.(type) {
+			case *object:
+				if len(val.keys) == 0 {
+					// Nothing is hidden, unlike the {...} of a collapsed object
+					out.set(name, "{}")
+					continue
+				}
+				f.flatten(out, val, name+".", tablePath, rowKey, remaining-1)
+				continue
+			case []any:
+				if children, ok := toObjectSlice(val); ok {
+					path := name
+					if tablePath != "" {
+						path = tablePath + "." + name
+					}
+					t := f.table(path)
+					for i, child := range children {
+						childKey := strconv.Itoa(i)
+						row := newObject()
+						if rowKey != "" {
+							childKey = rowKey + "." + childKey
+							row.set("#", childKey)
+						}
+						f.flatten(row, child, "", path, childKey, remaining-1)
+						t.rows = append(t.rows, row)
+					}
+					continue
+				}
+			}
+		}
+		out.set(name, val)
+	}
+}
+
+func (f *flattener) table(path string) *subTable {
+	t, ok := f.byPath[path]
+	if !ok {
+		t = &subTable{path: path}
+		f.byPath[path] = t
+		f.tables = append(f.tables, t)
+	}
+	return t
+}
+
+func newObject() *object {
+	return &object{values: make(map[string]any)}
+}
+
+// set adds or replaces a field, keeping the position of an existing key.
+func (o *object) set(key string, val any) {
+	if _, ok := o.values[key]; !ok {
+		o.keys = append(o.keys, key)
+	}
+	o.values[key] = val
+}
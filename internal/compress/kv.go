@@ -0,0 +1,89 @@
+package compress
+
+import (
+	"strconv"
+	"strings"
+)
+
+// encodeKeyValue writes a single object as one "path=value" line per leaf,
+// with nested keys joined by dots and array elements addressed by index.
+// Arrays of scalars stay on one line, comma-separated.
+func encodeKeyValue(v any, _ Options) (string, bool) {
+	obj, ok := v.(*object)
+	if !ok || len(obj.keys) == 0 {
+		return "", false
+	}
+	var sb strings.Builder
+	writeKeyValues(&sb, "", obj)
+	return sb.String(), true
+}
+
+func writeKeyValues(sb *strings.Builder, path string, v any) {
+	switch v := v.(type) {
+	case *object:
+		if len(v.keys) == 0 {
+			writeKeyValue(sb, path, "{}")
+			return
+		}
+		for _, key := range v.keys {
+			child := key
+			if path != "" {
+				child = path + "." + key
+			}
+			writeKeyValues(sb, child, v.values[key])
+		}
+
+	case []any:
+		if len(v) == 0 {
+			writeKeyValue(sb, path, "[]")
+			return
+		}
+		if items, ok := scalarItems(v, kvScalar); ok {
+			writeKeyValue(sb, path, strings.Join(items, ","))
+			return
+		}
+		for i, item := range v {
+			writeKeyValues(sb, path+"."+strconv.Itoa(i), item)
+		}
+
+	default:
+		writeKeyValue(sb, path, kvScalar(v))
+	}
+}
+
+func writeKeyValue(sb *strings.Builder, path, value string) {
+	sb.WriteString(path)
+	sb.WriteString("=")
+	sb.WriteString(value)
+	sb.WriteString("\n")
+}
+
+// kvScalar renders a scalar, quoting strings that would otherwise read
+// ambiguously.
+func kvScalar(v any) string {
+	s, ok := v.(string)
+	if !ok {
+		if v == nil {
+			return "null"
+		}
+		return renderValue(v)
+	}
+	if s == "" || strings.TrimSpace(s) != s || strings.ContainsAny(s, ",=\"\\\n\r") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// scalarItems renders every item of arr with render, or reports false if
+// any item is an object or array.
+func scalarItems(arr []any, render func(any) string) ([]string, bool) {
+	items := make([]string, len(arr))
+	for i, item := range arr {
+		switch item.(type) {
+		case *object, []any:
+			return nil, false
+		}
+		items[i] = render(item)
+	}
+	return items, true
+}
@@ -10,51 +10,60 @@ import (
 // Returns the converted string and true if conversion happened,
 // or the original string and false if the input is not convertible.
 func CompressJSONToMarkdown(content string) (string, bool) {
-	content = strings.TrimSpace(content)
-	if content == "" {
-		return content, false
+	out, _, ok := encoder{name: FormatMarkdown, encode: encodeMarkdown}.Compress(content)
+	if !ok {
+		return strings.TrimSpace(content), false
 	}
+	return out, true
+}
 
-	// Try to parse as JSON
-	var raw any
-	if err := json.Unmarshal([]byte(content), &raw); err != nil {
-		return content, false
-	}
+func encodeMarkdown(v any, opts Options) (string, bool) {
+	return tabular(v, opts, renderTable)
+}
 
-	switch v := raw.(type) {
+// tabular renders the rows of a table found in v: v itself if it is an
+// array of objects, or else the first array of objects in a wrapper object,
+// whose other fields become "key: value" lines above the table. With
+// opts.FlattenDepth set, nested data is flattened instead.
+func tabular(v any, opts Options, render func([]*object) string) (string, bool) {
+	if opts.FlattenDepth > 0 {
+		return flattened(v, opts.FlattenDepth, render)
+	}
+	switch v := v.(type) {
 	case []any:
 		// Direct array of objects
 		objects, ok := toObjectSlice(v)
 		if !ok {
-			return content, false
+			return "", false
 		}
-		return renderTable(objects), true
+		return render(objects), true
 
-	case map[string]any:
-		// Wrapper object: find a top-level key whose value is an array of objects
+	case *object:
+		// Wrapper object: find the first top-level key whose value is an array of objects
 		var arrayKey string
-		var objects []map[string]any
+		var objects []*object
 		var scalarFields []scalarField
 
-		for key, val := range v {
-			if arr, ok := val.([]any); ok {
+		for _, key := range v.keys {
+			if arr, ok := v.values[key].([]any); ok {
 				if objs, ok := toObjectSlice(arr); ok {
 					arrayKey = key
 					objects = objs
+					break
 				}
 			}
 		}
 
 		if arrayKey == "" {
-			return content, false
+			return "", false
 		}
 
 		// Collect scalar (non-array) fields for the header
-		for key, val := range v {
+		for _, key := range v.keys {
 			if key == arrayKey {
 				continue
 			}
-			scalarFields = append(scalarFields, scalarField{key: key, value: renderValue(val)})
+			scalarFields = append(scalarFields, scalarField{key: key, value: renderValue(v.values[key])})
 		}
 
 		var sb strings.Builder
@@ -67,11 +76,11 @@ func CompressJSONToMarkdown(content string) (string, bool) {
 		if len(scalarFields) > 0 {
 			sb.WriteString("\n")
 		}
-		sb.WriteString(renderTable(objects))
+		sb.WriteString(render(objects))
 		return sb.String(), true
 
 	default:
-		return content, false
+		return "", false
 	}
 }
 
@@ -80,14 +89,14 @@ type scalarField struct {
 	value string
 }
 
-// toObjectSlice checks if all items in a slice are maps and returns them.
-func toObjectSlice(arr []any) ([]map[string]any, bool) {
+// toObjectSlice checks if all items in a slice are objects and returns them.
+func toObjectSlice(arr []any) ([]*object, bool) {
 	if len(arr) == 0 {
 		return nil, false
 	}
-	objects := make([]map[string]any, 0, len(arr))
+	objects := make([]*object, 0, len(arr))
 	for _, item := range arr {
-		obj, ok := item.(map[string]any)
+		obj, ok := item.(*object)
 		if !ok {
 			return nil, false
 		}
@@ -97,7 +106,7 @@ func toObjectSlice(arr []any) ([]map[string]any, bool) {
 }
 
 // renderTable converts a slice of objects into a markdown table string.
-func renderTable(objects []map[string]any) string {
+func renderTable(objects []*object) string {
 	if len(objects) == 0 {
 		return ""
 	}
@@ -129,7 +138,7 @@ func renderTable(objects []map[string]any) string {
 		sb.WriteString("|")
 		for _, col := range columns {
 			sb.WriteString(" ")
-			val, exists := obj[col]
+			val, exists := obj.values[col]
 			if !exists || val == nil {
 				sb.WriteString(" ")
 			} else {
@@ -145,23 +154,21 @@ func renderTable(objects []map[string]any) string {
 
 // collectColumns returns ordered column names: keys from first object in order,
 // then any additional keys from remaining objects.
-func collectColumns(objects []map[string]any) []string {
+func collectColumns(objects []*object) []string {
 	seen := make(map[string]bool)
 	var columns []string
 
-	// Keys from first object (preserving JSON parse order — Go maps don't guarantee
-	// order, but in practice encoding/json preserves insertion order for map[string]any).
-	// For deterministic ordering, we re-parse the first object.
-	for key := range objects[0] {
+	// Keys from first object, in source order
+	for _, key := range objects[0].keys {
 		if !seen[key] {
 			seen[key] = true
 			columns = append(columns, key)
 		}
 	}
 
-	// Additional keys from remaining objects
+	// Additional keys from remaining objects, in order of first appearance
 	for _, obj := range objects[1:] {
-		for key := range obj {
+		for _, key := range obj.keys {
 			if !seen[key] {
 				seen[key] = true
 				columns = append(columns, key)
@@ -177,12 +184,8 @@ func renderValue(val any) string {
 	switch v := val.(type) {
 	case string:
 		return v
-	case float64:
-		// Render integers without decimal point
-		if v == float64(int64(v)) {
-			return fmt.Sprintf("%d", int64(v))
-		}
-		return fmt.Sprintf("%g", v)
+	case json.Number:
+		return v.String()
 	case bool:
 		if v {
 			return "true"
@@ -192,7 +195,7 @@ func renderValue(val any) string {
 		return ""
 	case []any:
 		return renderArray(v)
-	case map[string]any:
+	case *object:
 		return "{...}"
 	default:
 		return fmt.Sprintf("%v", v)
@@ -208,7 +211,7 @@ func renderArray(arr []any) string {
 	// Check if it's an array of objects
 	allObjects := true
 	for _, item := range arr {
-		if _, ok := item.(map[string]any); !ok {
+		if _, ok := item.(*object); !ok {
 			allObjects = false
 			break
 		}
@@ -15,8 +15,8 @@ func TestCompressJSONToMarkdown_FlatArray(t *testing.T) {
 	if !converted {
 		t.Fatal("expected conversion to happen")
 	}
-	if !strings.Contains(result, "| name ") {
-		t.Errorf("expected header with 'name', got:\n%s", result)
+	if !strings.HasPrefix(result, "| name | namespace | type |\n") {
+		t.Errorf("expected header in source key order, got:\n%s", result)
 	}
 	if !strings.Contains(result, "| svc-a ") {
 		t.Errorf("expected row with 'svc-a', got:\n%s", result)
@@ -179,9 +179,9 @@ func TestCompressJSONToMarkdown_MixedKeys(t *testing.T) {
 	if !converted {
 		t.Fatal("expected conversion to happen")
 	}
-	// Should have all three columns: name, cpu, memory
-	if !strings.Contains(result, "name") || !strings.Contains(result, "cpu") || !strings.Contains(result, "memory") {
-		t.Errorf("expected all keys as columns, got:\n%s", result)
+	// Columns of the first object, then new keys in order of first appearance
+	if !strings.HasPrefix(result, "| name | cpu | memory |\n") {
+		t.Errorf("expected all keys as columns in first-seen order, got:\n%s", result)
 	}
 }
 
@@ -240,3 +240,56 @@ func TestCompressJSONToMarkdown_WrapperWithScalarArrayOnly(t *testing.T) {
 		t.Errorf("expected original string returned, got: %s", result)
 	}
 }
+
+func TestCompressJSONToMarkdown_SourceOrder(t *testing.T) {
+	input := `{
+		"total": 2,
+		"kind": "PodList",
+		"items": [
+			{"zone": "b", "name": "pod-a", "age": "1d"},
+			{"zone": "a", "name": "pod-b", "age": "2d"}
+		],
+		"continue": ""
+	}`
+	want := "total: 2\n" +
+		"kind: PodList\n" +
+		"continue: \n" +
+		"\n" +
+		"| zone | name | age |\n" +
+		"|------|------|-----|\n" +
+		"| b | pod-a | 1d |\n" +
+		"| a | pod-b | 2d |\n"
+
+	// Map iteration order varies between runs, so repeat to catch it
+	for range 20 {
+		result, converted := CompressJSONToMarkdown(input)
+		if !converted {
+			t.Fatal("expected conversion to happen")
+		}
+		if result != want {
+			t.Fatalf("expected upstream key order, got:\n%s", result)
+		}
+	}
+}
+
+func TestCompressJSONToMarkdown_FirstArrayWins(t *testing.T) {
+	input := `{"pods": [{"name": "a"}], "nodes": [{"host": "n1"}]}`
+	result, converted := CompressJSONToMarkdown(input)
+	if !converted {
+		t.Fatal("expected conversion to happen")
+	}
+	if !strings.Contains(result, "| name |") || !strings.HasPrefix(result, "nodes: [{...} x 1]\n") {
+		t.Errorf("expected the first array of objects as the table, got:\n%s", result)
+	}
+}
+
+func TestCompressJSONToMarkdown_TrailingData(t *testing.T) {
+	input := `[{"name": "a"}] [{"name": "b"}]`
+	result, converted := CompressJSONToMarkdown(input)
+	if converted {
+		t.Fatal("expected no conversion for trailing data")
+	}
+	if result != input {
+		t.Errorf("expected original string returned, got: %s", result)
+	}
+}
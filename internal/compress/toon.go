@@ -0,0 +1,159 @@
+package compress
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// encodeTOON writes v in an indented, TOON-style notation: objects as
+// "key: value" lines nested by indentation, arrays with their length as
+// "key[N]", and arrays of objects sharing the same scalar fields as a
+// table whose header "key[N]{a,b}:" is followed by one row per object.
+func encodeTOON(v any, _ Options) (string, bool) {
+	var sb strings.Builder
+	switch v := v.(type) {
+	case *object:
+		if len(v.keys) == 0 {
+			return "", false
+		}
+		writeTOONObject(&sb, v, 0)
+	case []any:
+		writeTOONArray(&sb, "", v, 0)
+	default:
+		return "", false
+	}
+	return sb.String(), true
+}
+
+func writeTOONObject(sb *strings.Builder, obj *object, depth int) {
+	for _, key := range obj.keys {
+		writeTOONField(sb, toonKey(key), obj.values[key], depth)
+	}
+}
+
+func writeTOONField(sb *strings.Builder, key string, v any, depth int) {
+	indent := strings.Repeat("  ", depth)
+	switch v := v.(type) {
+	case *object:
+		sb.WriteString(indent + key + ":\n")
+		writeTOONObject(sb, v, depth+1)
+	case []any:
+		writeTOONArray(sb, key, v, depth)
+	default:
+		sb.WriteString(indent + key + ": " + toonScalar(v) + "\n")
+	}
+}
+
+func writeTOONArray(sb *strings.Builder, key string, arr []any, depth int) {
+	indent := strings.Repeat("  ", depth)
+	header := indent + key + "[" + strconv.Itoa(len(arr)) + "]"
+
+	if items, ok := scalarItems(arr, toonScalar); ok {
+		if len(items) == 0 {
+			sb.WriteString(header + ":\n")
+			return
+		}
+		sb.WriteString(header + ": " + strings.Join(items, ",") + "\n")
+		return
+	}
+
+	if columns, ok := uniformColumns(arr); ok {
+		keys := make([]string, len(columns))
+		for i, col := range columns {
+			keys[i] = toonKey(col)
+		}
+		sb.WriteString(header + "{" + strings.Join(keys, ",") + "}:\n")
+		row := make([]string, len(columns))
+		for _, item := range arr {
+			obj := item.(*object)
+			for i, col := range columns {
+				row[i] = toonScalar(obj.values[col])
+			}
+			sb.WriteString(indent + "  " + strings.Join(row, ",") + "\n")
+		}
+		return
+	}
+
+	sb.WriteString(header + ":\n")
+	for _, item := range arr {
+		writeTOONListItem(sb, item, depth+1)
+	}
+}
+
+// writeTOONListItem writes an element of a mixed array as a "- " item. The
+// first line of a nested object or array goes on the hyphen line.
+func writeTOONListItem(sb *strings.Builder, item any, depth int) {
+	indent := strings.Repeat("  ", depth)
+	var b strings.Builder
+	switch v := item.(type) {
+	case *object:
+		if len(v.keys) == 0 {
+			sb.WriteString(indent + "-\n")
+			return
+		}
+		writeTOONObject(&b, v, depth+1)
+	case []any:
+		writeTOONArray(&b, "", v, depth+1)
+	default:
+		sb.WriteString(indent + "- " + toonScalar(v) + "\n")
+		return
+	}
+	sb.WriteString(indent + "- " + strings.TrimPrefix(b.String(), indent+"  "))
+}
+
+// uniformColumns returns the columns of arr if it is a non-empty array of
+// objects that all have the same keys and only scalar values.
+func uniformColumns(arr []any) ([]string, bool) {
+	objects, ok := toObjectSlice(arr)
+	if !ok || len(objects[0].keys) == 0 {
+		return nil, false
+	}
+	columns := objects[0].keys
+	for _, obj := range objects {
+		if len(obj.keys) != len(columns) {
+			return nil, false
+		}
+		for _, col := range columns {
+			val, exists := obj.values[col]
+			if !exists {
+				return nil, false
+			}
+			switch val.(type) {
+			case *object, []any:
+				return nil, false
+			}
+		}
+	}
+	return columns, true
+}
+
+var bareTOONKey = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+func toonKey(key string) string {
+	if bareTOONKey.MatchString(key) {
+		return key
+	}
+	return strconv.Quote(key)
+}
+
+// toonScalar renders a scalar, quoting strings that would otherwise read as
+// another type or break the line structure.
+func toonScalar(v any) string {
+	s, ok := v.(string)
+	if !ok {
+		if v == nil {
+			return "null"
+		}
+		return renderValue(v)
+	}
+	switch {
+	case s == "", strings.TrimSpace(s) != s, s == "true", s == "false", s == "null",
+		strings.HasPrefix(s, "-"), strings.ContainsAny(s, ",:\"\\[]{}#\n\r\t"):
+		return strconv.Quote(s)
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -1,15 +1,24 @@
 package config
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/url"
 	"os"
-	"strconv"
+	"regexp"
 	"strings"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/compress"
+	"github.com/isitobservable/mcp-otel-proxy/internal/paging"
 )
 
-// Config holds all proxy configuration loaded from environment variables.
+// Config holds all proxy configuration, loaded from an optional config file
+// with environment variable overrides.
 type Config struct {
-	UpstreamURL        string
+	// Path is the config file the configuration was loaded from, if any.
+	Path string
+
+	Upstreams          []Upstream
 	ProxyPort          string
 	OTELEndpoint       string
 	OTELInsecure       bool
@@ -18,62 +27,630 @@ type Config struct {
 	ContextPropagation bool
 	CapturePayload     bool
 	CompressResponses  bool
-	SessionTTLSeconds  int
-	ResourceAttributes string
+	// CompressFormat is the default compression format, one of
+	// compress.Formats.
+	CompressFormat string
+	// CompressFlattenDepth is the default compress.Options.FlattenDepth.
+	CompressFlattenDepth int
+	// TokenizerVocabFile is a tiktoken-format BPE vocabulary used to count
+	// the tokens compression saves; empty uses a built-in approximation.
+	TokenizerVocabFile string
+	// PromoteStructuredContent copies a JSON object in the text of a tool
+	// result to its structuredContent when the upstream set none.
+	// ValidateOutputSchema checks structuredContent against the tool's
+	// outputSchema from tools/list.
+	PromoteStructuredContent bool
+	ValidateOutputSchema     bool
+	// ValidateInputSchema is what the proxy does with tools/call arguments
+	// that fail the tool's inputSchema from tools/list: InputValidationOff,
+	// InputValidationWarn or InputValidationReject.
+	ValidateInputSchema string
+	// MaxResponseTokens and MaxResponseBytes are the default page budget of
+	// tool results; 0 leaves results whole. Longer results are split into
+	// pages kept in a store of PageStoreSizeMB for PageStoreRetentionSeconds.
+	MaxResponseTokens         int
+	MaxResponseBytes          int
+	PageStoreSizeMB           int
+	PageStoreRetentionSeconds int
+	SessionTTLSeconds         int
+	ResourceAttributes        string
+
+	// OTELProtocol is OTLPProtocolGRPC or OTLPProtocolHTTP. The per-signal
+	// endpoints override OTELEndpoint for one signal.
+	OTELProtocol        string
+	OTELTracesEndpoint  string
+	OTELMetricsEndpoint string
+	OTELLogsEndpoint    string
+	OTELHeaders         map[string]string
+	// OTELCompression is "gzip" or "none".
+	OTELCompression string
+	// OTELCertificate is a CA bundle for the collector's certificate; the
+	// client certificate and key authenticate the proxy to it.
+	OTELCertificate       string
+	OTELClientCertificate string
+	OTELClientKey         string
+
+	// TracesExporter and LogsExporter are ExporterOTLP, ExporterFile,
+	// ExporterConsole or ExporterNone. MetricsExporters lists any of those
+	// and ExporterPrometheus; it is empty when metric export is disabled.
+	TracesExporter   string
+	MetricsExporters []string
+	LogsExporter     string
+	// AdminPort serves the Prometheus /metrics endpoint and the policy
+	// approval endpoints.
+	AdminPort string
+	// FileExporterDir receives traces.jsonl, metrics.jsonl and logs.jsonl
+	// from the file exporter. Each file is rotated once it reaches
+	// FileExporterMaxSizeMB, keeping FileExporterMaxBackups old files.
+	FileExporterDir        string
+	FileExporterMaxSizeMB  int
+	FileExporterMaxBackups int
+
+	// SSEBufferSize is the number of SSE events kept per session for
+	// Last-Event-ID resumption; 0 disables resumption by the proxy.
+	SSEBufferSize             int
+	SSEBufferRetentionSeconds int
+
+	// SessionInjection adds a client's cached Mcp-Session-Id to requests
+	// that omit it, keyed by the identity ClientIdentity resolves.
+	SessionInjection     bool
+	ClientIdentity       string
+	ClientIdentityHeader string
+	TrustedProxies       []string
+
+	// TLS serves the proxy over HTTPS when TLSCertFile is set. With
+	// TLSClientCAFile, client certificates signed by that CA are verified.
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+
+	Policy Policy
 }
 
-func Load() (*Config, error) {
-	upstreamURL := os.Getenv("UPSTREAM_URL")
-	if upstreamURL == "" {
-		return nil, fmt.Errorf("UPSTREAM_URL environment variable is required")
+// Policy decides which client requests are forwarded upstream. Rules are
+// evaluated in order and the first match applies; requests matching no rule
+// get Default.
+type Policy struct {
+	Default string
+	Rules   []PolicyRule
+	// ApprovalTTLSeconds is how long a request held for approval waits, and
+	// how long a granted approval waits for the client to retry.
+	ApprovalTTLSeconds int
+	// Approvers maps operator names to the bearer tokens that authenticate
+	// them on the approval endpoints.
+	Approvers map[string]string
+}
+
+// PolicyRule matches requests on every non-empty field. Methods and Tools
+// are glob patterns as understood by path.Match. Resources, Clients and
+// the values of Arguments are glob patterns in which * also matches /.
+type PolicyRule struct {
+	Name      string
+	Action    string
+	Upstreams []string
+	Methods   []string
+	Tools     []string
+	// Resources match the URI of resources/read and resource subscriptions.
+	Resources []string
+	// Clients match the client identity resolved by ClientIdentity.
+	Clients []string
+	// Arguments match tools/call arguments, keyed by a dotted path such as
+	// filter.namespace.
+	Arguments map[string]string
+}
+
+// Policy actions.
+const (
+	PolicyAllow = "allow"
+	PolicyDeny  = "deny"
+	// PolicyRequireApproval holds a request until an operator approves it
+	// on the admin port; the client then retries it.
+	PolicyRequireApproval = "require-approval"
+)
+
+// Enabled reports whether the policy can affect any request.
+func (p *Policy) Enabled() bool {
+	return p.Default != PolicyAllow || len(p.Rules) > 0
+}
+
+// RequiresApproval reports whether any request can be held for approval.
+func (p *Policy) RequiresApproval() bool {
+	if p.Default == PolicyRequireApproval {
+		return true
+	}
+	for _, r := range p.Rules {
+		if r.Action == PolicyRequireApproval {
+			return true
+		}
+	}
+	return false
+}
+
+// Upstream describes one upstream MCP server and the path prefix routed to it.
+// An upstream is reached over HTTP at URL, or, when Command is set, is a
+// local stdio MCP server launched by the proxy.
+type Upstream struct {
+	Name              string
+	URL               string
+	Command           string
+	Args              []string
+	Env               map[string]string
+	PathPrefix        string
+	CompressResponses bool
+	CompressFormat    string
+	// CompressFlattenDepth is the compress.Options.FlattenDepth.
+	CompressFlattenDepth     int
+	PromoteStructuredContent bool
+	ValidateOutputSchema     bool
+	ValidateInputSchema      string
+	// MaxResponseTokens and MaxResponseBytes are the page budget of tool
+	// results; 0 sets no limit.
+	MaxResponseTokens int
+	MaxResponseBytes  int
+	TimeoutSeconds    int
+	Headers           map[string]string
+	// Transport is TransportStreamableHTTP or TransportSSE.
+	Transport string
+	// Tools holds per-tool overrides keyed by tool name.
+	Tools map[string]Tool
+}
+
+// Tool holds settings that override the upstream's for a single tool.
+type Tool struct {
+	// CompressResponses overrides Upstream.CompressResponses when set.
+	CompressResponses *bool
+	// CompressFormat overrides Upstream.CompressFormat when not empty.
+	CompressFormat string
+	// CompressFlattenDepth overrides Upstream.CompressFlattenDepth when set.
+	CompressFlattenDepth *int
+	// PromoteStructuredContent and ValidateOutputSchema override the
+	// upstream's when set.
+	PromoteStructuredContent *bool
+	ValidateOutputSchema     *bool
+	// ValidateInputSchema overrides Upstream.ValidateInputSchema when not
+	// empty.
+	ValidateInputSchema string
+	// MaxResponseTokens and MaxResponseBytes override the upstream's when set.
+	MaxResponseTokens *int
+	MaxResponseBytes  *int
+}
+
+// CompressTool reports whether responses of the named tool are compressed.
+func (u Upstream) CompressTool(name string) bool {
+	if t, ok := u.Tools[name]; ok && t.CompressResponses != nil {
+		return *t.CompressResponses
 	}
+	return u.CompressResponses
+}
 
-	upstreamURL = strings.TrimRight(upstreamURL, "/")
+// ToolCompressFormat returns the compression format for the named tool.
+func (u Upstream) ToolCompressFormat(name string) string {
+	if t, ok := u.Tools[name]; ok && t.CompressFormat != "" {
+		return t.CompressFormat
+	}
+	return u.CompressFormat
+}
 
-	cfg := &Config{
-		UpstreamURL:        upstreamURL,
-		ProxyPort:          envOrDefault("PROXY_PORT", "8080"),
-		OTELEndpoint:       envOrDefault("OTEL_EXPORTER_OTLP_ENDPOINT", "localhost:4317"),
-		OTELInsecure:       envBoolOrDefault("OTEL_EXPORTER_OTLP_INSECURE", true),
-		ServiceName:        envOrDefault("OTEL_SERVICE_NAME", "mcp-otel-proxy"),
-		LogLevel:           strings.ToLower(envOrDefault("LOG_LEVEL", "info")),
-		ContextPropagation: envBoolOrDefault("CONTEXT_PROPAGATION", true),
-		CapturePayload:     envBoolOrDefault("CAPTURE_PAYLOAD", false),
-		CompressResponses:  envBoolOrDefault("COMPRESS_RESPONSES", false),
-		SessionTTLSeconds:  envIntOrDefault("SESSION_TTL", 3600),
-		ResourceAttributes: os.Getenv("OTEL_RESOURCE_ATTRIBUTES"),
+// ToolCompressOptions returns the compression options for the named tool.
+func (u Upstream) ToolCompressOptions(name string) compress.Options {
+	if t, ok := u.Tools[name]; ok && t.CompressFlattenDepth != nil {
+		return compress.Options{FlattenDepth: *t.CompressFlattenDepth}
 	}
+	return compress.Options{FlattenDepth: u.CompressFlattenDepth}
+}
 
-	return cfg, nil
+// PromoteTool reports whether JSON text in results of the named tool is
+// promoted to structuredContent.
+func (u Upstream) PromoteTool(name string) bool {
+	if t, ok := u.Tools[name]; ok && t.PromoteStructuredContent != nil {
+		return *t.PromoteStructuredContent
+	}
+	return u.PromoteStructuredContent
 }
 
-func envOrDefault(key, defaultVal string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
+// ValidateToolOutput reports whether results of the named tool are checked
+// against its outputSchema.
+func (u Upstream) ValidateToolOutput(name string) bool {
+	if t, ok := u.Tools[name]; ok && t.ValidateOutputSchema != nil {
+		return *t.ValidateOutputSchema
 	}
-	return defaultVal
+	return u.ValidateOutputSchema
 }
 
-func envBoolOrDefault(key string, defaultVal bool) bool {
-	v := os.Getenv(key)
-	if v == "" {
-		return defaultVal
+// ToolInputValidation returns what is done with arguments of the named tool
+// that fail its inputSchema.
+func (u Upstream) ToolInputValidation(name string) string {
+	if t, ok := u.Tools[name]; ok && t.ValidateInputSchema != "" {
+		return t.ValidateInputSchema
 	}
-	b, err := strconv.ParseBool(v)
-	if err != nil {
-		return defaultVal
+	return u.ValidateInputSchema
+}
+
+// ToolBudget returns the page budget of results of the named tool.
+func (u Upstream) ToolBudget(name string) paging.Budget {
+	b := paging.Budget{Tokens: u.MaxResponseTokens, Bytes: u.MaxResponseBytes}
+	if t, ok := u.Tools[name]; ok {
+		if t.MaxResponseTokens != nil {
+			b.Tokens = *t.MaxResponseTokens
+		}
+		if t.MaxResponseBytes != nil {
+			b.Bytes = *t.MaxResponseBytes
+		}
 	}
 	return b
 }
 
-func envIntOrDefault(key string, defaultVal int) int {
-	v := os.Getenv(key)
-	if v == "" {
-		return defaultVal
+// PagesResponses reports whether results of any of the upstream's tools can
+// be split into pages.
+func (u Upstream) PagesResponses() bool {
+	if u.MaxResponseTokens > 0 || u.MaxResponseBytes > 0 {
+		return true
 	}
-	i, err := strconv.Atoi(v)
-	if err != nil {
-		return defaultVal
+	for name := range u.Tools {
+		if u.ToolBudget(name).Enabled() {
+			return true
+		}
 	}
-	return i
+	return false
+}
+
+// Input validation modes.
+const (
+	InputValidationOff    = "off"
+	InputValidationWarn   = "warn"
+	InputValidationReject = "reject"
+)
+
+// validInputValidation reports whether mode is an input validation mode.
+func validInputValidation(mode string) bool {
+	return mode == InputValidationOff || mode == InputValidationWarn || mode == InputValidationReject
+}
+
+// OTLP exporter protocols.
+const (
+	OTLPProtocolGRPC = "grpc"
+	OTLPProtocolHTTP = "http/protobuf"
+)
+
+// Telemetry exporters.
+const (
+	ExporterOTLP       = "otlp"
+	ExporterPrometheus = "prometheus"
+	ExporterFile       = "file"
+	ExporterConsole    = "console"
+	ExporterNone       = "none"
+)
+
+// Upstream transports.
+const (
+	TransportStreamableHTTP = "streamable-http"
+	// TransportSSE is the deprecated HTTP+SSE transport (protocol 2024-11-05):
+	// a GET stream announces a message endpoint that clients POST to.
+	TransportSSE = "sse"
+)
+
+// upstreamSpec is the shape of an entry in the UPSTREAMS list and the config
+// file's upstreams section. Pointer fields distinguish "not set" from the
+// zero value so global defaults apply.
+type upstreamSpec struct {
+	Name                 string              `json:"name" yaml:"name"`
+	URL                  string              `json:"url" yaml:"url"`
+	Address              string              `json:"address" yaml:"address"`
+	Command              string              `json:"command" yaml:"command"`
+	Args                 []string            `json:"args" yaml:"args"`
+	Env                  map[string]string   `json:"env" yaml:"env"`
+	PathPrefix           string              `json:"pathPrefix" yaml:"pathPrefix"`
+	CompressResponses    *bool               `json:"compressResponses" yaml:"compressResponses"`
+	CompressFormat       string              `json:"compressFormat" yaml:"compressFormat"`
+	CompressFlattenDepth *int                `json:"compressFlattenDepth" yaml:"compressFlattenDepth"`
+	PromoteStructured    *bool               `json:"promoteStructuredContent" yaml:"promoteStructuredContent"`
+	ValidateOutput       *bool               `json:"validateOutputSchema" yaml:"validateOutputSchema"`
+	ValidateInput        string              `json:"validateInputSchema" yaml:"validateInputSchema"`
+	MaxResponseTokens    *int                `json:"maxResponseTokens" yaml:"maxResponseTokens"`
+	MaxResponseBytes     *int                `json:"maxResponseBytes" yaml:"maxResponseBytes"`
+	TimeoutSeconds       int                 `json:"timeoutSeconds" yaml:"timeoutSeconds"`
+	Headers              map[string]string   `json:"headers" yaml:"headers"`
+	Transport            string              `json:"transport" yaml:"transport"`
+	Tools                map[string]toolSpec `json:"tools" yaml:"tools"`
+}
+
+// toolSpec is the shape of a per-tool override.
+type toolSpec struct {
+	CompressResponses    *bool  `json:"compressResponses" yaml:"compressResponses"`
+	CompressFormat       string `json:"compressFormat" yaml:"compressFormat"`
+	CompressFlattenDepth *int   `json:"compressFlattenDepth" yaml:"compressFlattenDepth"`
+	PromoteStructured    *bool  `json:"promoteStructuredContent" yaml:"promoteStructuredContent"`
+	ValidateOutput       *bool  `json:"validateOutputSchema" yaml:"validateOutputSchema"`
+	ValidateInput        string `json:"validateInputSchema" yaml:"validateInputSchema"`
+	MaxResponseTokens    *int   `json:"maxResponseTokens" yaml:"maxResponseTokens"`
+	MaxResponseBytes     *int   `json:"maxResponseBytes" yaml:"maxResponseBytes"`
+}
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+const (
+	defaultUpstreamName    = "default"
+	defaultUpstreamTimeout = 300
+)
+
+// Load reads the configuration file named by CONFIG_FILE, if any, and applies
+// environment variable overrides on top of it.
+func Load() (*Config, error) {
+	return LoadFile(os.Getenv("CONFIG_FILE"))
+}
+
+// LoadFile reads the configuration file at path (YAML or JSON; empty for
+// none) and applies environment variable overrides on top of it. Every
+// problem found is reported at once in a *ValidationError.
+func LoadFile(path string) (*Config, error) {
+	cfg := defaults()
+	var specs []upstreamSpec
+	var problems []string
+	if path != "" {
+		fc, fileProblems, err := readFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config file %s: %w", path, err)
+		}
+		specs = fc.apply(cfg)
+		cfg.Path = path
+		problems = fileProblems
+	}
+
+	env := &envReader{}
+	env.apply(cfg)
+	if raw := os.Getenv("UPSTREAMS"); raw != "" {
+		specs = nil
+		dec := json.NewDecoder(strings.NewReader(raw))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&specs); err != nil {
+			env.fail("UPSTREAMS", fmt.Sprintf("must be a JSON list of upstreams: %v", err))
+		}
+	}
+
+	// UPSTREAM_URL remains the single-server shorthand: it becomes the
+	// catch-all upstream alongside any named, prefix-routed upstreams.
+	if upstreamURL := os.Getenv("UPSTREAM_URL"); upstreamURL != "" {
+		specs = append(specs, upstreamSpec{Name: defaultUpstreamName, URL: upstreamURL})
+	}
+
+	problems = append(problems, env.problems...)
+	if cfg.OTELEndpoint == "" {
+		cfg.OTELEndpoint = "localhost:4317"
+		if cfg.OTELProtocol == OTLPProtocolHTTP {
+			cfg.OTELEndpoint = "localhost:4318"
+		}
+	}
+	if len(specs) == 0 {
+		problems = append(problems, "no upstreams configured: set UPSTREAM_URL, UPSTREAMS or upstreams in the config file")
+	}
+	upstreams, upstreamProblems := resolveUpstreams(specs, cfg)
+	cfg.Upstreams = upstreams
+	problems = append(problems, upstreamProblems...)
+	problems = append(problems, cfg.validate()...)
+
+	if len(problems) > 0 {
+		return nil, &ValidationError{Problems: problems}
+	}
+	return cfg, nil
+}
+
+// defaults returns the configuration used when neither the config file nor
+// the environment sets a value.
+func defaults() *Config {
+	return &Config{
+		ProxyPort:                 "8080",
+		OTELInsecure:              true,
+		OTELProtocol:              OTLPProtocolGRPC,
+		TracesExporter:            ExporterOTLP,
+		MetricsExporters:          []string{ExporterOTLP},
+		LogsExporter:              ExporterOTLP,
+		AdminPort:                 "9464",
+		FileExporterDir:           "telemetry",
+		FileExporterMaxSizeMB:     100,
+		FileExporterMaxBackups:    5,
+		ServiceName:               "mcp-otel-proxy",
+		LogLevel:                  "info",
+		CompressFormat:            compress.FormatMarkdown,
+		ValidateInputSchema:       InputValidationOff,
+		ContextPropagation:        true,
+		SessionTTLSeconds:         3600,
+		SSEBufferSize:             100,
+		SSEBufferRetentionSeconds: 300,
+		PageStoreSizeMB:           64,
+		PageStoreRetentionSeconds: 600,
+		ClientIdentity:            "remote-addr",
+		Policy:                    Policy{Default: PolicyAllow, ApprovalTTLSeconds: 900},
+	}
+}
+
+// resolveUpstreams validates upstream specs and applies defaults, including
+// the global compression settings of cfg. Upstreams with problems are left
+// out of the result.
+func resolveUpstreams(specs []upstreamSpec, cfg *Config) ([]Upstream, []string) {
+	names := make(map[string]bool, len(specs))
+	prefixes := make(map[string]string, len(specs))
+	upstreams := make([]Upstream, 0, len(specs))
+	var problems []string
+
+	for i, spec := range specs {
+		where := fmt.Sprintf("upstreams[%d]", i)
+		if spec.Name != "" {
+			where = fmt.Sprintf("upstream %q", spec.Name)
+		}
+		fail := func(format string, args ...any) {
+			problems = append(problems, where+": "+fmt.Sprintf(format, args...))
+		}
+		before := len(problems)
+
+		switch {
+		case spec.Name == "":
+			fail("name is required")
+		case !validName.MatchString(spec.Name):
+			fail("name may only contain letters, digits, '.', '_' and '-'")
+		case names[spec.Name]:
+			fail("duplicate name")
+		}
+		names[spec.Name] = true
+
+		rawURL := spec.URL
+		if rawURL == "" && spec.Address != "" {
+			rawURL = "http://" + spec.Address
+		}
+		switch {
+		case rawURL != "" && spec.Command != "":
+			fail("url/address and command are mutually exclusive")
+		case spec.Command != "":
+			// stdio servers are addressed by name; the URL is never dialed.
+			rawURL = "stdio://" + spec.Name
+		case rawURL == "":
+			fail("url, address or command is required")
+		default:
+			if u, err := url.Parse(rawURL); err != nil || u.Scheme == "" || u.Host == "" {
+				fail("invalid url %q", rawURL)
+			}
+		}
+
+		prefix := strings.TrimRight(spec.PathPrefix, "/")
+		if prefix != "" && !strings.HasPrefix(prefix, "/") {
+			fail("pathPrefix %q must start with /", spec.PathPrefix)
+		} else if other, ok := prefixes[prefix]; ok {
+			fail("pathPrefix %q already used by upstream %q", spec.PathPrefix, other)
+		} else {
+			prefixes[prefix] = spec.Name
+		}
+
+		timeout := spec.TimeoutSeconds
+		if timeout < 0 {
+			fail("timeoutSeconds must not be negative")
+		}
+		if timeout == 0 {
+			timeout = defaultUpstreamTimeout
+		}
+
+		transport := spec.Transport
+		switch transport {
+		case "":
+			transport = TransportStreamableHTTP
+		case TransportStreamableHTTP:
+		case TransportSSE:
+			if spec.Command != "" {
+				fail("transport %q requires a url", transport)
+			}
+		default:
+			fail("unknown transport %q", spec.Transport)
+		}
+
+		compressOn := cfg.CompressResponses
+		if spec.CompressResponses != nil {
+			compressOn = *spec.CompressResponses
+		}
+		format := cfg.CompressFormat
+		if spec.CompressFormat != "" {
+			format = spec.CompressFormat
+			if _, err := compress.New(format, compress.Options{}); err != nil {
+				fail("compressFormat: %v", err)
+			}
+		}
+		depth := cfg.CompressFlattenDepth
+		if spec.CompressFlattenDepth != nil {
+			depth = *spec.CompressFlattenDepth
+			if depth < 0 {
+				fail("compressFlattenDepth: must not be negative, got %d", depth)
+			}
+		}
+
+		promote := cfg.PromoteStructuredContent
+		if spec.PromoteStructured != nil {
+			promote = *spec.PromoteStructured
+		}
+		validateOutput := cfg.ValidateOutputSchema
+		if spec.ValidateOutput != nil {
+			validateOutput = *spec.ValidateOutput
+		}
+		validateInput := cfg.ValidateInputSchema
+		if spec.ValidateInput != "" {
+			validateInput = spec.ValidateInput
+			if !validInputValidation(validateInput) {
+				fail("validateInputSchema: must be %q, %q or %q, got %q", InputValidationOff, InputValidationWarn, InputValidationReject, validateInput)
+			}
+		}
+		maxTokens := cfg.MaxResponseTokens
+		if spec.MaxResponseTokens != nil {
+			maxTokens = *spec.MaxResponseTokens
+			if maxTokens < 0 {
+				fail("maxResponseTokens: must not be negative, got %d", maxTokens)
+			}
+		}
+		maxBytes := cfg.MaxResponseBytes
+		if spec.MaxResponseBytes != nil {
+			maxBytes = *spec.MaxResponseBytes
+			if maxBytes < 0 {
+				fail("maxResponseBytes: must not be negative, got %d", maxBytes)
+			}
+		}
+
+		var tools map[string]Tool
+		for name, t := range spec.Tools {
+			if name == "" {
+				fail("tools: tool name must not be empty")
+				continue
+			}
+			if tools == nil {
+				tools = make(map[string]Tool, len(spec.Tools))
+			}
+			if t.CompressFormat != "" {
+				if _, err := compress.New(t.CompressFormat, compress.Options{}); err != nil {
+					fail("tools: %s: compressFormat: %v", name, err)
+				}
+			}
+			if t.CompressFlattenDepth != nil && *t.CompressFlattenDepth < 0 {
+				fail("tools: %s: compressFlattenDepth: must not be negative, got %d", name, *t.CompressFlattenDepth)
+			}
+			if t.ValidateInput != "" && !validInputValidation(t.ValidateInput) {
+				fail("tools: %s: validateInputSchema: must be %q, %q or %q, got %q", name, InputValidationOff, InputValidationWarn, InputValidationReject, t.ValidateInput)
+			}
+			if t.MaxResponseTokens != nil && *t.MaxResponseTokens < 0 {
+				fail("tools: %s: maxResponseTokens: must not be negative, got %d", name, *t.MaxResponseTokens)
+			}
+			if t.MaxResponseBytes != nil && *t.MaxResponseBytes < 0 {
+				fail("tools: %s: maxResponseBytes: must not be negative, got %d", name, *t.MaxResponseBytes)
+			}
+			tools[name] = Tool{
+				CompressResponses:        t.CompressResponses,
+				CompressFormat:           t.CompressFormat,
+				CompressFlattenDepth:     t.CompressFlattenDepth,
+				PromoteStructuredContent: t.PromoteStructured,
+				ValidateOutputSchema:     t.ValidateOutput,
+				ValidateInputSchema:      t.ValidateInput,
+				MaxResponseTokens:        t.MaxResponseTokens,
+				MaxResponseBytes:         t.MaxResponseBytes,
+			}
+		}
+
+		if len(problems) > before {
+			continue
+		}
+		upstreams = append(upstreams, Upstream{
+			Name:                     spec.Name,
+			URL:                      strings.TrimRight(rawURL, "/"),
+			Command:                  spec.Command,
+			Args:                     spec.Args,
+			Env:                      spec.Env,
+			PathPrefix:               prefix,
+			CompressResponses:        compressOn,
+			CompressFormat:           format,
+			CompressFlattenDepth:     depth,
+			PromoteStructuredContent: promote,
+			ValidateOutputSchema:     validateOutput,
+			ValidateInputSchema:      validateInput,
+			MaxResponseTokens:        maxTokens,
+			MaxResponseBytes:         maxBytes,
+			TimeoutSeconds:           timeout,
+			Headers:                  spec.Headers,
+			Transport:                transport,
+			Tools:                    tools,
+		})
+	}
+
+	return upstreams, problems
 }
@@ -0,0 +1,336 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad_MalformedEnvIsAnError(t *testing.T) {
+	t.Setenv("UPSTREAM_URL", "http://localhost:3000")
+	t.Setenv("CAPTURE_PAYLOAD", "yes please")
+	t.Setenv("SSE_BUFFER_SIZE", "lots")
+
+	_, err := LoadFile("")
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+	if len(verr.Problems) != 2 {
+		t.Fatalf("expected both problems reported, got %q", verr.Problems)
+	}
+	if !strings.HasPrefix(verr.Problems[0], "CAPTURE_PAYLOAD:") || !strings.HasPrefix(verr.Problems[1], "SSE_BUFFER_SIZE:") {
+		t.Errorf("expected problems keyed by variable, got %q", verr.Problems)
+	}
+}
+
+func TestLoad_DurationStrings(t *testing.T) {
+	t.Setenv("UPSTREAM_URL", "http://localhost:3000")
+	t.Setenv("SESSION_TTL", "1h")
+	t.Setenv("SSE_BUFFER_RETENTION", "90")
+
+	cfg, err := LoadFile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.SessionTTLSeconds != 3600 || cfg.SSEBufferRetentionSeconds != 90 {
+		t.Errorf("expected 3600s and 90s, got %d and %d", cfg.SessionTTLSeconds, cfg.SSEBufferRetentionSeconds)
+	}
+}
+
+func TestLoad_OTLPSettings(t *testing.T) {
+	t.Setenv("UPSTREAM_URL", "http://localhost:3000")
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/protobuf")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "Authorization=Api-Token%20abc, x-tenant=team-a")
+
+	cfg, err := LoadFile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.OTELEndpoint != "localhost:4318" {
+		t.Errorf("expected the HTTP default endpoint, got %q", cfg.OTELEndpoint)
+	}
+	if cfg.OTELHeaders["Authorization"] != "Api-Token abc" || cfg.OTELHeaders["x-tenant"] != "team-a" {
+		t.Errorf("unexpected headers %v", cfg.OTELHeaders)
+	}
+
+	t.Setenv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/json")
+	if _, err := LoadFile(""); err == nil || !strings.Contains(err.Error(), "OTLP protocol") {
+		t.Errorf("expected an unsupported protocol to be rejected, got %v", err)
+	}
+}
+
+func TestLoad_Exporters(t *testing.T) {
+	t.Setenv("UPSTREAM_URL", "http://localhost:3000")
+	t.Setenv("OTEL_METRICS_EXPORTER", "prometheus, prometheus")
+	t.Setenv("OTEL_TRACES_EXPORTER", "none")
+
+	cfg, err := LoadFile("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.MetricsExporters) != 1 || !cfg.PrometheusEnabled() {
+		t.Errorf("expected prometheus only, got %q", cfg.MetricsExporters)
+	}
+
+	t.Setenv("ADMIN_PORT", "8080")
+	if _, err := LoadFile(""); err == nil || !strings.Contains(err.Error(), "admin port") {
+		t.Errorf("expected the admin port clash to be rejected, got %v", err)
+	}
+
+	t.Setenv("ADMIN_PORT", "")
+	t.Setenv("OTEL_TRACES_EXPORTER", "file")
+	t.Setenv("TELEMETRY_FILE_MAX_SIZE_MB", "0")
+	if _, err := LoadFile(""); err == nil || !strings.Contains(err.Error(), "file exporter: max size") {
+		t.Errorf("expected a zero file size to be rejected, got %v", err)
+	}
+}
+
+func TestLoadFile_YAMLWithEnvOverride(t *testing.T) {
+	path := writeConfig(t, "proxy.yaml", `
+proxy:
+  port: 9000
+  logLevel: debug
+  sessionTTL: 2h
+  compressResponses: true
+  compressFormat: csv
+  maxResponseBytes: 50000
+upstreams:
+  - name: k8s
+    url: http://localhost:9090
+    pathPrefix: /k8s
+    maxResponseTokens: 4000
+    promoteStructuredContent: true
+    validateInputSchema: reject
+    tools:
+      get_logs:
+        compressResponses: false
+        promoteStructuredContent: false
+        validateInputSchema: warn
+      describe_pod:
+        compressFormat: yaml
+        compressFlattenDepth: 2
+        maxResponseTokens: 0
+policy:
+  approvalTTL: 5m
+  approvers:
+    alice: from-the-file-0123456789
+  rules:
+    - name: no-deletes
+      action: deny
+      tools: ["delete_*"]
+    - name: approve-prod-exec
+      action: require-approval
+      clients: ["ops-*"]
+      tools: [exec_in_pod]
+      arguments:
+        target.namespace: "prod-*"
+`)
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("POLICY_APPROVERS", "bob=from-the-environment-0123")
+
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ProxyPort != "9000" || cfg.SessionTTLSeconds != 7200 {
+		t.Errorf("file values not applied: port=%s ttl=%d", cfg.ProxyPort, cfg.SessionTTLSeconds)
+	}
+	if cfg.LogLevel != "warn" {
+		t.Errorf("expected LOG_LEVEL to override the file, got %q", cfg.LogLevel)
+	}
+	up := cfg.Upstreams[0]
+	if !up.CompressTool("list_pods") || up.CompressTool("get_logs") {
+		t.Errorf("expected per-tool override to disable compression for get_logs only")
+	}
+	if up.ToolCompressOptions("list_pods").FlattenDepth != 0 || up.ToolCompressOptions("describe_pod").FlattenDepth != 2 {
+		t.Errorf("expected flattening for describe_pod only")
+	}
+	if !up.PromoteTool("list_pods") || up.PromoteTool("get_logs") || up.ValidateToolOutput("list_pods") {
+		t.Errorf("expected promotion for list_pods only and no output validation")
+	}
+	if up.ToolInputValidation("list_pods") != InputValidationReject || up.ToolInputValidation("get_logs") != InputValidationWarn {
+		t.Errorf("expected arguments rejected for list_pods and warned about for get_logs, got %q and %q",
+			up.ToolInputValidation("list_pods"), up.ToolInputValidation("get_logs"))
+	}
+	if b := up.ToolBudget("list_pods"); b.Tokens != 4000 || b.Bytes != 50000 {
+		t.Errorf("expected the upstream token budget and global byte budget, got %+v", b)
+	}
+	if b := up.ToolBudget("describe_pod"); b.Tokens != 0 || b.Bytes != 50000 {
+		t.Errorf("expected describe_pod to lift the token budget only, got %+v", b)
+	}
+	if up.ToolCompressFormat("list_pods") != "csv" || up.ToolCompressFormat("describe_pod") != "yaml" {
+		t.Errorf("expected csv by default and yaml for describe_pod, got %q and %q",
+			up.ToolCompressFormat("list_pods"), up.ToolCompressFormat("describe_pod"))
+	}
+	if len(cfg.Policy.Rules) != 2 || cfg.Policy.Rules[0].Action != PolicyDeny {
+		t.Errorf("expected a deny rule first, got %+v", cfg.Policy.Rules)
+	}
+	if r := cfg.Policy.Rules[1]; r.Arguments["target.namespace"] != "prod-*" || !cfg.Policy.RequiresApproval() || !cfg.AdminEnabled() {
+		t.Errorf("expected an approval rule on target.namespace serving the admin port, got %+v", r)
+	}
+	if cfg.Policy.ApprovalTTLSeconds != 300 {
+		t.Errorf("expected a 5m approval TTL, got %ds", cfg.Policy.ApprovalTTLSeconds)
+	}
+	if len(cfg.Policy.Approvers) != 2 || cfg.Policy.Approvers["bob"] != "from-the-environment-0123" {
+		t.Errorf("expected approvers from the file and POLICY_APPROVERS, got %v", cfg.Policy.Approvers)
+	}
+}
+
+func TestLoadFile_JSON(t *testing.T) {
+	path := writeConfig(t, "proxy.json", `{"upstreams": [{"name": "fs", "command": "mcp-fs"}]}`)
+
+	cfg, err := LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Upstreams[0].URL != "stdio://fs" {
+		t.Errorf("expected stdio upstream, got %q", cfg.Upstreams[0].URL)
+	}
+}
+
+func TestLoadFile_UnknownField(t *testing.T) {
+	path := writeConfig(t, "proxy.yaml", `
+proxy:
+  sesionTTL: 60
+upstreams:
+  - name: a
+    url: http://localhost:1
+`)
+	_, err := LoadFile(path)
+	if err == nil || !strings.Contains(err.Error(), "sesionTTL") {
+		t.Errorf("expected the misspelled field to be reported, got %v", err)
+	}
+}
+
+func TestLoadFile_ReportsEveryProblem(t *testing.T) {
+	path := writeConfig(t, "proxy.yaml", `
+proxy:
+  logLevel: loud
+upstreams:
+  - name: a
+  - name: a
+    url: http://localhost:1
+    transport: websocket
+    tools:
+      get_pods:
+        compressFormat: xml
+        compressFlattenDepth: -1
+        validateInputSchema: block
+policy:
+  default: maybe
+  rules:
+    - action: deny
+      tools: ["[bad"]
+    - name: escalate
+      action: escalate
+      arguments:
+        filter..ns: x
+    - name: approve
+      action: require-approval
+    - name: literal
+      action: deny
+      resources: ["file:///[a-z]*"]
+      clients: [""]
+  approvers:
+    carol: short
+`)
+	_, err := LoadFile(path)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+	for _, want := range []string{
+		"log level",
+		`upstream "a": url, address or command is required`,
+		`upstream "a": duplicate name`,
+		`unknown transport "websocket"`,
+		`tools: get_pods: compressFormat: unknown compression format "xml"`,
+		"tools: get_pods: compressFlattenDepth: must not be negative",
+		`tools: get_pods: validateInputSchema: must be "off", "warn" or "reject", got "block"`,
+		"policy default",
+		"policy rules[0]: name is required",
+		`invalid pattern "[bad"`,
+		`policy rule "escalate": action must be "allow", "deny" or "require-approval", got "escalate"`,
+		`invalid argument path "filter..ns"`,
+		`invalid pattern "file:///[a-z]*": only * and ? are wildcards`,
+		`policy rule "literal": invalid pattern ""`,
+		`policy approver "carol": token must be at least 16 characters`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in:\n%s", want, err)
+		}
+	}
+}
+
+func TestLoadFile_ApprovalNeedsApprovers(t *testing.T) {
+	path := writeConfig(t, "proxy.yaml", `
+upstreams:
+  - name: a
+    url: http://localhost:1
+policy:
+  rules:
+    - name: approve
+      action: require-approval
+`)
+	if _, err := LoadFile(path); err == nil || !strings.Contains(err.Error(), "policy approvers: required") {
+		t.Errorf("expected approvers to be required, got %v", err)
+	}
+}
+
+func TestLoadFile_ClientsNeedAVerifiedIdentity(t *testing.T) {
+	tests := []struct {
+		name, identity string
+		wantErr        bool
+	}{
+		{"remote address", "mode: remote-addr", false},
+		{"header", "mode: header\n    header: X-User", true},
+		{"header behind a gateway", "mode: header\n    header: X-User\n    trustedProxies: [10.0.0.1]", false},
+		{"bearer subject", "mode: bearer-sub", true},
+		{"bearer subject behind a gateway", "mode: bearer-sub\n    trustedProxies: [10.0.0.0/8]", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, "proxy.yaml", `
+proxy:
+  clientIdentity:
+    `+tt.identity+`
+upstreams:
+  - name: a
+    url: http://localhost:1
+policy:
+  rules:
+    - name: sre
+      action: allow
+      clients: ["sre-*"]
+`)
+			_, err := LoadFile(path)
+			if gotErr := err != nil && strings.Contains(err.Error(), `policy rule "sre": clients need an identity`); gotErr != tt.wantErr {
+				t.Errorf("expected a clients error %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	old := defaults()
+	next := defaults()
+	next.LogLevel = "debug"
+	next.ProxyPort = "9090"
+
+	fields := RestartRequired(old, next)
+	if len(fields) != 1 || fields[0] != "proxy port" {
+		t.Errorf("expected only the port to need a restart, got %q", fields)
+	}
+}
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envReader applies environment variable overrides to a Config, collecting
+// malformed values instead of silently falling back to defaults.
+type envReader struct {
+	problems []string
+}
+
+func (e *envReader) apply(cfg *Config) {
+	e.str("PROXY_PORT", &cfg.ProxyPort)
+	e.str("OTEL_EXPORTER_OTLP_ENDPOINT", &cfg.OTELEndpoint)
+	e.boolean("OTEL_EXPORTER_OTLP_INSECURE", &cfg.OTELInsecure)
+	e.str("OTEL_SERVICE_NAME", &cfg.ServiceName)
+	e.str("LOG_LEVEL", &cfg.LogLevel)
+	e.boolean("CONTEXT_PROPAGATION", &cfg.ContextPropagation)
+	e.boolean("CAPTURE_PAYLOAD", &cfg.CapturePayload)
+	e.boolean("COMPRESS_RESPONSES", &cfg.CompressResponses)
+	e.str("COMPRESS_FORMAT", &cfg.CompressFormat)
+	e.integer("COMPRESS_FLATTEN_DEPTH", &cfg.CompressFlattenDepth)
+	e.str("TOKENIZER_VOCAB_FILE", &cfg.TokenizerVocabFile)
+	e.boolean("PROMOTE_STRUCTURED_CONTENT", &cfg.PromoteStructuredContent)
+	e.boolean("VALIDATE_OUTPUT_SCHEMA", &cfg.ValidateOutputSchema)
+	e.str("VALIDATE_INPUT_SCHEMA", &cfg.ValidateInputSchema)
+	e.integer("MAX_RESPONSE_TOKENS", &cfg.MaxResponseTokens)
+	e.integer("MAX_RESPONSE_BYTES", &cfg.MaxResponseBytes)
+	e.integer("PAGE_STORE_SIZE_MB", &cfg.PageStoreSizeMB)
+	e.seconds("PAGE_STORE_RETENTION", &cfg.PageStoreRetentionSeconds)
+	e.seconds("SESSION_TTL", &cfg.SessionTTLSeconds)
+	e.str("OTEL_RESOURCE_ATTRIBUTES", &cfg.ResourceAttributes)
+	e.str("OTEL_EXPORTER_OTLP_PROTOCOL", &cfg.OTELProtocol)
+	e.str("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", &cfg.OTELTracesEndpoint)
+	e.str("OTEL_EXPORTER_OTLP_METRICS_ENDPOINT", &cfg.OTELMetricsEndpoint)
+	e.str("OTEL_EXPORTER_OTLP_LOGS_ENDPOINT", &cfg.OTELLogsEndpoint)
+	e.headers("OTEL_EXPORTER_OTLP_HEADERS", &cfg.OTELHeaders)
+	e.str("OTEL_EXPORTER_OTLP_COMPRESSION", &cfg.OTELCompression)
+	e.str("OTEL_EXPORTER_OTLP_CERTIFICATE", &cfg.OTELCertificate)
+	e.str("OTEL_EXPORTER_OTLP_CLIENT_CERTIFICATE", &cfg.OTELClientCertificate)
+	e.str("OTEL_EXPORTER_OTLP_CLIENT_KEY", &cfg.OTELClientKey)
+	e.str("OTEL_TRACES_EXPORTER", &cfg.TracesExporter)
+	if v := os.Getenv("OTEL_METRICS_EXPORTER"); v != "" {
+		cfg.MetricsExporters = splitList(v)
+	}
+	e.str("OTEL_LOGS_EXPORTER", &cfg.LogsExporter)
+	e.str("ADMIN_PORT", &cfg.AdminPort)
+	e.str("TELEMETRY_FILE_DIR", &cfg.FileExporterDir)
+	e.integer("TELEMETRY_FILE_MAX_SIZE_MB", &cfg.FileExporterMaxSizeMB)
+	e.integer("TELEMETRY_FILE_MAX_BACKUPS", &cfg.FileExporterMaxBackups)
+
+	e.integer("SSE_BUFFER_SIZE", &cfg.SSEBufferSize)
+	e.seconds("SSE_BUFFER_RETENTION", &cfg.SSEBufferRetentionSeconds)
+
+	e.boolean("SESSION_INJECTION", &cfg.SessionInjection)
+	e.str("CLIENT_IDENTITY", &cfg.ClientIdentity)
+	e.str("CLIENT_IDENTITY_HEADER", &cfg.ClientIdentityHeader)
+	if v := os.Getenv("TRUSTED_PROXIES"); v != "" {
+		cfg.TrustedProxies = splitList(v)
+	}
+
+	e.str("TLS_CERT_FILE", &cfg.TLSCertFile)
+	e.str("TLS_KEY_FILE", &cfg.TLSKeyFile)
+	e.str("TLS_CLIENT_CA_FILE", &cfg.TLSClientCAFile)
+
+	e.headers("POLICY_APPROVERS", &cfg.Policy.Approvers)
+
+	cfg.LogLevel = strings.ToLower(cfg.LogLevel)
+}
+
+func (e *envReader) fail(key, msg string) {
+	e.problems = append(e.problems, key+": "+msg)
+}
+
+func (e *envReader) str(key string, dst *string) {
+	if v := os.Getenv(key); v != "" {
+		*dst = v
+	}
+}
+
+func (e *envReader) boolean(key string, dst *bool) {
+	v := os.Getenv(key)
+	if v == "" {
+		return
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		e.fail(key, fmt.Sprintf("must be true or false, got %q", v))
+		return
+	}
+	*dst = b
+}
+
+func (e *envReader) integer(key string, dst *int) {
+	v := os.Getenv(key)
+	if v == "" {
+		return
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		e.fail(key, fmt.Sprintf("must be an integer, got %q", v))
+		return
+	}
+	*dst = i
+}
+
+func (e *envReader) seconds(key string, dst *int) {
+	v := os.Getenv(key)
+	if v == "" {
+		return
+	}
+	s, err := parseSeconds(v)
+	if err != nil {
+		e.fail(key, err.Error())
+		return
+	}
+	*dst = s
+}
+
+// headers merges a list of key=value pairs with URL-encoded values, the
+// OTEL_EXPORTER_OTLP_HEADERS format, over dst.
+func (e *envReader) headers(key string, dst *map[string]string) {
+	v := os.Getenv(key)
+	if v == "" {
+		return
+	}
+	if *dst == nil {
+		*dst = make(map[string]string)
+	}
+	for _, pair := range splitList(v) {
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		decoded, err := url.PathUnescape(strings.TrimSpace(value))
+		if !ok || name == "" || err != nil {
+			e.fail(key, fmt.Sprintf("must be a list of key=value pairs, got %q", pair))
+			continue
+		}
+		(*dst)[name] = decoded
+	}
+}
+
+// parseSeconds accepts a whole number of seconds ("3600") or a Go duration
+// string ("1h", "90s").
+func parseSeconds(v string) (int, error) {
+	if i, err := strconv.Atoi(v); err == nil {
+		return i, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("must be a number of seconds or a duration such as \"1h\", got %q", v)
+	}
+	if d%time.Second != 0 {
+		return 0, fmt.Errorf("must be a whole number of seconds, got %q", v)
+	}
+	return int(d / time.Second), nil
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
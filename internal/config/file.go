@@ -0,0 +1,279 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// fileConfig is the shape of the config file. JSON files are read with the
+// same decoder, since JSON is valid YAML. Pointer fields distinguish "not
+// set" from the zero value so defaults apply.
+type fileConfig struct {
+	Proxy     fileProxy      `yaml:"proxy"`
+	OTel      fileOTel       `yaml:"otel"`
+	Upstreams []upstreamSpec `yaml:"upstreams"`
+	Policy    filePolicy     `yaml:"policy"`
+}
+
+type fileProxy struct {
+	Port                 *int         `yaml:"port"`
+	AdminPort            *int         `yaml:"adminPort"`
+	LogLevel             *string      `yaml:"logLevel"`
+	ContextPropagation   *bool        `yaml:"contextPropagation"`
+	CapturePayload       *bool        `yaml:"capturePayload"`
+	CompressResponses    *bool        `yaml:"compressResponses"`
+	CompressFormat       *string      `yaml:"compressFormat"`
+	CompressFlattenDepth *int         `yaml:"compressFlattenDepth"`
+	TokenizerVocabFile   *string      `yaml:"tokenizerVocabFile"`
+	PromoteStructured    *bool        `yaml:"promoteStructuredContent"`
+	ValidateOutput       *bool        `yaml:"validateOutputSchema"`
+	ValidateInput        *string      `yaml:"validateInputSchema"`
+	MaxResponseTokens    *int         `yaml:"maxResponseTokens"`
+	MaxResponseBytes     *int         `yaml:"maxResponseBytes"`
+	PageStore            filePages    `yaml:"pageStore"`
+	SessionTTL           *seconds     `yaml:"sessionTTL"`
+	SessionInjection     *bool        `yaml:"sessionInjection"`
+	ClientIdentity       fileIdentity `yaml:"clientIdentity"`
+	TLS                  fileTLS      `yaml:"tls"`
+	SSEBuffer            fileBuffer   `yaml:"sseBuffer"`
+}
+
+type fileIdentity struct {
+	Mode           *string  `yaml:"mode"`
+	Header         *string  `yaml:"header"`
+	TrustedProxies []string `yaml:"trustedProxies"`
+}
+
+type fileTLS struct {
+	CertFile     *string `yaml:"certFile"`
+	KeyFile      *string `yaml:"keyFile"`
+	ClientCAFile *string `yaml:"clientCAFile"`
+}
+
+type fileBuffer struct {
+	Size      *int     `yaml:"size"`
+	Retention *seconds `yaml:"retention"`
+}
+
+type filePages struct {
+	SizeMB    *int     `yaml:"sizeMB"`
+	Retention *seconds `yaml:"retention"`
+}
+
+type fileOTel struct {
+	Endpoint           *string           `yaml:"endpoint"`
+	Endpoints          fileEndpoints     `yaml:"endpoints"`
+	Protocol           *string           `yaml:"protocol"`
+	Insecure           *bool             `yaml:"insecure"`
+	Headers            map[string]string `yaml:"headers"`
+	Compression        *string           `yaml:"compression"`
+	TLS                fileOTelTLS       `yaml:"tls"`
+	Exporters          fileExporters     `yaml:"exporters"`
+	File               fileExporterFile  `yaml:"file"`
+	ServiceName        *string           `yaml:"serviceName"`
+	ResourceAttributes map[string]string `yaml:"resourceAttributes"`
+}
+
+type fileEndpoints struct {
+	Traces  *string `yaml:"traces"`
+	Metrics *string `yaml:"metrics"`
+	Logs    *string `yaml:"logs"`
+}
+
+type fileExporters struct {
+	Traces  *string  `yaml:"traces"`
+	Metrics []string `yaml:"metrics"`
+	Logs    *string  `yaml:"logs"`
+}
+
+type fileExporterFile struct {
+	Dir        *string `yaml:"dir"`
+	MaxSizeMB  *int    `yaml:"maxSizeMB"`
+	MaxBackups *int    `yaml:"maxBackups"`
+}
+
+type fileOTelTLS struct {
+	CAFile   *string `yaml:"caFile"`
+	CertFile *string `yaml:"certFile"`
+	KeyFile  *string `yaml:"keyFile"`
+}
+
+type filePolicy struct {
+	Default     *string           `yaml:"default"`
+	ApprovalTTL *seconds          `yaml:"approvalTTL"`
+	Approvers   map[string]string `yaml:"approvers"`
+	Rules       []filePolicyRule  `yaml:"rules"`
+}
+
+type filePolicyRule struct {
+	Name      string            `yaml:"name"`
+	Action    string            `yaml:"action"`
+	Upstreams []string          `yaml:"upstreams"`
+	Methods   []string          `yaml:"methods"`
+	Tools     []string          `yaml:"tools"`
+	Resources []string          `yaml:"resources"`
+	Clients   []string          `yaml:"clients"`
+	Arguments map[string]string `yaml:"arguments"`
+}
+
+// seconds is a duration given as a whole number of seconds or as a Go
+// duration string such as "1h".
+type seconds int
+
+func (s *seconds) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: expected a number of seconds or a duration", node.Line)
+	}
+	v, err := parseSeconds(node.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", node.Line, err)
+	}
+	*s = seconds(v)
+	return nil
+}
+
+var unknownField = regexp.MustCompile(`field (\S+) not found in type \S+`)
+
+// readFile decodes the config file at path. Unknown fields and values of the
+// wrong type are returned as problems alongside everything else that could
+// be decoded; err is set only when the file cannot be read or parsed at all.
+func readFile(path string) (*fileConfig, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fc := &fileConfig{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(fc); err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return nil, nil, err
+		}
+		problems := make([]string, len(typeErr.Errors))
+		for i, msg := range typeErr.Errors {
+			problems[i] = path + ": " + unknownField.ReplaceAllString(msg, "unknown field $1")
+		}
+		return fc, problems, nil
+	}
+	return fc, nil, nil
+}
+
+// apply copies every value set in the file onto cfg and returns the
+// upstream specs, which are resolved together with any from the environment.
+func (fc *fileConfig) apply(cfg *Config) []upstreamSpec {
+	p := fc.Proxy
+	if p.Port != nil {
+		cfg.ProxyPort = strconv.Itoa(*p.Port)
+	}
+	if p.AdminPort != nil {
+		cfg.AdminPort = strconv.Itoa(*p.AdminPort)
+	}
+	setString(&cfg.LogLevel, p.LogLevel)
+	setBool(&cfg.ContextPropagation, p.ContextPropagation)
+	setBool(&cfg.CapturePayload, p.CapturePayload)
+	setBool(&cfg.CompressResponses, p.CompressResponses)
+	setString(&cfg.CompressFormat, p.CompressFormat)
+	setInt(&cfg.CompressFlattenDepth, p.CompressFlattenDepth)
+	setString(&cfg.TokenizerVocabFile, p.TokenizerVocabFile)
+	setBool(&cfg.PromoteStructuredContent, p.PromoteStructured)
+	setBool(&cfg.ValidateOutputSchema, p.ValidateOutput)
+	setString(&cfg.ValidateInputSchema, p.ValidateInput)
+	setInt(&cfg.MaxResponseTokens, p.MaxResponseTokens)
+	setInt(&cfg.MaxResponseBytes, p.MaxResponseBytes)
+	setInt(&cfg.PageStoreSizeMB, p.PageStore.SizeMB)
+	if p.PageStore.Retention != nil {
+		cfg.PageStoreRetentionSeconds = int(*p.PageStore.Retention)
+	}
+	if p.SessionTTL != nil {
+		cfg.SessionTTLSeconds = int(*p.SessionTTL)
+	}
+	setBool(&cfg.SessionInjection, p.SessionInjection)
+	setString(&cfg.ClientIdentity, p.ClientIdentity.Mode)
+	setString(&cfg.ClientIdentityHeader, p.ClientIdentity.Header)
+	if p.ClientIdentity.TrustedProxies != nil {
+		cfg.TrustedProxies = p.ClientIdentity.TrustedProxies
+	}
+	setString(&cfg.TLSCertFile, p.TLS.CertFile)
+	setString(&cfg.TLSKeyFile, p.TLS.KeyFile)
+	setString(&cfg.TLSClientCAFile, p.TLS.ClientCAFile)
+	if p.SSEBuffer.Size != nil {
+		cfg.SSEBufferSize = *p.SSEBuffer.Size
+	}
+	if p.SSEBuffer.Retention != nil {
+		cfg.SSEBufferRetentionSeconds = int(*p.SSEBuffer.Retention)
+	}
+
+	o := fc.OTel
+	setString(&cfg.OTELEndpoint, o.Endpoint)
+	setString(&cfg.OTELTracesEndpoint, o.Endpoints.Traces)
+	setString(&cfg.OTELMetricsEndpoint, o.Endpoints.Metrics)
+	setString(&cfg.OTELLogsEndpoint, o.Endpoints.Logs)
+	setString(&cfg.OTELProtocol, o.Protocol)
+	setBool(&cfg.OTELInsecure, o.Insecure)
+	if len(o.Headers) > 0 {
+		cfg.OTELHeaders = o.Headers
+	}
+	setString(&cfg.OTELCompression, o.Compression)
+	setString(&cfg.OTELCertificate, o.TLS.CAFile)
+	setString(&cfg.OTELClientCertificate, o.TLS.CertFile)
+	setString(&cfg.OTELClientKey, o.TLS.KeyFile)
+	setString(&cfg.TracesExporter, o.Exporters.Traces)
+	if o.Exporters.Metrics != nil {
+		cfg.MetricsExporters = o.Exporters.Metrics
+	}
+	setString(&cfg.LogsExporter, o.Exporters.Logs)
+	setString(&cfg.FileExporterDir, o.File.Dir)
+	setInt(&cfg.FileExporterMaxSizeMB, o.File.MaxSizeMB)
+	setInt(&cfg.FileExporterMaxBackups, o.File.MaxBackups)
+	setString(&cfg.ServiceName, o.ServiceName)
+	if len(o.ResourceAttributes) > 0 {
+		pairs := make([]string, 0, len(o.ResourceAttributes))
+		for k, v := range o.ResourceAttributes {
+			pairs = append(pairs, k+"="+v)
+		}
+		sort.Strings(pairs)
+		cfg.ResourceAttributes = strings.Join(pairs, ",")
+	}
+
+	setString(&cfg.Policy.Default, fc.Policy.Default)
+	if fc.Policy.ApprovalTTL != nil {
+		cfg.Policy.ApprovalTTLSeconds = int(*fc.Policy.ApprovalTTL)
+	}
+	if fc.Policy.Approvers != nil {
+		cfg.Policy.Approvers = fc.Policy.Approvers
+	}
+	for _, r := range fc.Policy.Rules {
+		cfg.Policy.Rules = append(cfg.Policy.Rules, PolicyRule(r))
+	}
+
+	return fc.Upstreams
+}
+
+func setString(dst *string, v *string) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+func setInt(dst *int, v *int) {
+	if v != nil {
+		*dst = *v
+	}
+}
+
+func setBool(dst *bool, v *bool) {
+	if v != nil {
+		*dst = *v
+	}
+}
@@ -0,0 +1,280 @@
+package config
+
+import (
+	"fmt"
+	"maps"
+	"path"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/compress"
+	"github.com/isitobservable/mcp-otel-proxy/internal/identity"
+)
+
+// ValidationError lists every problem found in a configuration.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Problems) == 1 {
+		return "invalid configuration: " + e.Problems[0]
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "invalid configuration (%d problems):", len(e.Problems))
+	for _, p := range e.Problems {
+		b.WriteString("\n  - ")
+		b.WriteString(p)
+	}
+	return b.String()
+}
+
+var logLevels = map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
+
+// validate checks settings that do not depend on the upstreams.
+func (c *Config) validate() []string {
+	var problems []string
+	fail := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if port, err := strconv.Atoi(c.ProxyPort); err != nil || port < 1 || port > 65535 {
+		fail("proxy port: must be between 1 and 65535, got %q", c.ProxyPort)
+	}
+	if !logLevels[c.LogLevel] {
+		fail("log level: must be debug, info, warn or error, got %q", c.LogLevel)
+	}
+	for _, signal := range []struct{ name, exporter string }{
+		{"traces", c.TracesExporter},
+		{"logs", c.LogsExporter},
+	} {
+		switch signal.exporter {
+		case ExporterOTLP, ExporterFile, ExporterConsole, ExporterNone:
+		default:
+			fail("%s exporter: must be %q, %q, %q or %q, got %q", signal.name, ExporterOTLP, ExporterFile, ExporterConsole, ExporterNone, signal.exporter)
+		}
+	}
+	if err := c.normalizeMetricsExporters(); err != nil {
+		fail("%v", err)
+	}
+	if c.FileExporterEnabled() {
+		if c.FileExporterDir == "" {
+			fail("file exporter: directory is required")
+		}
+		if c.FileExporterMaxSizeMB <= 0 {
+			fail("file exporter: max size must be positive, got %d", c.FileExporterMaxSizeMB)
+		}
+		if c.FileExporterMaxBackups < 0 {
+			fail("file exporter: max backups must not be negative, got %d", c.FileExporterMaxBackups)
+		}
+	}
+	if port, err := strconv.Atoi(c.AdminPort); c.AdminEnabled() && (err != nil || port < 1 || port > 65535 || c.AdminPort == c.ProxyPort) {
+		fail("admin port: must be between 1 and 65535 and differ from the proxy port, got %q", c.AdminPort)
+	}
+	if c.OTELProtocol != OTLPProtocolGRPC && c.OTELProtocol != OTLPProtocolHTTP {
+		fail("OTLP protocol: must be %q or %q, got %q", OTLPProtocolGRPC, OTLPProtocolHTTP, c.OTELProtocol)
+	}
+	if c.OTELCompression != "" && c.OTELCompression != "gzip" && c.OTELCompression != "none" {
+		fail("OTLP compression: must be gzip or none, got %q", c.OTELCompression)
+	}
+	if (c.OTELClientCertificate == "") != (c.OTELClientKey == "") {
+		fail("OTLP TLS: client certificate and client key must be set together")
+	}
+	if _, err := compress.New(c.CompressFormat, compress.Options{FlattenDepth: c.CompressFlattenDepth}); err != nil {
+		fail("compression: %v", err)
+	}
+	if !validInputValidation(c.ValidateInputSchema) {
+		fail("input validation: must be %q, %q or %q, got %q", InputValidationOff, InputValidationWarn, InputValidationReject, c.ValidateInputSchema)
+	}
+	if c.MaxResponseTokens < 0 || c.MaxResponseBytes < 0 {
+		fail("response budget: must not be negative, got %d tokens and %d bytes", c.MaxResponseTokens, c.MaxResponseBytes)
+	}
+	if c.PageStoreSizeMB <= 0 {
+		fail("page store size: must be positive, got %d", c.PageStoreSizeMB)
+	}
+	if c.PageStoreRetentionSeconds <= 0 {
+		fail("page store retention: must be positive, got %d", c.PageStoreRetentionSeconds)
+	}
+	if c.SessionTTLSeconds <= 0 {
+		fail("session TTL: must be positive, got %d", c.SessionTTLSeconds)
+	}
+	if c.SSEBufferSize < 0 {
+		fail("SSE buffer size: must not be negative, got %d", c.SSEBufferSize)
+	}
+	if c.SSEBufferSize > 0 && c.SSEBufferRetentionSeconds <= 0 {
+		fail("SSE buffer retention: must be positive, got %d", c.SSEBufferRetentionSeconds)
+	}
+
+	if _, err := identity.New(identity.Options{
+		Mode:           c.ClientIdentity,
+		Header:         c.ClientIdentityHeader,
+		TrustedProxies: c.TrustedProxies,
+	}); err != nil {
+		fail("%v", err)
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		fail("TLS: cert file and key file must be set together")
+	}
+	if c.TLSClientCAFile != "" && c.TLSCertFile == "" {
+		fail("TLS: client CA file requires a cert file and key file")
+	}
+	if c.ClientIdentity == identity.ModeMTLSCN && c.TLSClientCAFile == "" {
+		fail("client identity: mode %q requires a TLS client CA file", c.ClientIdentity)
+	}
+	// A rule on clients is only as good as the identity it matches
+	if identity.Spoofable(identity.Options{Mode: c.ClientIdentity, TrustedProxies: c.TrustedProxies}) {
+		for _, r := range c.Policy.Rules {
+			if len(r.Clients) > 0 {
+				fail("policy rule %q: clients need an identity callers cannot forge; with client identity %q, set trusted proxies to the gateway that sets it", r.Name, c.ClientIdentity)
+			}
+		}
+	}
+
+	return append(problems, c.Policy.validate()...)
+}
+
+// normalizeMetricsExporters checks MetricsExporters and drops "none" and
+// duplicates from it.
+func (c *Config) normalizeMetricsExporters() error {
+	var exporters []string
+	for _, name := range c.MetricsExporters {
+		switch name {
+		case ExporterNone:
+			if len(c.MetricsExporters) > 1 {
+				return fmt.Errorf("metrics exporter: %q cannot be combined with other exporters", ExporterNone)
+			}
+			continue
+		case ExporterOTLP, ExporterPrometheus, ExporterFile, ExporterConsole:
+		default:
+			return fmt.Errorf("metrics exporter: must be %q, %q, %q, %q or %q, got %q", ExporterOTLP, ExporterPrometheus, ExporterFile, ExporterConsole, ExporterNone, name)
+		}
+		if !slices.Contains(exporters, name) {
+			exporters = append(exporters, name)
+		}
+	}
+	c.MetricsExporters = exporters
+	return nil
+}
+
+// PrometheusEnabled reports whether metrics are served for scraping.
+func (c *Config) PrometheusEnabled() bool {
+	return slices.Contains(c.MetricsExporters, ExporterPrometheus)
+}
+
+// AdminEnabled reports whether the admin port is served, for Prometheus
+// metrics or for policy approvals.
+func (c *Config) AdminEnabled() bool {
+	return c.PrometheusEnabled() || c.Policy.RequiresApproval()
+}
+
+// FileExporterEnabled reports whether any signal is written to files.
+func (c *Config) FileExporterEnabled() bool {
+	return c.TracesExporter == ExporterFile || c.LogsExporter == ExporterFile || slices.Contains(c.MetricsExporters, ExporterFile)
+}
+
+func (p *Policy) validate() []string {
+	var problems []string
+	if !validAction(p.Default) {
+		problems = append(problems, fmt.Sprintf("policy default: must be %q, %q or %q, got %q", PolicyAllow, PolicyDeny, PolicyRequireApproval, p.Default))
+	}
+	if p.ApprovalTTLSeconds <= 0 {
+		problems = append(problems, fmt.Sprintf("policy approval TTL: must be positive, got %d", p.ApprovalTTLSeconds))
+	}
+	if p.RequiresApproval() && len(p.Approvers) == 0 {
+		problems = append(problems, "policy approvers: required when a rule requires approval, or no request could be approved")
+	}
+	for _, name := range slices.Sorted(maps.Keys(p.Approvers)) {
+		if len(p.Approvers[name]) < minApproverTokenLength {
+			problems = append(problems, fmt.Sprintf("policy approver %q: token must be at least %d characters", name, minApproverTokenLength))
+		}
+	}
+
+	names := make(map[string]bool, len(p.Rules))
+	for i, r := range p.Rules {
+		where := fmt.Sprintf("policy rules[%d]", i)
+		if r.Name != "" {
+			where = fmt.Sprintf("policy rule %q", r.Name)
+		}
+		fail := func(format string, args ...any) {
+			problems = append(problems, where+": "+fmt.Sprintf(format, args...))
+		}
+
+		switch {
+		case r.Name == "":
+			fail("name is required")
+		case names[r.Name]:
+			fail("duplicate name")
+		}
+		names[r.Name] = true
+
+		if !validAction(r.Action) {
+			fail("action must be %q, %q or %q, got %q", PolicyAllow, PolicyDeny, PolicyRequireApproval, r.Action)
+		}
+		for _, pattern := range slices.Concat(r.Methods, r.Tools) {
+			if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+				fail("invalid pattern %q", pattern)
+			}
+		}
+		// These patterns only know * and ?; anything path.Match would read
+		// as syntax is matched literally, so it is refused as a likely
+		// mistake
+		for _, pattern := range slices.Concat(r.Resources, r.Clients, slices.Sorted(maps.Values(r.Arguments))) {
+			if pattern == "" || strings.ContainsAny(pattern, `[]\`) {
+				fail("invalid pattern %q: only * and ? are wildcards in resources, clients and arguments, and [, ] and \\ are not allowed", pattern)
+			}
+		}
+		for key := range r.Arguments {
+			if key == "" || strings.HasPrefix(key, ".") || strings.HasSuffix(key, ".") || strings.Contains(key, "..") {
+				fail("invalid argument path %q", key)
+			}
+		}
+	}
+	return problems
+}
+
+// minApproverTokenLength keeps approver tokens out of guessing range.
+const minApproverTokenLength = 16
+
+func validAction(action string) bool {
+	return action == PolicyAllow || action == PolicyDeny || action == PolicyRequireApproval
+}
+
+// RestartRequired lists the settings that differ between old and next but
+// only take effect when the proxy restarts.
+func RestartRequired(old, next *Config) []string {
+	var fields []string
+	check := func(name string, a, b any) {
+		if !reflect.DeepEqual(a, b) {
+			fields = append(fields, name)
+		}
+	}
+	check("proxy port", old.ProxyPort, next.ProxyPort)
+	check("OTel endpoint", old.OTELEndpoint, next.OTELEndpoint)
+	check("OTel insecure", old.OTELInsecure, next.OTELInsecure)
+	check("OTel protocol", old.OTELProtocol, next.OTELProtocol)
+	check("OTel exporters", []any{old.TracesExporter, old.MetricsExporters, old.LogsExporter},
+		[]any{next.TracesExporter, next.MetricsExporters, next.LogsExporter})
+	check("admin port", []any{old.AdminPort, old.AdminEnabled()}, []any{next.AdminPort, next.AdminEnabled()})
+	check("OTel file exporter", []any{old.FileExporterDir, old.FileExporterMaxSizeMB, old.FileExporterMaxBackups},
+		[]any{next.FileExporterDir, next.FileExporterMaxSizeMB, next.FileExporterMaxBackups})
+	check("OTel signal endpoints", []string{old.OTELTracesEndpoint, old.OTELMetricsEndpoint, old.OTELLogsEndpoint},
+		[]string{next.OTELTracesEndpoint, next.OTELMetricsEndpoint, next.OTELLogsEndpoint})
+	check("OTel headers", old.OTELHeaders, next.OTELHeaders)
+	check("OTel compression", old.OTELCompression, next.OTELCompression)
+	check("OTel TLS files", []string{old.OTELCertificate, old.OTELClientCertificate, old.OTELClientKey},
+		[]string{next.OTELCertificate, next.OTELClientCertificate, next.OTELClientKey})
+	check("OTel service name", old.ServiceName, next.ServiceName)
+	check("OTel resource attributes", old.ResourceAttributes, next.ResourceAttributes)
+	check("session TTL", old.SessionTTLSeconds, next.SessionTTLSeconds)
+	check("SSE buffer size", old.SSEBufferSize, next.SSEBufferSize)
+	check("SSE buffer retention", old.SSEBufferRetentionSeconds, next.SSEBufferRetentionSeconds)
+	check("page store size", old.PageStoreSizeMB, next.PageStoreSizeMB)
+	check("page store retention", old.PageStoreRetentionSeconds, next.PageStoreRetentionSeconds)
+	check("TLS cert file", old.TLSCertFile, next.TLSCertFile)
+	check("TLS key file", old.TLSKeyFile, next.TLSKeyFile)
+	check("TLS client CA file", old.TLSClientCAFile, next.TLSClientCAFile)
+	return fields
+}
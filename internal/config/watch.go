@@ -0,0 +1,41 @@
+package config
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// Watch polls the file at path every interval and sends on the returned
+// channel whenever its modification time or size changes. The channel is
+// closed when ctx is done.
+func Watch(ctx context.Context, path string, interval time.Duration) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	go func() {
+		defer close(changed)
+		last, _ := os.Stat(path)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				// Editors may replace the file in several steps; wait for
+				// it to reappear.
+				continue
+			}
+			if last == nil || !info.ModTime().Equal(last.ModTime()) || info.Size() != last.Size() {
+				last = info
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changed
+}
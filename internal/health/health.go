@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/url"
+	"os/exec"
 	"time"
 )
 
@@ -12,9 +13,30 @@ type response struct {
 	Reason string `json:"reason,omitempty"`
 }
 
+// Check is a named readiness probe, typically one per upstream.
+type Check struct {
+	Name  string
+	Probe func() error
+}
+
+// UpstreamCheck returns a readiness check that probes an HTTP upstream.
+func UpstreamCheck(name, upstreamURL string) Check {
+	return Check{Name: name, Probe: func() error { return checkUpstream(upstreamURL) }}
+}
+
+// CommandCheck returns a readiness check for a stdio upstream: the command
+// must be resolvable so sessions can start it.
+func CommandCheck(name, command string) Check {
+	return Check{Name: name, Probe: func() error {
+		_, err := exec.LookPath(command)
+		return err
+	}}
+}
+
 // Handler returns an HTTP handler that serves /healthz and /readyz endpoints.
-// upstreamURL is checked for readiness probes.
-func Handler(upstreamURL string) http.Handler {
+// Every check returned by checks must pass for the readiness probe to
+// succeed; checks is called per probe so the set can change on reload.
+func Handler(checks func() []Check) http.Handler {
 	mux := http.NewServeMux()
 
 	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
@@ -22,12 +44,14 @@ func Handler(upstreamURL string) http.Handler {
 	})
 
 	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
-		if err := checkUpstream(upstreamURL); err != nil {
-			writeJSON(w, http.StatusServiceUnavailable, response{
-				Status: "not ready",
-				Reason: "upstream unreachable",
-			})
-			return
+		for _, check := range checks() {
+			if err := check.Probe(); err != nil {
+				writeJSON(w, http.StatusServiceUnavailable, response{
+					Status: "not ready",
+					Reason: "upstream " + check.Name + " unreachable",
+				})
+				return
+			}
 		}
 		writeJSON(w, http.StatusOK, response{Status: "ready"})
 	})
@@ -0,0 +1,246 @@
+// Package identity resolves who is calling the proxy. The identity keys
+// per-client state such as cached session IDs, so it must distinguish
+// clients that share a network path, for example behind a load balancer.
+package identity
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Identity resolution modes.
+const (
+	ModeRemoteAddr   = "remote-addr"
+	ModeForwardedFor = "forwarded-for"
+	ModeHeader       = "header"
+	ModeBearerSub    = "bearer-sub"
+	ModeMTLSCN       = "mtls-cn"
+)
+
+// ClientIdentity resolves the caller of a request. ok is false when the
+// request carries no usable identity.
+type ClientIdentity interface {
+	Resolve(r *http.Request) (id string, ok bool)
+}
+
+// Options configures New.
+type Options struct {
+	Mode string
+	// Header is the request header holding the identity in ModeHeader.
+	Header string
+	// TrustedProxies lists IPs or CIDRs whose X-Forwarded-For entries are
+	// believed in ModeForwardedFor. In ModeHeader and ModeBearerSub, when
+	// set, only requests from them carry an identity.
+	TrustedProxies []string
+}
+
+// Spoofable reports whether a client can claim any identity it likes under
+// opts: ModeHeader and ModeBearerSub believe every caller unless
+// TrustedProxies names the gateways that set them.
+func Spoofable(opts Options) bool {
+	return (opts.Mode == ModeHeader || opts.Mode == ModeBearerSub) && len(opts.TrustedProxies) == 0
+}
+
+// New returns the resolver for opts.Mode. An empty mode resolves by remote
+// address.
+func New(opts Options) (ClientIdentity, error) {
+	switch opts.Mode {
+	case "", ModeRemoteAddr:
+		return RemoteAddr{}, nil
+	case ModeForwardedFor:
+		trusted, err := parseNets(opts.TrustedProxies)
+		if err != nil {
+			return nil, err
+		}
+		return ForwardedFor{Trusted: trusted}, nil
+	case ModeHeader:
+		if opts.Header == "" {
+			return nil, fmt.Errorf("client identity mode %q requires a header name", ModeHeader)
+		}
+		trusted, err := parseNets(opts.TrustedProxies)
+		if err != nil {
+			return nil, err
+		}
+		return Header{Name: opts.Header, Trusted: trusted}, nil
+	case ModeBearerSub:
+		trusted, err := parseNets(opts.TrustedProxies)
+		if err != nil {
+			return nil, err
+		}
+		return BearerSubject{Trusted: trusted}, nil
+	case ModeMTLSCN:
+		return ClientCert{}, nil
+	default:
+		return nil, fmt.Errorf("unknown client identity mode %q", opts.Mode)
+	}
+}
+
+// RemoteAddr identifies clients by the IP of the connection.
+type RemoteAddr struct{}
+
+func (RemoteAddr) Resolve(r *http.Request) (string, bool) {
+	ip := remoteIP(r)
+	if ip == nil {
+		return "", false
+	}
+	return ip.String(), true
+}
+
+// ForwardedFor identifies clients by X-Forwarded-For. Entries are only
+// believed when added by a trusted proxy: the list is walked from the right
+// and the first address not in Trusted is the client. Requests that do not
+// come from a trusted proxy are identified by their connection.
+type ForwardedFor struct {
+	Trusted []*net.IPNet
+}
+
+func (f ForwardedFor) Resolve(r *http.Request) (string, bool) {
+	ip := remoteIP(r)
+	if ip == nil {
+		return "", false
+	}
+	if !f.trusted(ip) {
+		return ip.String(), true
+	}
+
+	var hops []string
+	for _, v := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hop == nil {
+			// Anything left of a malformed entry may be forged.
+			break
+		}
+		ip = hop
+		if !f.trusted(hop) {
+			break
+		}
+	}
+	return ip.String(), true
+}
+
+func (f ForwardedFor) trusted(ip net.IP) bool {
+	return containsIP(f.Trusted, ip)
+}
+
+// Header identifies clients by the value of a request header, typically set
+// by an authenticating gateway in front of the proxy. With Trusted set, only
+// requests from those gateways carry an identity.
+type Header struct {
+	Name    string
+	Trusted []*net.IPNet
+}
+
+func (h Header) Resolve(r *http.Request) (string, bool) {
+	if !fromGateway(r, h.Trusted) {
+		return "", false
+	}
+	v := strings.TrimSpace(r.Header.Get(h.Name))
+	return v, v != ""
+}
+
+// BearerSubject identifies clients by the sub claim of a JWT bearer token.
+// The token signature is not verified; use it only when a gateway in front
+// of the proxy has already authenticated the token, and set Trusted to that
+// gateway so requests bypassing it carry no identity.
+type BearerSubject struct {
+	Trusted []*net.IPNet
+}
+
+func (b BearerSubject) Resolve(r *http.Request) (string, bool) {
+	if !fromGateway(r, b.Trusted) {
+		return "", false
+	}
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	parts := strings.Split(strings.TrimSpace(token), ".")
+	if len(parts) != 3 {
+		return "", false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", false
+	}
+	var claims struct {
+		Sub string `json:"sub"`
+	}
+	if json.Unmarshal(payload, &claims) != nil || claims.Sub == "" {
+		return "", false
+	}
+	return claims.Sub, true
+}
+
+// ClientCert identifies clients by the common name of their verified TLS
+// client certificate.
+type ClientCert struct{}
+
+func (ClientCert) Resolve(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+	return cn, cn != ""
+}
+
+// fromGateway reports whether r comes from one of the trusted gateways, or
+// from anywhere when none are set.
+func fromGateway(r *http.Request, trusted []*net.IPNet) bool {
+	if len(trusted) == 0 {
+		return true
+	}
+	ip := remoteIP(r)
+	return ip != nil && containsIP(trusted, ip)
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+func parseNets(specs []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(specs))
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		if !strings.Contains(spec, "/") {
+			ip := net.ParseIP(spec)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", spec)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", spec, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
@@ -14,6 +14,8 @@ type RequestInfo struct {
 	PromptName  string
 	ResourceURI string
 	RequestID   string
+	// ToolArguments are the arguments of a tools/call request, if any.
+	ToolArguments json.RawMessage
 }
 
 // SpanName returns the OTel span name following MCP semantic conventions.
@@ -64,6 +66,7 @@ func ExtractRequestInfo(req *jsonrpc.Request) *RequestInfo {
 				info.ToolName = s
 			}
 		}
+		info.ToolArguments = params["arguments"]
 	case "resources/read", "resources/subscribe", "resources/unsubscribe":
 		if uri, ok := params["uri"]; ok {
 			var s string
@@ -1,10 +1,15 @@
 package mcp
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
+
 	"github.com/isitobservable/mcp-otel-proxy/internal/jsonrpc"
 )
 
@@ -14,20 +19,75 @@ type Session struct {
 	ProtocolVersion string
 	CreatedAt       time.Time
 	LastAccessedAt  time.Time
+
+	// initRequest is the initialize request that established the session,
+	// replayed when the upstream session has to be re-established.
+	initRequest []byte
+	// upstreamID is the upstream session currently serving this session.
+	// It changes when the session is reinitialized.
+	upstreamID string
+	// upstreamSpan is the span that established upstreamID.
+	upstreamSpan trace.SpanContext
+	// tokensSaved is the running total of tokens response compression
+	// saved in the session; negative if it cost tokens.
+	tokensSaved atomic.Int64
+
+	toolsMu sync.RWMutex
+	// tools caches the definitions from the session's tools/list
+	// responses, keyed by name.
+	tools map[string]*Tool
+}
+
+// SetTools caches tool definitions from a tools/list response. A response
+// to a request without a cursor starts the list over; later pages add to it.
+func (s *Session) SetTools(tools []*Tool, firstPage bool) {
+	s.toolsMu.Lock()
+	defer s.toolsMu.Unlock()
+	if firstPage || s.tools == nil {
+		s.tools = make(map[string]*Tool, len(tools))
+	}
+	for _, t := range tools {
+		s.tools[t.Name] = t
+	}
+}
+
+// Tool returns the cached definition of the named tool, or nil if the
+// session has not listed it.
+func (s *Session) Tool(name string) *Tool {
+	s.toolsMu.RLock()
+	defer s.toolsMu.RUnlock()
+	return s.tools[name]
 }
 
+// AddTokensSaved adds n to the tokens compression saved in the session and
+// returns the new total.
+func (s *Session) AddTokensSaved(n int64) int64 {
+	return s.tokensSaved.Add(n)
+}
+
+// TokensSaved returns the tokens compression saved in the session.
+func (s *Session) TokensSaved() int64 {
+	return s.tokensSaved.Load()
+}
+
+// Reasons passed to the onRemove callback of a SessionStore.
+const (
+	EndTerminated = "terminated"
+	EndExpired    = "expired"
+)
+
 // SessionStore manages MCP session state with TTL-based eviction.
 type SessionStore struct {
 	mu       sync.RWMutex
 	sessions map[string]*Session
 	ttl      time.Duration
 	onAdd    func()
-	onRemove func()
+	onRemove func(s *Session, reason string)
 }
 
 // NewSessionStore creates a new session store with TTL eviction.
 // onAdd and onRemove callbacks are called when sessions are added/removed (for metrics).
-func NewSessionStore(ttl time.Duration, onAdd, onRemove func()) *SessionStore {
+func NewSessionStore(ttl time.Duration, onAdd func(), onRemove func(s *Session, reason string)) *SessionStore {
 	ss := &SessionStore{
 		sessions: make(map[string]*Session),
 		ttl:      ttl,
@@ -40,8 +100,9 @@ func NewSessionStore(ttl time.Duration, onAdd, onRemove func()) *SessionStore {
 
 // Get returns the session for the given ID, or nil if not found.
 func (ss *SessionStore) Get(id string) *Session {
-	ss.mu.RLock()
-	defer ss.mu.RUnlock()
+	// Get touches the session, so it needs the write lock
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
 	s, ok := ss.sessions[id]
 	if !ok {
 		return nil
@@ -51,7 +112,8 @@ func (ss *SessionStore) Get(id string) *Session {
 }
 
 // TrackInitialize extracts session info from initialize request/response.
-func (ss *SessionStore) TrackInitialize(resp *jsonrpc.Response, sessionID string) {
+// initRequest is kept so the handshake can be replayed for this session.
+func (ss *SessionStore) TrackInitialize(resp *jsonrpc.Response, sessionID string, initRequest []byte) {
 	if sessionID == "" {
 		return
 	}
@@ -77,12 +139,62 @@ func (ss *SessionStore) TrackInitialize(resp *jsonrpc.Response, sessionID string
 		ProtocolVersion: protocolVersion,
 		CreatedAt:       time.Now(),
 		LastAccessedAt:  time.Now(),
+		initRequest:     append([]byte(nil), initRequest...),
+		upstreamID:      sessionID,
 	}
 	if ss.onAdd != nil {
 		ss.onAdd()
 	}
 }
 
+// InitRequest returns the initialize request that established the session,
+// or nil if the session is unknown.
+func (ss *SessionStore) InitRequest(id string) []byte {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	if s, ok := ss.sessions[id]; ok {
+		return s.initRequest
+	}
+	return nil
+}
+
+// Upstream returns the upstream session currently serving the session and
+// the span that established it. upstreamID is "" if the session is unknown.
+func (ss *SessionStore) Upstream(id string) (upstreamID string, established trace.SpanContext) {
+	ss.mu.RLock()
+	defer ss.mu.RUnlock()
+	if s, ok := ss.sessions[id]; ok {
+		return s.upstreamID, s.upstreamSpan
+	}
+	return "", trace.SpanContext{}
+}
+
+// SetUpstream records that the session is now served by upstreamID, which
+// was established by the span with context established.
+func (ss *SessionStore) SetUpstream(id, upstreamID string, established trace.SpanContext) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	if s, ok := ss.sessions[id]; ok {
+		s.upstreamID = upstreamID
+		s.upstreamSpan = established
+	}
+}
+
+// Remove deletes a terminated session and returns it, or nil if unknown.
+func (ss *SessionStore) Remove(id string) *Session {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	s, ok := ss.sessions[id]
+	if !ok {
+		return nil
+	}
+	delete(ss.sessions, id)
+	if ss.onRemove != nil {
+		ss.onRemove(s, EndTerminated)
+	}
+	return s
+}
+
 // ActiveCount returns the number of active sessions.
 func (ss *SessionStore) ActiveCount() int {
 	ss.mu.RLock()
@@ -106,8 +218,15 @@ func (ss *SessionStore) evict() {
 		if now.Sub(s.LastAccessedAt) > ss.ttl {
 			delete(ss.sessions, id)
 			if ss.onRemove != nil {
-				ss.onRemove()
+				ss.onRemove(s, EndExpired)
 			}
 		}
 	}
 }
+
+// NewSessionID returns a random, URL-safe session identifier.
+func NewSessionID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,56 @@
+package mcp
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/schema"
+)
+
+// Tool is a tool definition from a tools/list response. A schema is nil
+// when the tool declares none or it could not be compiled.
+type Tool struct {
+	Name         string
+	InputSchema  *schema.Schema
+	OutputSchema *schema.Schema
+}
+
+// ParseTools returns the tools in a tools/list result, along with a problem
+// for every schema that could not be compiled.
+func ParseTools(result json.RawMessage) ([]*Tool, []string) {
+	var list struct {
+		Tools []struct {
+			Name         string          `json:"name"`
+			InputSchema  json.RawMessage `json:"inputSchema"`
+			OutputSchema json.RawMessage `json:"outputSchema"`
+		} `json:"tools"`
+	}
+	if err := json.Unmarshal(result, &list); err != nil {
+		return nil, []string{fmt.Sprintf("tools/list result: %v", err)}
+	}
+
+	var problems []string
+	compile := func(tool, field string, raw json.RawMessage) *schema.Schema {
+		if len(raw) == 0 || string(raw) == "null" {
+			return nil
+		}
+		s, err := schema.Compile(raw)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("tool %q: %s: %v", tool, field, err))
+			return nil
+		}
+		return s
+	}
+	tools := make([]*Tool, 0, len(list.Tools))
+	for _, t := range list.Tools {
+		if t.Name == "" {
+			continue
+		}
+		tools = append(tools, &Tool{
+			Name:         t.Name,
+			InputSchema:  compile(t.Name, "inputSchema", t.InputSchema),
+			OutputSchema: compile(t.Name, "outputSchema", t.OutputSchema),
+		})
+	}
+	return tools, problems
+}
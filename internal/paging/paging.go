@@ -0,0 +1,100 @@
+// Package paging splits tool output that exceeds a size budget into pages
+// an LLM can read one at a time.
+package paging
+
+import (
+	"strings"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/tokens"
+)
+
+// Budget limits the size of one page. A zero field sets no limit; when both
+// are set a page stays within both.
+type Budget struct {
+	Tokens int
+	Bytes  int
+}
+
+// Enabled reports whether the budget limits anything.
+func (b Budget) Enabled() bool {
+	return b.Tokens > 0 || b.Bytes > 0
+}
+
+// Split returns text as pages within budget, counting tokens with counter.
+// Pages break after a newline where possible; a line too large for a page
+// on its own is cut between runes. Text within budget is a single page.
+func Split(text string, budget Budget, counter tokens.Counter) []string {
+	if !budget.Enabled() || budget.fits(len(text), tokensIf(budget, counter, text)) {
+		return []string{text}
+	}
+
+	var pages []string
+	var page strings.Builder
+	pageTokens := 0
+	flush := func() {
+		if page.Len() > 0 {
+			pages = append(pages, page.String())
+			page.Reset()
+			pageTokens = 0
+		}
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		for line != "" {
+			n := tokensIf(budget, counter, line)
+			if budget.fits(page.Len()+len(line), pageTokens+n) {
+				page.WriteString(line)
+				pageTokens += n
+				break
+			}
+			if page.Len() > 0 {
+				flush()
+				continue
+			}
+			// The line alone is over budget: cut off as much as fits
+			head := budget.prefix(line, counter)
+			pages = append(pages, head)
+			line = line[len(head):]
+		}
+	}
+	flush()
+	return pages
+}
+
+func (b Budget) fits(size, count int) bool {
+	return (b.Bytes <= 0 || size <= b.Bytes) && (b.Tokens <= 0 || count <= b.Tokens)
+}
+
+// prefix returns the longest prefix of s within budget, and at least its
+// first rune so that splitting always makes progress.
+func (b Budget) prefix(s string, counter tokens.Counter) string {
+	// bounds[i] is the end of rune i. Binary search over them, since token
+	// counts only grow with length.
+	bounds := make([]int, 0, len(s))
+	for i := range s {
+		if i > 0 {
+			bounds = append(bounds, i)
+		}
+	}
+	bounds = append(bounds, len(s))
+
+	lo, hi := 0, len(bounds)-1
+	for lo < hi {
+		mid := (lo + hi + 1) / 2
+		head := s[:bounds[mid]]
+		if b.fits(len(head), tokensIf(b, counter, head)) {
+			lo = mid
+		} else {
+			hi = mid - 1
+		}
+	}
+	return s[:bounds[lo]]
+}
+
+// tokensIf counts the tokens in s when the budget limits tokens.
+func tokensIf(b Budget, counter tokens.Counter, s string) int {
+	if b.Tokens <= 0 {
+		return 0
+	}
+	return counter.Count(s)
+}
@@ -0,0 +1,66 @@
+package paging
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/tokens"
+)
+
+func TestSplit_WithinBudget(t *testing.T) {
+	text := "a\nb\nc\n"
+	if got := Split(text, Budget{Bytes: 100}, tokens.Approx{}); !slices.Equal(got, []string{text}) {
+		t.Errorf("expected a single page, got %q", got)
+	}
+	if got := Split(text, Budget{}, tokens.Approx{}); !slices.Equal(got, []string{text}) {
+		t.Errorf("expected no split without a budget, got %q", got)
+	}
+}
+
+func TestSplit_Bytes(t *testing.T) {
+	text := "| a |\n| b |\n| c |\n| d |\n"
+	got := Split(text, Budget{Bytes: 13}, tokens.Approx{})
+	want := []string{"| a |\n| b |\n", "| c |\n| d |\n"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Split = %q, want %q", got, want)
+	}
+}
+
+func TestSplit_LongLine(t *testing.T) {
+	text := "short\n" + strings.Repeat("é", 10) + "\n"
+	got := Split(text, Budget{Bytes: 7}, tokens.Approx{})
+	if strings.Join(got, "") != text {
+		t.Fatalf("pages do not add up to the text: %q", got)
+	}
+	for _, page := range got {
+		if len(page) > 7 {
+			t.Errorf("page %q is over budget", page)
+		}
+		if !utf8.ValidString(page) {
+			t.Errorf("page %q is not cut between runes", page)
+		}
+	}
+}
+
+func TestSplit_Tokens(t *testing.T) {
+	var b strings.Builder
+	for range 50 {
+		b.WriteString("| pod | Running | default |\n")
+	}
+	text := b.String()
+	counter := tokens.Approx{}
+	got := Split(text, Budget{Tokens: 40}, counter)
+	if len(got) < 2 {
+		t.Fatalf("expected several pages, got %d", len(got))
+	}
+	if strings.Join(got, "") != text {
+		t.Fatal("pages do not add up to the text")
+	}
+	for _, page := range got {
+		if n := counter.Count(page); n > 40 {
+			t.Errorf("page has %d tokens, over budget:\n%s", n, page)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/jsonrpc"
+	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
+)
+
+// approvalRequiredCode is the JSON-RPC error code returned for requests held
+// until an operator approves them.
+const approvalRequiredCode = -32002
+
+// approvalStore keeps requests held by require-approval rules. An operator
+// grants or rejects them on the admin port, and a granted approval lets the
+// same client send the same request once.
+type approvalStore struct {
+	mu    sync.Mutex
+	byID  map[string]*approval
+	byKey map[string]*approval
+}
+
+// approval is a held request. Its fields are listed on the admin port.
+type approval struct {
+	ID        string          `json:"id"`
+	Rule      string          `json:"rule"`
+	Upstream  string          `json:"upstream"`
+	Client    string          `json:"client,omitempty"`
+	SessionID string          `json:"sessionId,omitempty"`
+	Method    string          `json:"method"`
+	Target    string          `json:"target,omitempty"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+	Granted   bool            `json:"granted"`
+	Expires   time.Time       `json:"expires"`
+
+	key string
+}
+
+func newApprovalStore() *approvalStore {
+	return &approvalStore{
+		byID:  make(map[string]*approval),
+		byKey: make(map[string]*approval),
+	}
+}
+
+// approvalKey identifies a request for approval: the same client sending
+// the same method and params to the same upstream, ignoring _meta, which
+// carries per-attempt data such as trace context.
+func approvalKey(upstreamName, client, sessionID string, req *jsonrpc.Request) string {
+	var params map[string]json.RawMessage
+	if json.Unmarshal(req.Params, &params) == nil {
+		delete(params, "_meta")
+	}
+	// Marshalling a map sorts its keys, so equal params give equal bytes
+	canonical, _ := json.Marshal(params)
+	h := sha256.New()
+	for _, part := range []string{upstreamName, client, sessionID, req.Method, string(canonical)} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// take consumes the granted approval for key, if there is one.
+func (s *approvalStore) take(key string) (approval, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+	a, ok := s.byKey[key]
+	if !ok || !a.Granted {
+		return approval{}, false
+	}
+	s.remove(a)
+	return *a, true
+}
+
+// hold returns the pending approval for a.key, registering a if there is
+// none. Retrying a held request does not extend its wait.
+func (s *approvalStore) hold(a *approval, ttl time.Duration) *approval {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+	if existing, ok := s.byKey[a.key]; ok {
+		return existing
+	}
+	a.ID = mcp.NewSessionID()
+	a.Expires = time.Now().Add(ttl)
+	s.byID[a.ID] = a
+	s.byKey[a.key] = a
+	return a
+}
+
+// grant approves a pending request for ttl and returns it.
+func (s *approvalStore) grant(id string, ttl time.Duration) (approval, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+	a, ok := s.byID[id]
+	if !ok {
+		return approval{}, false
+	}
+	a.Granted = true
+	a.Expires = time.Now().Add(ttl)
+	return *a, true
+}
+
+// reject drops a pending or granted request and returns it.
+func (s *approvalStore) reject(id string) (approval, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.byID[id]
+	if !ok {
+		return approval{}, false
+	}
+	s.remove(a)
+	return *a, true
+}
+
+// list returns the held requests, oldest expiry first.
+func (s *approvalStore) list() []approval {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+	out := make([]approval, 0, len(s.byID))
+	for _, a := range s.byID {
+		out = append(out, *a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Expires.Before(out[j].Expires) })
+	return out
+}
+
+// sweep drops expired approvals. The caller holds mu.
+func (s *approvalStore) sweep() {
+	now := time.Now()
+	for _, a := range s.byID {
+		if now.After(a.Expires) {
+			s.remove(a)
+		}
+	}
+}
+
+// remove drops an approval. The caller holds mu.
+func (s *approvalStore) remove(a *approval) {
+	delete(s.byID, a.ID)
+	delete(s.byKey, a.key)
+}
+
+// RegisterApprovals adds the endpoints operators use to review requests
+// held by require-approval policy rules: GET /approvals lists them, POST
+// /approvals/{id} grants one and DELETE /approvals/{id} rejects it. Every
+// call must carry the bearer token of a configured approver.
+func (h *Handler) RegisterApprovals(mux *http.ServeMux) {
+	mux.HandleFunc("GET /approvals", h.withApprover(func(w http.ResponseWriter, r *http.Request, operator string) {
+		writeJSON(w, http.StatusOK, h.approvals.list())
+	}))
+	mux.HandleFunc("POST /approvals/{id}", h.withApprover(func(w http.ResponseWriter, r *http.Request, operator string) {
+		a, ok := h.approvals.grant(r.PathValue("id"), h.approvalTTL())
+		if !ok {
+			http.Error(w, "no such approval", http.StatusNotFound)
+			return
+		}
+		h.auditApproval(r, operator, a, "granted")
+		writeJSON(w, http.StatusOK, a)
+	}))
+	mux.HandleFunc("DELETE /approvals/{id}", h.withApprover(func(w http.ResponseWriter, r *http.Request, operator string) {
+		a, ok := h.approvals.reject(r.PathValue("id"))
+		if !ok {
+			http.Error(w, "no such approval", http.StatusNotFound)
+			return
+		}
+		h.auditApproval(r, operator, a, "rejected")
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+// withApprover authenticates the operator calling an approval endpoint by
+// bearer token and passes on the approver's name.
+func (h *Handler) withApprover(next func(w http.ResponseWriter, r *http.Request, operator string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		operator := ""
+		if ok {
+			operator = approverFor(h.config().Policy.Approvers, token)
+		}
+		if operator == "" {
+			h.logger.WarnContext(r.Context(), "policy approval request not authorized",
+				"http.request.method", r.Method,
+				"url.path", r.URL.Path,
+				"client.address", r.RemoteAddr,
+			)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="approvals"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r, operator)
+	}
+}
+
+// approverFor returns the name of the approver whose token matches, or "".
+// Every token is compared in constant time so the response time does not
+// reveal how much of a token was right.
+func approverFor(approvers map[string]string, token string) string {
+	operator := ""
+	for name, want := range approvers {
+		if want != "" && subtle.ConstantTimeCompare([]byte(token), []byte(want)) == 1 {
+			operator = name
+		}
+	}
+	return operator
+}
+
+func (h *Handler) approvalTTL() time.Duration {
+	return time.Duration(h.config().Policy.ApprovalTTLSeconds) * time.Second
+}
+
+// auditApproval writes the audit log entry of an operator's decision.
+func (h *Handler) auditApproval(r *http.Request, operator string, a approval, status string) {
+	h.logger.InfoContext(r.Context(), "policy approval",
+		"policy.rule", a.Rule,
+		"policy.approval.id", a.ID,
+		"policy.approval.status", status,
+		"policy.approval.operator", operator,
+		"client.id", a.Client,
+		"mcp.session.id", a.SessionID,
+		"mcp.method.name", a.Method,
+		"mcp.target", a.Target,
+		"upstream.name", a.Upstream,
+		"operator.address", r.RemoteAddr,
+	)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	body, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,238 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/jsonrpc"
+)
+
+func TestApprovalStore_HoldGrantTake(t *testing.T) {
+	s := newApprovalStore()
+	held := s.hold(&approval{Rule: "prod-scale", key: "k"}, time.Minute)
+	if again := s.hold(&approval{Rule: "prod-scale", key: "k"}, time.Minute); again.ID != held.ID {
+		t.Errorf("expected a retry to get approval %s, got %s", held.ID, again.ID)
+	}
+	if _, ok := s.take("k"); ok {
+		t.Fatal("expected no approval to take before it is granted")
+	}
+	if _, ok := s.grant("unknown", time.Minute); ok {
+		t.Error("expected granting an unknown approval to fail")
+	}
+
+	granted, ok := s.grant(held.ID, time.Minute)
+	if !ok || !granted.Granted {
+		t.Fatalf("expected approval %s to be granted, got %+v", held.ID, granted)
+	}
+	if got := s.list(); len(got) != 1 || !got[0].Granted {
+		t.Errorf("expected the granted approval to be listed, got %+v", got)
+	}
+	if taken, ok := s.take("k"); !ok || taken.ID != held.ID {
+		t.Fatalf("expected to take approval %s, got %+v", held.ID, taken)
+	}
+	if _, ok := s.take("k"); ok {
+		t.Error("expected a granted approval to be used once")
+	}
+	if got := s.list(); len(got) != 0 {
+		t.Errorf("expected no approvals left, got %+v", got)
+	}
+}
+
+func TestApprovalStore_Expiry(t *testing.T) {
+	tests := []struct {
+		name               string
+		holdTTL, grantTTL  time.Duration
+		wantListed, wantOK bool
+	}{
+		{"pending", time.Minute, 0, true, false},
+		{"granted", time.Minute, time.Minute, true, true},
+		{"expired while pending", -time.Second, 0, false, false},
+		{"expired once granted", time.Minute, -time.Second, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newApprovalStore()
+			held := s.hold(&approval{key: "k"}, tt.holdTTL)
+			if tt.grantTTL != 0 {
+				s.grant(held.ID, tt.grantTTL)
+			}
+			if listed := len(s.list()) == 1; listed != tt.wantListed {
+				t.Errorf("expected listed %v, got %v", tt.wantListed, listed)
+			}
+			if _, ok := s.take("k"); ok != tt.wantOK {
+				t.Errorf("expected take to report %v, got %v", tt.wantOK, ok)
+			}
+		})
+	}
+}
+
+func TestApprovalStore_Reject(t *testing.T) {
+	s := newApprovalStore()
+	held := s.hold(&approval{key: "k"}, time.Minute)
+	if _, ok := s.reject(held.ID); !ok {
+		t.Fatal("expected the held approval to be rejected")
+	}
+	if _, ok := s.reject(held.ID); ok {
+		t.Error("expected a rejected approval to be gone")
+	}
+	if _, ok := s.grant(held.ID, time.Minute); ok {
+		t.Error("expected a rejected approval not to be granted")
+	}
+	if again := s.hold(&approval{key: "k"}, time.Minute); again.ID == held.ID {
+		t.Error("expected a retry after rejection to be held anew")
+	}
+}
+
+func TestApprovalKey(t *testing.T) {
+	request := func(params string) *jsonrpc.Request {
+		return &jsonrpc.Request{Method: "tools/call", Params: json.RawMessage(params)}
+	}
+	base := approvalKey("k8s", "alice", "s1", request(`{"name":"scale","arguments":{"n":3}}`))
+	tests := []struct {
+		name                      string
+		upstream, client, session string
+		params                    string
+		wantSame                  bool
+	}{
+		{"same request", "k8s", "alice", "s1", `{"name":"scale","arguments":{"n":3}}`, true},
+		{"other _meta", "k8s", "alice", "s1", `{"_meta":{"progressToken":7},"arguments":{"n":3},"name":"scale"}`, true},
+		{"other arguments", "k8s", "alice", "s1", `{"name":"scale","arguments":{"n":30}}`, false},
+		{"other session", "k8s", "alice", "s2", `{"name":"scale","arguments":{"n":3}}`, false},
+		{"other client", "k8s", "bob", "s1", `{"name":"scale","arguments":{"n":3}}`, false},
+		{"other upstream", "git", "alice", "s1", `{"name":"scale","arguments":{"n":3}}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key := approvalKey(tt.upstream, tt.client, tt.session, request(tt.params))
+			if same := key == base; same != tt.wantSame {
+				t.Errorf("expected same key %v, got %v", tt.wantSame, same)
+			}
+		})
+	}
+}
+
+func TestApproverFor(t *testing.T) {
+	approvers := map[string]string{"alice": "alice-token-0123456789", "bob": "bob-token-0123456789", "nobody": ""}
+	tests := []struct {
+		token, want string
+	}{
+		{"alice-token-0123456789", "alice"},
+		{"bob-token-0123456789", "bob"},
+		{"alice-token-012345678", ""},
+		{"alice-token-01234567890", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := approverFor(approvers, tt.token); got != tt.want {
+			t.Errorf("approverFor(%q) = %q, expected %q", tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestApprovalEndpoints(t *testing.T) {
+	h := newTestHandler(t, `
+upstreams:
+  - name: up
+    url: http://127.0.0.1:1
+policy:
+  approvers:
+    alice: alice-token-0123456789
+  rules:
+    - name: prod-scale
+      action: require-approval
+      tools: [scale]
+`)
+	mux := http.NewServeMux()
+	h.RegisterApprovals(mux)
+	granted := h.approvals.hold(&approval{Rule: "prod-scale", key: "granted"}, time.Minute)
+	rejected := h.approvals.hold(&approval{Rule: "prod-scale", key: "rejected"}, time.Minute)
+
+	// The steps share the store, so they run in order
+	tests := []struct {
+		name, method, path, auth string
+		wantStatus               int
+	}{
+		{"no token", http.MethodGet, "/approvals", "", http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "/approvals", "Bearer bob-token-0123456789", http.StatusUnauthorized},
+		{"not a bearer token", http.MethodGet, "/approvals", "alice-token-0123456789", http.StatusUnauthorized},
+		{"grant without token", http.MethodPost, "/approvals/" + granted.ID, "", http.StatusUnauthorized},
+		{"list", http.MethodGet, "/approvals", "Bearer alice-token-0123456789", http.StatusOK},
+		{"grant", http.MethodPost, "/approvals/" + granted.ID, "Bearer alice-token-0123456789", http.StatusOK},
+		{"grant unknown", http.MethodPost, "/approvals/unknown", "Bearer alice-token-0123456789", http.StatusNotFound},
+		{"reject", http.MethodDelete, "/approvals/" + rejected.ID, "Bearer alice-token-0123456789", http.StatusNoContent},
+		{"reject again", http.MethodDelete, "/approvals/" + rejected.ID, "Bearer alice-token-0123456789", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: expected %d, got %d", tt.name, tt.wantStatus, rec.Code)
+		}
+	}
+
+	if _, ok := h.approvals.take("granted"); !ok {
+		t.Error("expected the approval granted on the endpoint to be usable")
+	}
+}
+
+func TestServeHTTP_ApprovalFlow(t *testing.T) {
+	up, forwarded := countingUpstream(t)
+	h := newTestHandler(t, `
+upstreams:
+  - name: up
+    url: `+up.URL+`
+policy:
+  approvers:
+    alice: alice-token-0123456789
+  rules:
+    - name: prod-scale
+      action: require-approval
+      tools: [scale]
+`)
+	call := func(meta string) *httptest.ResponseRecorder {
+		body := `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"scale","arguments":{"n":3}` + meta + `}}`
+		req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	approvalID := func(rec *httptest.ResponseRecorder) string {
+		t.Helper()
+		var resp jsonrpc.Response
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		var data struct {
+			ApprovalID string `json:"approvalId"`
+		}
+		if resp.Error == nil || resp.Error.Code != approvalRequiredCode || json.Unmarshal(resp.Error.Data, &data) != nil {
+			t.Fatalf("expected the call to be held, got %s", rec.Body)
+		}
+		return data.ApprovalID
+	}
+
+	id := approvalID(call(""))
+	if retry := approvalID(call(`,"_meta":{"progressToken":1}`)); retry != id {
+		t.Errorf("expected a retry to wait for approval %s, got %s", id, retry)
+	}
+	if forwarded.Load() != 0 {
+		t.Fatal("expected the held call not to reach the upstream")
+	}
+
+	if _, ok := h.approvals.grant(id, time.Minute); !ok {
+		t.Fatalf("expected approval %s to be pending", id)
+	}
+	if rec := call(""); rec.Code != http.StatusOK || forwarded.Load() != 1 {
+		t.Fatalf("expected the approved call to be forwarded, got %d: %s", rec.Code, rec.Body)
+	}
+	if again := approvalID(call("")); again == id {
+		t.Error("expected the approval to be used up by the forwarded call")
+	}
+}
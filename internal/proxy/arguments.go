@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/config"
+	"github.com/isitobservable/mcp-otel-proxy/internal/jsonrpc"
+	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
+	"github.com/isitobservable/mcp-otel-proxy/internal/schema"
+	"github.com/isitobservable/mcp-otel-proxy/internal/telemetry"
+)
+
+// argumentViolations checks the arguments of a tools/call request against
+// the inputSchema the session's tools/list declared for the tool. It
+// returns the validation mode of the tool and the violations found; there
+// are none when validation is off or the tool's schema is unknown.
+func (h *Handler) argumentViolations(up *upstream, session *mcp.Session, reqInfo *mcp.RequestInfo) (string, []schema.Violation) {
+	mode := up.settings.Load().ToolInputValidation(reqInfo.ToolName)
+	if reqInfo.Method != "tools/call" || mode == config.InputValidationOff || session == nil {
+		return mode, nil
+	}
+	def := session.Tool(reqInfo.ToolName)
+	if def == nil || def.InputSchema == nil {
+		return mode, nil
+	}
+	// Arguments are optional and default to an empty object
+	args := reqInfo.ToolArguments
+	if len(args) == 0 || string(args) == "null" {
+		args = json.RawMessage(`{}`)
+	}
+	violations, err := def.InputSchema.ValidateJSON(args)
+	if err != nil {
+		return mode, nil
+	}
+	return mode, violations
+}
+
+// reportedViolations returns at most maxReportedViolations of violations
+// as strings.
+func reportedViolations(violations []schema.Violation) []string {
+	reported := make([]string, 0, min(len(violations), maxReportedViolations))
+	for _, v := range violations[:cap(reported)] {
+		reported = append(reported, v.String())
+	}
+	return reported
+}
+
+// recordArgumentViolations adds a span event, the failure metric and a log
+// line for a tool call whose arguments fail the tool's inputSchema.
+func (h *Handler) recordArgumentViolations(ctx context.Context, span trace.Span, up *upstream, reqInfo *mcp.RequestInfo, mode string, violations []schema.Violation) {
+	reported := reportedViolations(violations)
+	span.AddEvent("mcp.request.input_schema.violation", trace.WithAttributes(
+		attribute.String("mcp.proxy.input_validation.action", mode),
+		attribute.Int("mcp.request.input_schema.violation_count", len(violations)),
+		attribute.StringSlice("mcp.request.input_schema.violations", reported),
+	))
+	h.metrics.InputSchemaFailures.Add(ctx, 1,
+		telemetry.MethodToolAttrs(reqInfo.Method, reqInfo.ToolName),
+		telemetry.InputValidationAttr(mode),
+		telemetry.UpstreamAttr(up.name),
+	)
+	h.logger.WarnContext(ctx, "tool arguments violate the input schema",
+		"gen_ai.tool.name", reqInfo.ToolName,
+		"jsonrpc.request.id", reqInfo.RequestID,
+		"action", mode,
+		"violations", reported,
+		"upstream.name", up.name,
+	)
+}
+
+// warnArguments records arguments that fail their inputSchema on the span
+// of a request that is forwarded anyway.
+func (h *Handler) warnArguments(ctx context.Context, span trace.Span, up *upstream, session *mcp.Session, reqInfo *mcp.RequestInfo) {
+	if mode, violations := h.argumentViolations(up, session, reqInfo); mode == config.InputValidationWarn && len(violations) > 0 {
+		h.recordArgumentViolations(ctx, span, up, reqInfo, mode, violations)
+	}
+}
+
+// enforceInputSchemas answers the request itself with an invalid params
+// error when arguments of any of its tool calls fail their inputSchema and
+// the tool rejects them, and reports whether it did. Like policy denials, a
+// batch is rejected as a whole.
+func (h *Handler) enforceInputSchemas(w http.ResponseWriter, r *http.Request, up *upstream, parsed *jsonrpc.ParseResult) bool {
+	sessionID := sessionKey(r, up)
+	if sessionID == "" {
+		return false
+	}
+	session := h.sessions.Get(sessionID)
+	if session == nil {
+		return false
+	}
+
+	rejected := make(map[int][]schema.Violation)
+	for i := range parsed.Requests {
+		req := &parsed.Requests[i]
+		if req.Method != "tools/call" {
+			continue
+		}
+		if mode, violations := h.argumentViolations(up, session, mcp.ExtractRequestInfo(req)); mode == config.InputValidationReject && len(violations) > 0 {
+			rejected[i] = violations
+		}
+	}
+	if len(rejected) == 0 {
+		return false
+	}
+
+	var responses []jsonrpc.Response
+	for i := range parsed.Requests {
+		req := &parsed.Requests[i]
+		if req.Method == "" {
+			continue
+		}
+		rpcErr := &jsonrpc.Error{Code: invalidParamsCode, Message: "batch rejected: another request in it has invalid arguments"}
+		if violations, ok := rejected[i]; ok {
+			rpcErr = h.rejectArguments(r, up, req, session, violations)
+		}
+		if !req.IsNotification() {
+			responses = append(responses, jsonrpc.Response{JSONRPC: "2.0", ID: req.ID, Error: rpcErr})
+		}
+	}
+
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return true
+	}
+	var body []byte
+	if parsed.IsBatch {
+		body, _ = json.Marshal(responses)
+	} else {
+		body, _ = json.Marshal(responses[0])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+	return true
+}
+
+// rejectArguments gives a rejected tool call its span, metrics and log line
+// and returns its error. The message lists the failing paths so the model
+// can correct the call; data carries every violation.
+func (h *Handler) rejectArguments(r *http.Request, up *upstream, req *jsonrpc.Request, session *mcp.Session, violations []schema.Violation) *jsonrpc.Error {
+	reqInfo := mcp.ExtractRequestInfo(req)
+	ctx := telemetry.ExtractContextFromMeta(r.Context(), req.Params, propagation.HeaderCarrier(r.Header))
+	ctx, span := telemetry.StartMCPSpan(ctx, reqInfo, session, up.peer)
+	defer span.End()
+	h.recordPolicy(ctx, span, r, up, req, reqInfo)
+
+	h.metrics.RequestCount.Add(ctx, 1, telemetry.MethodToolAttrs(reqInfo.Method, reqInfo.ToolName), telemetry.UpstreamAttr(up.name))
+	h.recordArgumentViolations(ctx, span, up, reqInfo, config.InputValidationReject, violations)
+	span.SetAttributes(attribute.String("error.type", "input_schema_violation"))
+	span.SetStatus(codes.Error, "arguments violate the input schema")
+	h.metrics.ErrorsTotal.Add(ctx, 1, telemetry.ErrorAttr("input_schema_violation"), telemetry.UpstreamAttr(up.name))
+
+	all := make([]map[string]string, len(violations))
+	for i, v := range violations {
+		all[i] = map[string]string{"path": v.Path, "message": v.Message}
+	}
+	data, _ := json.Marshal(map[string]any{"violations": all})
+	return &jsonrpc.Error{
+		Code:    invalidParamsCode,
+		Message: fmt.Sprintf("invalid arguments for tool %q: %s", reqInfo.ToolName, strings.Join(reportedViolations(violations), "; ")),
+		Data:    data,
+	}
+}
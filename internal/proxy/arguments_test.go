@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/jsonrpc"
+)
+
+// toolsUpstream lists the tool scale, whose inputSchema requires an integer
+// replicas, and the tool free, which has no inputSchema. It counts the tool
+// calls that reached it.
+func toolsUpstream(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var calls atomic.Int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		_ = json.Unmarshal(body, &req)
+		result := `{}`
+		switch req.Method {
+		case "initialize":
+			w.Header().Set("Mcp-Session-Id", "up-1")
+			result = `{"protocolVersion":"2025-06-18"}`
+		case "tools/list":
+			result = `{"tools":[
+				{"name":"scale","inputSchema":{"type":"object","properties":{"replicas":{"type":"integer"}},"required":["replicas"]}},
+				{"name":"free"}
+			]}`
+		case "tools/call":
+			calls.Add(1)
+			result = `{"content":[{"type":"text","text":"done"}]}`
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":%s}`, req.ID, result)
+	}))
+	t.Cleanup(up.Close)
+	return up, &calls
+}
+
+func TestServeHTTP_EnforceInputSchemas(t *testing.T) {
+	tests := []struct {
+		name, mode     string
+		warm           bool
+		tool, args     string
+		wantForwarded  bool
+		wantViolations []string
+		wantMetric     int64
+	}{
+		{"reject, valid", "reject", true, "scale", `{"replicas":3}`, true, nil, 0},
+		{"reject, wrong type", "reject", true, "scale", `{"replicas":"3"}`, false, []string{"$.replicas"}, 1},
+		{"reject, missing", "reject", true, "scale", `{}`, false, []string{"$"}, 1},
+		{"reject, no arguments", "reject", true, "scale", ``, false, []string{"$"}, 1},
+		{"warn", "warn", true, "scale", `{"replicas":"3"}`, true, nil, 1},
+		{"off", "off", true, "scale", `{"replicas":"3"}`, true, nil, 0},
+		{"cold cache", "reject", false, "scale", `{"replicas":"3"}`, true, nil, 0},
+		{"no inputSchema", "reject", true, "free", `{"anything":1}`, true, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := recordMetrics(t)
+			up, calls := toolsUpstream(t)
+			h := newTestHandler(t, "upstreams:\n  - name: up\n    url: "+up.URL+"\n    validateInputSchema: "+tt.mode+"\n")
+			sessionID := initializeSession(t, h)
+			if tt.warm {
+				send(h, http.MethodPost, sessionID, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`)
+			}
+
+			params := `{"name":"` + tt.tool + `"}`
+			if tt.args != "" {
+				params = `{"name":"` + tt.tool + `","arguments":` + tt.args + `}`
+			}
+			rec := send(h, http.MethodPost, sessionID, `{"jsonrpc":"2.0","id":2,"method":"tools/call","params":`+params+`}`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+			}
+			if forwarded := calls.Load() == 1; forwarded != tt.wantForwarded {
+				t.Errorf("expected forwarded %v, got %v", tt.wantForwarded, forwarded)
+			}
+			action := attribute.String("mcp.proxy.input_validation.action", tt.mode)
+			if n := counterSum(t, reader, "mcp.proxy.tool.input_schema_failures", action); n != tt.wantMetric {
+				t.Errorf("expected %d input schema failures, got %d", tt.wantMetric, n)
+			}
+			if tt.wantForwarded {
+				return
+			}
+
+			var resp jsonrpc.Response
+			_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+			if resp.Error == nil || resp.Error.Code != invalidParamsCode || string(resp.ID) != "2" {
+				t.Fatalf("expected an invalid params error for request 2, got %s", rec.Body)
+			}
+			var data struct {
+				Violations []struct{ Path, Message string } `json:"violations"`
+			}
+			if err := json.Unmarshal(resp.Error.Data, &data); err != nil || len(data.Violations) != len(tt.wantViolations) {
+				t.Fatalf("expected %d violations in the error data, got %s", len(tt.wantViolations), resp.Error.Data)
+			}
+			for i, v := range data.Violations {
+				if v.Path != tt.wantViolations[i] || v.Message == "" {
+					t.Errorf("expected a violation at %q, got %+v", tt.wantViolations[i], v)
+				}
+			}
+			if !strings.Contains(resp.Error.Message, `"scale"`) {
+				t.Errorf("expected the message to name the tool, got %s", resp.Error.Message)
+			}
+		})
+	}
+}
@@ -0,0 +1,315 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/jsonrpc"
+	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
+	"github.com/isitobservable/mcp-otel-proxy/internal/telemetry"
+)
+
+// batchElement is one request of a JSON-RPC batch with its own span.
+type batchElement struct {
+	req  *jsonrpc.Request
+	raw  json.RawMessage
+	info *mcp.RequestInfo
+	ctx  context.Context
+	span trace.Span
+	// index is the element's position in the client's batch.
+	index int
+}
+
+// handleBatch forwards a JSON-RPC batch as one upstream request. Every
+// element gets a child span of the batch span and is matched to its
+// response by JSON-RPC ID, so errors, metrics, compression, truncation and
+// payload capture apply per element as they do to single requests. Calls
+// of the page tool are answered by the proxy and left out of the upstream
+// request.
+func (h *Handler) handleBatch(w http.ResponseWriter, r *http.Request, up *upstream, reqBody []byte, parsed *jsonrpc.ParseResult, start time.Time) {
+	ctx := telemetry.ExtractContextFromMeta(r.Context(), nil, propagation.HeaderCarrier(r.Header))
+
+	sessionID := r.Header.Get("Mcp-Session-Id")
+	var session *mcp.Session
+	if sessionID != "" {
+		session = h.sessions.Get(sessionID)
+	}
+
+	batchInfo := &mcp.RequestInfo{Method: "batch"}
+	ctx, batchSpan := telemetry.StartMCPSpan(ctx, batchInfo, session, up.peer)
+	batchSpan.SetAttributes(attribute.Int("jsonrpc.batch.size", len(parsed.Requests)))
+	defer batchSpan.End()
+
+	var rawReqs []json.RawMessage
+	_ = json.Unmarshal(reqBody, &rawReqs)
+
+	upstreamAttr := telemetry.UpstreamAttr(up.name)
+	elems := make([]batchElement, 0, len(parsed.Requests))
+	var local []json.RawMessage
+	for i := range parsed.Requests {
+		req := &parsed.Requests[i]
+		if resp, ok := h.pageResponse(ctx, r, up, req); ok {
+			if !req.IsNotification() {
+				body, _ := json.Marshal(resp)
+				local = append(local, body)
+			}
+			continue
+		}
+		e := batchElement{req: req, info: mcp.ExtractRequestInfo(req), index: i}
+		if i < len(rawReqs) {
+			e.raw = rawReqs[i]
+		}
+		e.ctx, e.span = telemetry.StartMCPSpan(ctx, e.info, session, up.peer)
+		h.metrics.RequestCount.Add(e.ctx, 1, telemetry.MethodToolAttrs(e.info.Method, e.info.ToolName), upstreamAttr)
+		h.recordPolicy(e.ctx, e.span, r, up, e.req, e.info)
+		h.warnArguments(e.ctx, e.span, up, session, e.info)
+		elems = append(elems, e)
+	}
+
+	if len(elems) == 0 {
+		if len(local) == 0 {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		body, _ := json.Marshal(local)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(body)
+		return
+	}
+
+	// Only the elements left for the upstream are sent
+	bodyToSend := reqBody
+	if len(elems) < len(parsed.Requests) {
+		forwarded := make([]json.RawMessage, len(elems))
+		for i, e := range elems {
+			forwarded[i] = e.raw
+		}
+		if body, err := json.Marshal(forwarded); err == nil {
+			bodyToSend = body
+		}
+	}
+
+	// Each element carries its own span's context upstream
+	if h.config().ContextPropagation {
+		elemCtxs := make([]context.Context, len(elems))
+		for i, e := range elems {
+			elemCtxs[i] = e.ctx
+		}
+		modified, err := telemetry.InjectContextIntoBatchBody(elemCtxs, bodyToSend)
+		if err == nil {
+			bodyToSend = modified
+		}
+	}
+
+	// Forward to upstream
+	upstreamStart := time.Now()
+	respBody, respHeaders, statusCode, err := h.doUpstreamRequest(ctx, r, up, bodyToSend)
+	upstreamDuration := time.Since(upstreamStart)
+
+	if err != nil {
+		h.logger.ErrorContext(ctx, "upstream batch request failed",
+			"error", err,
+			"upstream.name", up.name,
+			"upstream.url", up.url.String(),
+		)
+		h.metrics.ErrorsTotal.Add(ctx, 1, telemetry.ErrorAttr("upstream_error"), upstreamAttr)
+		batchSpan.SetAttributes(attribute.String("error.type", "upstream_error"))
+		for _, e := range elems {
+			e.span.SetAttributes(attribute.String("error.type", "upstream_error"))
+			e.span.End()
+		}
+		http.Error(w, "upstream request failed", http.StatusBadGateway)
+		return
+	}
+
+	reply := parseBatchReply(respBody, respHeaders)
+	byID := make(map[string]int, len(reply.responses))
+	for i := range reply.responses {
+		if id := jsonrpc.IDString(reply.responses[i].ID); id != "" {
+			byID[id] = i
+		}
+	}
+
+	for i := range elems {
+		e := &elems[i]
+		var respInfo *mcp.ResponseInfo
+		if j, ok := byID[e.info.RequestID]; ok && !e.req.IsNotification() {
+			resp := &reply.responses[j]
+			respInfo = h.observeResponse(e.ctx, e.span, r, up, e.req, e.info, e.raw, sessionID, "", resp, respHeaders)
+			if rewrite := h.finalRewrite(e.ctx, e.span, up, session, sessionID, e.info); rewrite != nil {
+				reply.rewrite(j, rewrite)
+			}
+		}
+
+		h.metrics.UpstreamLatency.Record(e.ctx, upstreamDuration.Seconds(), telemetry.MethodToolAttrs(e.info.Method, e.info.ToolName), upstreamAttr)
+		h.metrics.RequestDuration.Record(e.ctx, time.Since(start).Seconds(),
+			telemetry.MethodToolErrorAttrs(e.info.Method, e.info.ToolName, respInfo), upstreamAttr)
+		telemetry.EndMCPSpan(e.span, respInfo)
+
+		errType := ""
+		logLevel := slog.LevelDebug
+		if respInfo != nil && respInfo.HasError {
+			errType = respInfo.ErrorType()
+			logLevel = slog.LevelError
+		}
+		h.logger.Log(e.ctx, logLevel, "MCP response",
+			"mcp.method.name", e.info.Method,
+			"gen_ai.tool.name", e.info.ToolName,
+			"jsonrpc.request.id", e.info.RequestID,
+			"jsonrpc.batch.index", e.index,
+			"upstream.name", up.name,
+			"error.type", errType,
+		)
+	}
+
+	// The page tool's answers join the upstream's, unless the batch failed
+	if statusCode >= http.StatusBadRequest {
+		local = nil
+	}
+	if len(local) > 0 && statusCode == http.StatusAccepted {
+		statusCode = http.StatusOK
+		respHeaders.Set("Content-Type", "application/json")
+	}
+	if body := reply.body(respBody, local); !bytes.Equal(body, respBody) {
+		respBody = body
+		respHeaders.Del("Content-Length")
+	}
+
+	h.metrics.MessageSize.Record(ctx, int64(len(reqBody)), telemetry.DirectionAttr("request"), telemetry.MethodAttr("batch"), upstreamAttr)
+	h.metrics.MessageSize.Record(ctx, int64(len(respBody)), telemetry.DirectionAttr("response"), telemetry.MethodAttr("batch"), upstreamAttr)
+
+	// Write response
+	fromUpstreamSession(respHeaders, sessionID)
+	copyHeaders(w.Header(), respHeaders)
+	w.WriteHeader(statusCode)
+	if _, err := w.Write(respBody); err != nil {
+		h.logger.ErrorContext(ctx, "failed to write batch response to client", "error", err)
+	}
+}
+
+// batchReply is an upstream reply to a batch, split into the responses it
+// carries so that each can be rewritten and the reply put back together.
+type batchReply struct {
+	responses []jsonrpc.Response
+	// raws holds each response as received, index-aligned with responses.
+	raws    []json.RawMessage
+	changed []bool
+	// messages are the payloads of the reply: its body, or every event of
+	// an SSE reply.
+	messages []replyMessage
+	sse      bool
+}
+
+// replyMessage is one payload of a batch reply, carrying
+// responses[first:first+n].
+type replyMessage struct {
+	// event is the SSE event carrying the payload; nil for a JSON reply.
+	event    *sseEvent
+	array    bool
+	first, n int
+}
+
+func parseBatchReply(body []byte, header http.Header) *batchReply {
+	b := &batchReply{sse: isEventStream(header)}
+	if !b.sse {
+		b.add(nil, body)
+		return b
+	}
+	events := newSSEReader(bytes.NewReader(body))
+	for {
+		ev, err := events.next()
+		if err != nil {
+			return b
+		}
+		b.add(ev, ev.data)
+	}
+}
+
+// add appends the responses in one payload of the reply. Payloads that are
+// not JSON, such as an empty priming event, carry none.
+func (b *batchReply) add(ev *sseEvent, data []byte) {
+	m := replyMessage{event: ev, first: len(b.responses)}
+	var raws []json.RawMessage
+	if json.Unmarshal(data, &raws) == nil {
+		m.array = true
+	} else if json.Valid(data) {
+		raws = []json.RawMessage{data}
+	}
+	for _, raw := range raws {
+		// A malformed element keeps a zero response, which matches no request
+		var resp jsonrpc.Response
+		_ = json.Unmarshal(raw, &resp)
+		b.responses = append(b.responses, resp)
+		b.raws = append(b.raws, raw)
+		b.changed = append(b.changed, false)
+	}
+	m.n = len(raws)
+	b.messages = append(b.messages, m)
+}
+
+// rewrite applies rewrite to the response at index j.
+func (b *batchReply) rewrite(j int, rewrite rewriteFunc) {
+	before := b.raws[j]
+	b.raws[j] = rewrite(&b.responses[j], before)
+	b.changed[j] = b.changed[j] || !bytes.Equal(before, b.raws[j])
+}
+
+// body returns the reply with its rewritten responses, and the responses in
+// extra added at the end. It returns orig when neither changes anything.
+func (b *batchReply) body(orig []byte, extra []json.RawMessage) []byte {
+	if len(extra) == 0 && !slices.Contains(b.changed, true) {
+		return orig
+	}
+	if !b.sse {
+		m := b.messages[0]
+		if !m.array && m.n == 0 {
+			// An empty 202 reply: the extra responses are the whole reply
+			body, _ := json.Marshal(extra)
+			return body
+		}
+		if !m.array && len(extra) == 0 {
+			return b.raws[0]
+		}
+		body, err := json.Marshal(append(slices.Clone(b.raws), extra...))
+		if err != nil {
+			return orig
+		}
+		return body
+	}
+
+	var out []byte
+	for _, m := range b.messages {
+		ev := m.event
+		if slices.Contains(b.changed[m.first:m.first+m.n], true) {
+			if data, err := m.data(b.raws[m.first : m.first+m.n]); err == nil {
+				ev = ev.withData(data)
+			}
+		}
+		out = append(out, ev.raw...)
+	}
+	for _, raw := range extra {
+		out = append(out, "event: message\ndata: "...)
+		out = append(out, raw...)
+		out = append(out, "\n\n"...)
+	}
+	return out
+}
+
+// data encodes the responses of m on one line, as an SSE data field needs.
+func (m replyMessage) data(raws []json.RawMessage) ([]byte, error) {
+	if m.array {
+		return json.Marshal(raws)
+	}
+	var buf bytes.Buffer
+	err := json.Compact(&buf, raws[0])
+	return buf.Bytes(), err
+}
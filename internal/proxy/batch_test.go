@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// Reply shapes of batchUpstream.
+const (
+	replyJSON        = "json"
+	replySSEEvents   = "sse events"
+	replySSEOneEvent = "sse one event"
+)
+
+// batchUpstream answers a batch in the given shape. tools/list lists
+// get_pods and delete_ns; other requests get an empty result. It counts
+// the requests that reached it.
+func batchUpstream(t *testing.T, shape string) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var received atomic.Int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var reqs []struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		_ = json.Unmarshal(body, &reqs)
+		received.Add(int32(len(reqs)))
+
+		var resps []string
+		for _, req := range reqs {
+			if len(req.ID) == 0 {
+				continue
+			}
+			result := `{}`
+			if req.Method == "tools/list" {
+				result = `{"tools":[{"name":"get_pods"},{"name":"delete_ns"}]}`
+			}
+			resps = append(resps, fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":%s}`, req.ID, result))
+		}
+		if len(resps) == 0 {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		switch shape {
+		case replyJSON:
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, "["+strings.Join(resps, ",")+"]")
+		case replySSEEvents:
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, ": priming\n\n")
+			for i, resp := range resps {
+				_, _ = fmt.Fprintf(w, "id: %d\nevent: message\ndata: %s\n\n", i, resp)
+			}
+		case replySSEOneEvent:
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = fmt.Fprintf(w, "event: message\ndata: [%s]\n\n", strings.Join(resps, ","))
+		}
+	}))
+	t.Cleanup(up.Close)
+	return up, &received
+}
+
+// batchResults returns the results in a batch reply, JSON or SSE, keyed by
+// request ID.
+func batchResults(t *testing.T, rec *httptest.ResponseRecorder) map[string]string {
+	t.Helper()
+	var payloads []string
+	if isEventStream(rec.Header()) {
+		for _, line := range strings.Split(rec.Body.String(), "\n") {
+			if d, ok := strings.CutPrefix(line, "data: "); ok {
+				payloads = append(payloads, d)
+			}
+		}
+	} else {
+		payloads = []string{rec.Body.String()}
+	}
+	results := map[string]string{}
+	for _, p := range payloads {
+		var resps []struct {
+			ID     json.RawMessage `json:"id"`
+			Result json.RawMessage `json:"result"`
+		}
+		if !strings.HasPrefix(p, "[") {
+			p = "[" + p + "]"
+		}
+		if err := json.Unmarshal([]byte(p), &resps); err != nil {
+			t.Fatalf("expected JSON-RPC responses, got %s", p)
+		}
+		for _, resp := range resps {
+			results[string(resp.ID)] = string(resp.Result)
+		}
+	}
+	return results
+}
+
+func postBatch(h *Handler, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestHandleBatch_RewritesEveryReplyShape(t *testing.T) {
+	for _, shape := range []string{replyJSON, replySSEEvents, replySSEOneEvent} {
+		t.Run(shape, func(t *testing.T) {
+			up, received := batchUpstream(t, shape)
+			h := newTestHandler(t, `
+upstreams:
+  - name: up
+    url: `+up.URL+`
+    maxResponseTokens: 1000
+policy:
+  rules:
+    - name: no-deletes
+      action: deny
+      tools: ["delete_*"]
+`)
+			rec := postBatch(h, `[
+				{"jsonrpc":"2.0","id":1,"method":"tools/list"},
+				{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"proxy_next_page","arguments":{"cursor":"gone:1"}}},
+				{"jsonrpc":"2.0","id":3,"method":"ping"}
+			]`)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+			}
+			if n := received.Load(); n != 2 {
+				t.Errorf("expected the page call to stay in the proxy, the upstream got %d requests", n)
+			}
+			if rec.Header().Get("Content-Length") != "" {
+				t.Error("expected the stale Content-Length to be dropped")
+			}
+
+			results := batchResults(t, rec)
+			if len(results) != 3 {
+				t.Fatalf("expected three responses, got %v", results)
+			}
+			if list := results["1"]; !strings.Contains(list, "get_pods") || strings.Contains(list, "delete_ns") || !strings.Contains(list, pageToolName) {
+				t.Errorf("expected the list filtered and given the page tool, got %s", list)
+			}
+			if page := results["2"]; !strings.Contains(page, "no longer available") {
+				t.Errorf("expected the proxy to answer the page call, got %s", page)
+			}
+			if results["3"] != "{}" {
+				t.Errorf("expected the ping result unchanged, got %s", results["3"])
+			}
+		})
+	}
+}
+
+func TestHandleBatch_PageCallsOnly(t *testing.T) {
+	tests := []struct {
+		name, body   string
+		wantStatus   int
+		wantReceived int32
+		wantResults  int
+	}{
+		{"page calls only", `[{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"proxy_next_page","arguments":{"cursor":"gone:1"}}}]`,
+			http.StatusOK, 0, 1},
+		{"page call and a notification", `[{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"proxy_next_page","arguments":{"cursor":"gone:1"}}},{"jsonrpc":"2.0","method":"notifications/initialized"}]`,
+			http.StatusOK, 1, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up, received := batchUpstream(t, replyJSON)
+			h := newTestHandler(t, "upstreams:\n  - name: up\n    url: "+up.URL+"\n    maxResponseTokens: 1000\n")
+			rec := postBatch(h, tt.body)
+			if rec.Code != tt.wantStatus || received.Load() != tt.wantReceived {
+				t.Fatalf("expected %d with %d upstream requests, got %d with %d: %s", tt.wantStatus, tt.wantReceived, rec.Code, received.Load(), rec.Body)
+			}
+			if results := batchResults(t, rec); len(results) != tt.wantResults {
+				t.Errorf("expected %d responses, got %v", tt.wantResults, results)
+			}
+		})
+	}
+}
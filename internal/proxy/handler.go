@@ -1,7 +1,6 @@
 package proxy
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -13,6 +12,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.opentelemetry.io/otel"
@@ -22,110 +22,328 @@ import (
 
 	"github.com/isitobservable/mcp-otel-proxy/internal/compress"
 	"github.com/isitobservable/mcp-otel-proxy/internal/config"
+	"github.com/isitobservable/mcp-otel-proxy/internal/identity"
 	"github.com/isitobservable/mcp-otel-proxy/internal/jsonrpc"
 	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
 	"github.com/isitobservable/mcp-otel-proxy/internal/telemetry"
+	"github.com/isitobservable/mcp-otel-proxy/internal/tokens"
 )
 
 // Handler is the MCP proxy HTTP handler.
 type Handler struct {
-	upstream   *url.URL
-	client     *http.Client
-	config     *config.Config
-	metrics    *telemetry.Metrics
-	sessions   *mcp.SessionStore
-	logger     *slog.Logger
-	serverAddr string
-	serverPort int
+	// state is swapped as a whole on reload; a request keeps the upstream
+	// it was routed to even if the state changes underneath it.
+	state          atomic.Pointer[handlerState]
+	metrics        *telemetry.Metrics
+	sessions       *mcp.SessionStore
+	logger         *slog.Logger
 	clientSessions sync.Map
-	reinit         *reinitializer
+	// legacySessions maps the session IDs the proxy puts in legacy
+	// endpoint events to the upstream's.
+	legacySessions sync.Map
+	// pending holds spans for server-initiated requests awaiting the
+	// client's response.
+	pending *pendingCalls
+	// replay buffers SSE events for Last-Event-ID resumption; nil when
+	// disabled.
+	replay *replayBuffer
+	// pages holds the rest of tool results truncated to their budget.
+	pages *pageStore
+	// approvals holds requests waiting for an operator's approval.
+	approvals *approvalStore
 }
 
-// New creates a new proxy handler.
+// handlerState is the part of the handler that changes on reload.
+type handlerState struct {
+	config   *config.Config
+	router   *router
+	identity identity.ClientIdentity
+	// tokens estimates the LLM tokens compression saves.
+	tokens tokens.Counter
+}
+
+// New creates a new proxy handler routing to every configured upstream.
 func New(cfg *config.Config, metrics *telemetry.Metrics, sessions *mcp.SessionStore, logger *slog.Logger) (*Handler, error) {
-	u, err := url.Parse(cfg.UpstreamURL)
+	h := &Handler{
+		metrics:   metrics,
+		sessions:  sessions,
+		logger:    logger,
+		pending:   newPendingCalls(),
+		pages:     newPageStore(cfg.PageStoreSizeMB<<20, time.Duration(cfg.PageStoreRetentionSeconds)*time.Second),
+		approvals: newApprovalStore(),
+	}
+	if cfg.SSEBufferSize > 0 {
+		h.replay = newReplayBuffer(cfg.SSEBufferSize, time.Duration(cfg.SSEBufferRetentionSeconds)*time.Second)
+	}
+
+	state, _, err := h.buildState(cfg, nil)
 	if err != nil {
 		return nil, err
 	}
+	h.state.Store(state)
+	return h, nil
+}
+
+// Reload switches the handler to cfg. Upstreams whose connection settings
+// are unchanged are kept, along with their sessions and stdio processes.
+// Requests already in flight finish on the upstream they started on;
+// upstreams that are no longer used are closed once they drain.
+func (h *Handler) Reload(cfg *config.Config) error {
+	old := h.state.Load()
+	state, retired, err := h.buildState(cfg, old.router.upstreams)
+	if err != nil {
+		return err
+	}
+	h.state.Store(state)
+	for _, up := range retired {
+		go up.retire()
+	}
+	return nil
+}
+
+// buildState creates the routing state for cfg, reusing upstreams from
+// current where possible. It returns the upstreams of current that are not
+// reused.
+func (h *Handler) buildState(cfg *config.Config, current []*upstream) (*handlerState, []*upstream, error) {
+	clientIdentity, err := identity.New(identity.Options{
+		Mode:           cfg.ClientIdentity,
+		Header:         cfg.ClientIdentityHeader,
+		TrustedProxies: cfg.TrustedProxies,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	tokenCounter, err := tokens.New(cfg.TokenizerVocabFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("tokenizer vocabulary: %w", err)
+	}
 
-	port := 80
-	if u.Port() != "" {
-		port, _ = strconv.Atoi(u.Port())
+	byName := make(map[string]*upstream, len(current))
+	for _, up := range current {
+		byName[up.name] = up
 	}
-	if u.Scheme == "https" && u.Port() == "" {
-		port = 443
+
+	upstreams := make([]*upstream, 0, len(cfg.Upstreams))
+	var created []*upstream
+	for _, uc := range cfg.Upstreams {
+		if up, ok := byName[uc.Name]; ok && up.sameConnection(uc) {
+			up.settings.Store(&uc)
+			delete(byName, uc.Name)
+			upstreams = append(upstreams, up)
+			continue
+		}
+		up, err := newUpstream(uc, h.metrics, h.sessions, time.Duration(cfg.SessionTTLSeconds)*time.Second, h.logger)
+		if err != nil {
+			for _, c := range created {
+				c.close()
+			}
+			return nil, nil, fmt.Errorf("upstream %q: %w", uc.Name, err)
+		}
+		created = append(created, up)
+		upstreams = append(upstreams, up)
+	}
+
+	retired := make([]*upstream, 0, len(byName))
+	for _, up := range byName {
+		retired = append(retired, up)
 	}
+	return &handlerState{
+		config:   cfg,
+		router:   newRouter(upstreams),
+		identity: clientIdentity,
+		tokens:   tokenCounter,
+	}, retired, nil
+}
+
+// config returns the configuration currently in effect.
+func (h *Handler) config() *config.Config {
+	return h.state.Load().config
+}
 
-	httpClient := &http.Client{Timeout: 5 * time.Minute}
-	return &Handler{
-		upstream:   u,
-		client:     httpClient,
-		config:     cfg,
-		metrics:    metrics,
-		sessions:   sessions,
-		logger:     logger,
-		serverAddr: u.Hostname(),
-		serverPort: port,
-		reinit:     newReinitializer(u.String(), httpClient, logger),
-	}, nil
+// Close stops resources owned by upstreams, such as stdio server processes.
+func (h *Handler) Close() {
+	for _, up := range h.state.Load().router.upstreams {
+		up.close()
+	}
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Log with session ID
 	h.logger.Info("incoming request", "method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr, "accept", r.Header.Get("Accept"), "content-type", r.Header.Get("Content-Type"), "mcp-session-id", r.Header.Get("Mcp-Session-Id"))
 
+	state := h.state.Load()
+	up, upstreamPath, ok := state.router.match(r.URL.Path)
+	if !ok {
+		h.logger.Warn("no upstream configured for path", "path", r.URL.Path)
+		http.Error(w, "no upstream configured for path", http.StatusNotFound)
+		return
+	}
+	up.active.Add(1)
+	defer up.active.Add(-1)
+	r = withPath(r, upstreamPath)
+
 	// Inject cached session ID if client omits it
-	clientIP := strings.Split(r.RemoteAddr, ":")[0]
 	if r.Header.Get("Mcp-Session-Id") == "" {
-		if cachedID, ok := h.clientSessions.Load(clientIP); ok {
-			r.Header.Set("Mcp-Session-Id", cachedID.(string))
-			h.logger.Info("injected cached session ID", "client", clientIP, "session-id", cachedID)
+		if key, ok := h.clientKey(r, up, state); ok {
+			if cachedID, ok := h.clientSessions.Load(key); ok && h.sessions.Get(cachedID.(string)) != nil {
+				r.Header.Set("Mcp-Session-Id", cachedID.(string))
+				h.logger.Info("injected cached session ID", "client", key, "upstream", up.name, "session-id", cachedID)
+			} else if ok {
+				// The session expired; the client starts a new one
+				h.clientSessions.Delete(key)
+			}
 		}
 	}
 	start := time.Now()
 
+	// On the legacy HTTP+SSE transport, GET opens the stream that announces
+	// the message endpoint and carries every response
+	if up.legacySSE && r.Method == http.MethodGet {
+		h.handleLegacyStream(w, r, up)
+		return
+	}
+
+	if r.Method != http.MethodPost && h.sessionExpired(w, r, up) {
+		return
+	}
+
+	// DELETE terminates the session
+	if r.Method == http.MethodDelete && !up.legacySSE {
+		h.handleTerminate(w, r, up, start)
+		return
+	}
+
+	// GET with SSE accept opens the server-initiated message stream, or
+	// resumes a dropped stream from Last-Event-ID
+	if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		if h.replay != nil && r.Header.Get("Last-Event-ID") != "" {
+			h.handleResume(w, r, up)
+			return
+		}
+		h.handleListen(w, r, up)
+		return
+	}
+
 	// Read request body
 	reqBody, err := io.ReadAll(r.Body)
 	_ = r.Body.Close()
 	if err != nil {
 		h.logger.ErrorContext(r.Context(), "failed to read request body",
 			"error", err,
-			"upstream.url", h.config.UpstreamURL,
+			"upstream.name", up.name,
+			"upstream.url", up.url.String(),
 		)
 		http.Error(w, "failed to read request body", http.StatusBadGateway)
-		h.metrics.ErrorsTotal.Add(r.Context(), 1, telemetry.ErrorAttr("connection_error"))
+		h.metrics.ErrorsTotal.Add(r.Context(), 1, telemetry.ErrorAttr("connection_error"), telemetry.UpstreamAttr(up.name))
 		return
 	}
 
 	// Parse JSON-RPC request
 	parsed, parseErr := jsonrpc.ParseRequest(reqBody)
+	initialize := parseErr == nil && !parsed.IsBatch && len(parsed.Requests) > 0 && parsed.Requests[0].Method == "initialize"
+	if !initialize && h.sessionExpired(w, r, up) {
+		return
+	}
 	if parseErr != nil {
+		h.metrics.ErrorsTotal.Add(r.Context(), 1, telemetry.ErrorAttr("parse_error"), telemetry.UpstreamAttr(up.name))
+		// A policy cannot judge what it cannot read, so with one enabled
+		// the request is refused rather than forwarded
+		if state.config.Policy.Enabled() {
+			h.logger.WarnContext(r.Context(), "failed to parse JSON-RPC request, rejected by policy",
+				"error", parseErr,
+				"upstream.name", up.name,
+				"upstream.url", up.url.String(),
+			)
+			rejectUnparsed(w, reqBody)
+			return
+		}
 		h.logger.WarnContext(r.Context(), "failed to parse JSON-RPC request, forwarding raw",
 			"error", parseErr,
-			"upstream.url", h.config.UpstreamURL,
+			"upstream.name", up.name,
+			"upstream.url", up.url.String(),
 		)
-		h.metrics.ErrorsTotal.Add(r.Context(), 1, telemetry.ErrorAttr("parse_error"))
 		// Fail-open: forward raw request
-		h.forwardRaw(w, r, reqBody)
+		h.forwardRaw(w, r, up, reqBody)
+		return
+	}
+
+	// Responses to server-initiated requests close their pending spans
+	if isResponseOnly(parsed) {
+		h.handleClientResponses(w, r, up, reqBody)
+		return
+	}
+
+	// Requests the policy denies or holds for approval never reach the
+	// upstream
+	var blocked bool
+	if r, blocked = h.enforcePolicy(w, r, up, parsed); blocked {
+		return
+	}
+
+	// Tool calls with arguments the tool's inputSchema rejects are answered
+	// with an invalid params error
+	if h.enforceInputSchemas(w, r, up, parsed) {
+		return
+	}
+
+	// The proxy answers calls of its page tool itself
+	if !parsed.IsBatch && h.servePage(w, r, up, &parsed.Requests[0]) {
+		return
+	}
+
+	if up.legacySSE {
+		h.handleLegacyPost(w, r, up, reqBody, parsed)
 		return
 	}
 
 	// Rewrite protocol version in initialize requests to ensure compatibility
 	// with older MCP servers (e.g., supergateway) that don't support 2025-11-25
-	if !parsed.IsBatch && len(parsed.Requests) > 0 && parsed.Requests[0].Method == "initialize" {
-		reqBody = rewriteProtocolVersion(reqBody, h.logger)
+	if initialize {
+		// stdio servers are spoken to directly and need no version rewrite
+		if up.bridge == nil {
+			reqBody = rewriteProtocolVersion(reqBody, h.logger)
+		}
 		// initialize creates a NEW session -- never send a stale session ID
 		r.Header.Del("Mcp-Session-Id")
 	}
 
 	if parsed.IsBatch {
-		h.handleBatch(w, r, reqBody, parsed, start)
+		h.handleBatch(w, r, up, reqBody, parsed, start)
 	} else {
-		h.handleSingle(w, r, reqBody, &parsed.Requests[0], start)
+		h.handleSingle(w, r, up, reqBody, &parsed.Requests[0], start)
+	}
+}
+
+// clientKey keys cached session IDs by client identity and upstream, since
+// each upstream issues its own sessions. ok is false when session injection
+// is disabled or the client cannot be identified.
+func (h *Handler) clientKey(r *http.Request, up *upstream, state *handlerState) (string, bool) {
+	if !state.config.SessionInjection {
+		return "", false
+	}
+	id, ok := state.identity.Resolve(r)
+	if !ok {
+		return "", false
 	}
+	return up.name + "|" + id, true
 }
 
-func (h *Handler) handleSingle(w http.ResponseWriter, r *http.Request, reqBody []byte, req *jsonrpc.Request, start time.Time) {
+// withPath returns a shallow copy of r whose URL path is replaced, mirroring
+// http.StripPrefix, so the upstream sees the path without the routing prefix.
+func withPath(r *http.Request, path string) *http.Request {
+	if path == r.URL.Path {
+		return r
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = path
+	r2.URL.RawPath = ""
+	return r2
+}
+
+func (h *Handler) handleSingle(w http.ResponseWriter, r *http.Request, up *upstream, reqBody []byte, req *jsonrpc.Request, start time.Time) {
 	ctx := r.Context()
 	reqInfo := mcp.ExtractRequestInfo(req)
 
@@ -140,131 +358,95 @@ func (h *Handler) handleSingle(w http.ResponseWriter, r *http.Request, reqBody [
 	ctx = telemetry.ExtractContextFromMeta(ctx, req.Params, propagation.HeaderCarrier(r.Header))
 
 	// Start span
-	ctx, span := telemetry.StartMCPSpan(ctx, reqInfo, session, h.serverAddr, h.serverPort)
+	ctx, span := telemetry.StartMCPSpan(ctx, reqInfo, session, up.peer)
 	defer span.End()
 
 	// Record request metrics
-	h.metrics.RequestCount.Add(ctx, 1, telemetry.MethodToolAttrs(reqInfo.Method, reqInfo.ToolName))
-	h.metrics.MessageSize.Record(ctx, int64(len(reqBody)), telemetry.DirectionAttr("request"), telemetry.MethodAttr(reqInfo.Method))
+	upstreamAttr := telemetry.UpstreamAttr(up.name)
+	h.metrics.RequestCount.Add(ctx, 1, telemetry.MethodToolAttrs(reqInfo.Method, reqInfo.ToolName), upstreamAttr)
+	h.metrics.MessageSize.Record(ctx, int64(len(reqBody)), telemetry.DirectionAttr("request"), telemetry.MethodAttr(reqInfo.Method), upstreamAttr)
 
 	// Log request
 	h.logger.DebugContext(ctx, "MCP request",
 		"mcp.method.name", reqInfo.Method,
 		"gen_ai.tool.name", reqInfo.ToolName,
 		"jsonrpc.request.id", reqInfo.RequestID,
-		"upstream.url", h.config.UpstreamURL,
+		"upstream.name", up.name,
+		"upstream.url", up.url.String(),
 	)
+	h.recordPolicy(ctx, span, r, up, req, reqInfo)
+	h.warnArguments(ctx, span, up, session, reqInfo)
 
 	// Inject context propagation into params._meta
 	bodyToSend := reqBody
-	if h.config.ContextPropagation {
+	if h.config().ContextPropagation {
 		modified, err := telemetry.InjectContextIntoBody(ctx, reqBody)
 		if err == nil {
 			bodyToSend = modified
 		}
 	}
 
-	// Forward to upstream — use streaming for SSE-capable clients
+	// Forward to upstream. The response body is left unread so SSE responses
+	// can be streamed to clients that accept them.
 	upstreamStart := time.Now()
 	acceptsSSE := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
-	var respBody []byte
-	var respHeaders http.Header
-	var statusCode int
-
-	if acceptsSSE {
-		// Use buffered upstream request so we can reinit on 400 before writing to client
-		var sseErr error
-		respBody, respHeaders, statusCode, sseErr = h.doUpstreamRequest(ctx, r, bodyToSend)
-		// If dead session, reinit and retry
-		if sseErr == nil && shouldReinit(statusCode, reqInfo.Method) {
-			h.logger.Warn("upstream SSE returned error, attempting reinit",
-				"status", statusCode, "mcp.method.name", reqInfo.Method)
-			span.SetAttributes(attribute.Bool("mcp.reinit.attempted", true))
-			retryBody, retryHeaders, retryStatus, retryErr := h.reinit.reinitAndRetry(r, bodyToSend, r.URL.Path)
-			if retryErr == nil {
-				span.SetAttributes(attribute.Bool("mcp.reinit.success", true))
-				respBody = retryBody
-				respHeaders = retryHeaders
-				statusCode = retryStatus
-				if newSID := h.reinit.getSessionID(); newSID != "" {
-					clientIP := strings.Split(r.RemoteAddr, ":")[0]
-					h.clientSessions.Store(clientIP, newSID)
-				}
-			} else {
-				h.logger.Error("SSE reinit failed", "error", retryErr)
-				span.SetAttributes(attribute.Bool("mcp.reinit.success", false))
-			}
-		}
-		upstreamDuration := time.Since(upstreamStart)
-		h.metrics.UpstreamLatency.Record(ctx, upstreamDuration.Seconds(), telemetry.MethodToolAttrs(reqInfo.Method, reqInfo.ToolName))
-		if sseErr != nil {
-			h.logger.ErrorContext(ctx, "upstream SSE stream error",
-				"error", sseErr,
-				"mcp.method.name", reqInfo.Method,
-			)
-		}
-		// Parse SSE data lines for telemetry
-		sseData := extractSSEData(respBody)
-		h.logger.Info("SSE debug", "respBody.len", len(respBody), "respBody", string(respBody), "sseData.nil", sseData == nil, "sseData", string(sseData), "respHeaders.session", respHeaders.Get("Mcp-Session-Id"), "allHeaders", fmt.Sprintf("%v", respHeaders), "method", reqInfo.Method)
-		var respInfo *mcp.ResponseInfo
-		if sseData != nil {
-			respParsed, parseErr := jsonrpc.ParseResponse(sseData)
-			if parseErr == nil && len(respParsed.Responses) > 0 {
-				respInfo = mcp.ExtractResponseInfo(&respParsed.Responses[0], reqInfo.Method)
-				if reqInfo.Method == "initialize" && !respInfo.HasError {
-					respSessionID := sessionID
-					if respSessionID == "" {
-						respSessionID = respHeaders.Get("Mcp-Session-Id")
-					}
-					h.sessions.TrackInitialize(&respParsed.Responses[0], respSessionID)
-					h.reinit.cacheInitRequest(reqBody, respSessionID)
-					if respSessionID != "" {
-						clientIP := strings.Split(r.RemoteAddr, ":")[0]
-						h.clientSessions.Store(clientIP, respSessionID)
-						h.logger.Info("cached session ID for client", "client", clientIP, "session-id", respSessionID)
-					}
-				}
-				if h.config.CapturePayload && reqInfo.Method == "tools/call" {
-					telemetry.SetPayloadAttributes(span, string(req.Params), string(respParsed.Responses[0].Result))
-				}
-			}
-		}
-		h.metrics.MessageSize.Record(ctx, int64(len(respBody)), telemetry.DirectionAttr("response"), telemetry.MethodAttr(reqInfo.Method))
-		duration := time.Since(start)
-		h.metrics.RequestDuration.Record(ctx, duration.Seconds(),
-			telemetry.MethodToolErrorAttrs(reqInfo.Method, reqInfo.ToolName, respInfo))
-		telemetry.EndMCPSpan(span, respInfo)
-		// Write buffered response to client
-		copyHeaders(w.Header(), respHeaders)
-		w.WriteHeader(statusCode)
-		if _, wErr := w.Write(respBody); wErr != nil {
-			h.logger.ErrorContext(ctx, "failed to write SSE response to client", "error", wErr)
-		}
-		return
-	}
-
-	var upErr error
-	respBody, respHeaders, statusCode, upErr = h.doUpstreamRequest(ctx, r, bodyToSend)
-	upstreamDuration := time.Since(upstreamStart)
-
+	// A resumable stream keeps reading upstream after its client drops, so
+	// the upstream call must not be cancelled with the client request.
+	resumable := acceptsSSE && h.replay != nil && sessionID != ""
+	upstreamCtx := ctx
+	if resumable {
+		upstreamCtx = context.WithoutCancel(ctx)
+	}
+	resp, upErr := h.openUpstream(upstreamCtx, r, up, bodyToSend)
 	if upErr != nil {
 		h.logger.ErrorContext(ctx, "upstream request failed",
 			"error", upErr,
 			"mcp.method.name", reqInfo.Method,
-			"upstream.url", h.config.UpstreamURL,
+			"upstream.name", up.name,
+			"upstream.url", up.url.String(),
 		)
-		h.metrics.ErrorsTotal.Add(ctx, 1, telemetry.ErrorAttr("upstream_error"))
+		h.metrics.ErrorsTotal.Add(ctx, 1, telemetry.ErrorAttr("upstream_error"), upstreamAttr)
 		span.SetAttributes(attribute.String("error.type", "upstream_error"))
 		http.Error(w, "upstream request failed", http.StatusBadGateway)
 		return
 	}
 
-	// Retry with reinit if upstream returned an error indicating dead session
-	if shouldReinit(statusCode, reqInfo.Method) {
+	respHeaders := resp.Header
+	statusCode := resp.StatusCode
+	fromUpstreamSession(respHeaders, sessionID)
+
+	// The proxy issues its own session IDs, so upstream session changes
+	// stay invisible to clients.
+	upstreamSessionID := ""
+	if reqInfo.Method == "initialize" {
+		if upstreamSessionID = respHeaders.Get("Mcp-Session-Id"); upstreamSessionID != "" {
+			respHeaders.Set("Mcp-Session-Id", mcp.NewSessionID())
+		}
+	}
+
+	var respBody []byte
+	var final *jsonrpc.Response
+	streamed := false
+	respSize := 0
+
+	// Compression and truncation rewrite the final response on both the
+	// JSON and the SSE path.
+	rewriteFinal := h.finalRewrite(ctx, span, up, session, sessionID, reqInfo)
+
+	switch {
+	case shouldReinit(statusCode, reqInfo.Method):
+		// Retry with reinit if upstream returned an error indicating dead session
+		respBody, _ = io.ReadAll(resp.Body)
 		h.logger.Warn("upstream returned error, attempting reinit",
 			"status", statusCode, "mcp.method.name", reqInfo.Method)
 		span.SetAttributes(attribute.Bool("mcp.reinit.attempted", true))
-		retryBody, retryHeaders, retryStatus, retryErr := h.reinit.reinitAndRetry(r, bodyToSend, r.URL.Path)
+		// The upstream session the request was sent with, which a reinit
+		// for a concurrent request may already have replaced
+		failedID := sessionID
+		if resp.Request != nil {
+			failedID = resp.Request.Header.Get("Mcp-Session-Id")
+		}
+		retryBody, retryHeaders, retryStatus, retryErr := up.reinit.reinitAndRetry(ctx, sessionID, failedID, bodyToSend, r.URL.Path)
 		if retryErr != nil {
 			h.logger.Error("reinit failed, returning original error response", "error", retryErr)
 			span.SetAttributes(attribute.Bool("mcp.reinit.success", false))
@@ -274,54 +456,81 @@ func (h *Handler) handleSingle(w http.ResponseWriter, r *http.Request, reqBody [
 			respBody = retryBody
 			respHeaders = retryHeaders
 			statusCode = retryStatus
-			if newSID := h.reinit.getSessionID(); newSID != "" {
-				clientIP := strings.Split(r.RemoteAddr, ":")[0]
-				h.clientSessions.Store(clientIP, newSID)
-			}
-			upstreamDuration = time.Since(upstreamStart)
+			fromUpstreamSession(respHeaders, sessionID)
 		}
-	}
 
-	// Record upstream latency
-	h.metrics.UpstreamLatency.Record(ctx, upstreamDuration.Seconds(), telemetry.MethodToolAttrs(reqInfo.Method, reqInfo.ToolName))
+	case acceptsSSE && isEventStream(respHeaders):
+		// Relay each event as it arrives so progress reaches the client
+		// while the tool is still running.
+		var st *eventStream
+		if resumable {
+			st = h.replay.open(sessionID, false)
+			defer h.replay.finish(st)
+		}
+		if resumable || rewriteFinal != nil {
+			// Assigned event ids and rewrites change the length.
+			respHeaders.Del("Content-Length")
+		}
+		copyHeaders(w.Header(), respHeaders)
+		w.WriteHeader(statusCode)
+		var streamErr error
+		final, respSize, streamErr = h.streamSSE(ctx, w, resp.Body, span, req.ID, sessionID, st, rewriteFinal)
+		streamed = true
+		if streamErr != nil {
+			h.logger.ErrorContext(ctx, "upstream SSE stream error",
+				"error", streamErr,
+				"mcp.method.name", reqInfo.Method,
+			)
+		}
 
-	// Parse response for telemetry
-	var respInfo *mcp.ResponseInfo
-	respParsed, err := jsonrpc.ParseResponse(respBody)
-	if err == nil && len(respParsed.Responses) > 0 {
-		respInfo = mcp.ExtractResponseInfo(&respParsed.Responses[0], reqInfo.Method)
-
-		// Track initialize handshake
-		if reqInfo.Method == "initialize" && !respInfo.HasError {
-			respSessionID := sessionID
-			if respSessionID == "" {
-				respSessionID = respHeaders.Get("Mcp-Session-Id")
-			}
-			h.sessions.TrackInitialize(&respParsed.Responses[0], respSessionID)
-			h.reinit.cacheInitRequest(reqBody, respSessionID)
-			if respSessionID != "" {
-				clientIP := strings.Split(r.RemoteAddr, ":")[0]
-				h.clientSessions.Store(clientIP, respSessionID)
-				h.logger.Info("cached session ID for client", "client", clientIP, "session-id", respSessionID)
-			}
+	default:
+		var readErr error
+		respBody, readErr = io.ReadAll(resp.Body)
+		if readErr != nil {
+			h.logger.ErrorContext(ctx, "failed to read upstream response", "error", readErr)
 		}
+	}
+	_ = resp.Body.Close()
 
-		// Opt-in payload capture
-		if h.config.CapturePayload && reqInfo.Method == "tools/call" {
-			telemetry.SetPayloadAttributes(span, string(req.Params), string(respParsed.Responses[0].Result))
+	// Record upstream latency
+	upstreamDuration := time.Since(upstreamStart)
+	h.metrics.UpstreamLatency.Record(ctx, upstreamDuration.Seconds(), telemetry.MethodToolAttrs(reqInfo.Method, reqInfo.ToolName), upstreamAttr)
+
+	// Parse buffered response for telemetry
+	if !streamed {
+		respSize = len(respBody)
+		data := respBody
+		if isEventStream(respHeaders) {
+			data = extractSSEData(respBody)
+		}
+		if parsed, err := jsonrpc.ParseResponse(data); err == nil && len(parsed.Responses) > 0 {
+			final = &parsed.Responses[0]
 		}
 	}
 
-	// Apply JSON→Markdown compression if enabled for tools/call responses
-	if h.config.CompressResponses && reqInfo.Method == "tools/call" && respInfo != nil && !respInfo.HasError {
-		respBody = h.compressResponse(ctx, span, respBody, respParsed)
+	var respInfo *mcp.ResponseInfo
+	if final != nil {
+		respInfo = h.observeResponse(ctx, span, r, up, req, reqInfo, reqBody, sessionID, upstreamSessionID, final, respHeaders)
+	}
+
+	// Rewrite a buffered response; streamed ones were rewritten in flight
+	if !streamed && rewriteFinal != nil && final != nil {
+		rewritten := rewriteFinal(final, respBody)
+		if isEventStream(respHeaders) {
+			rewritten = rewriteSSEBody(respBody, req.ID, rewriteFinal)
+		}
+		if !bytes.Equal(rewritten, respBody) {
+			respBody = rewritten
+			respSize = len(respBody)
+			respHeaders.Del("Content-Length")
+		}
 	}
 
 	// Record response metrics
 	totalDuration := time.Since(start)
 	h.metrics.RequestDuration.Record(ctx, totalDuration.Seconds(),
-		telemetry.MethodToolErrorAttrs(reqInfo.Method, reqInfo.ToolName, respInfo))
-	h.metrics.MessageSize.Record(ctx, int64(len(respBody)), telemetry.DirectionAttr("response"), telemetry.MethodAttr(reqInfo.Method))
+		telemetry.MethodToolErrorAttrs(reqInfo.Method, reqInfo.ToolName, respInfo), upstreamAttr)
+	h.metrics.MessageSize.Record(ctx, int64(respSize), telemetry.DirectionAttr("response"), telemetry.MethodAttr(reqInfo.Method), upstreamAttr)
 
 	// End span with response info
 	telemetry.EndMCPSpan(span, respInfo)
@@ -339,10 +548,16 @@ func (h *Handler) handleSingle(w http.ResponseWriter, r *http.Request, reqBody [
 		"mcp.method.name", reqInfo.Method,
 		"gen_ai.tool.name", reqInfo.ToolName,
 		"duration_ms", totalDuration.Milliseconds(),
-		"upstream.url", h.config.UpstreamURL,
+		"streamed", streamed,
+		"upstream.name", up.name,
+		"upstream.url", up.url.String(),
 		"error.type", errType,
 	)
 
+	if streamed {
+		return
+	}
+
 	// Write response to client
 	copyHeaders(w.Header(), respHeaders)
 	w.WriteHeader(statusCode)
@@ -351,94 +566,152 @@ func (h *Handler) handleSingle(w http.ResponseWriter, r *http.Request, reqBody [
 	}
 }
 
-func (h *Handler) handleBatch(w http.ResponseWriter, r *http.Request, reqBody []byte, parsed *jsonrpc.ParseResult, start time.Time) {
-	// For batch: inject context into each request, forward as batch, parse batch response
-	ctx := r.Context()
-
-	// Extract context from HTTP headers for batch
-	ctx = telemetry.ExtractContextFromMeta(ctx, nil, propagation.HeaderCarrier(r.Header))
+// observeResponse extracts telemetry from the final JSON-RPC response to a
+// single request, tracks the initialize handshake and captures payloads.
+func (h *Handler) observeResponse(ctx context.Context, span trace.Span, r *http.Request, up *upstream, req *jsonrpc.Request, reqInfo *mcp.RequestInfo, reqBody []byte, sessionID, upstreamSessionID string, resp *jsonrpc.Response, respHeaders http.Header) *mcp.ResponseInfo {
+	respInfo := mcp.ExtractResponseInfo(resp, reqInfo.Method)
 
-	// Create parent batch span
-	batchInfo := &mcp.RequestInfo{Method: "batch"}
-	ctx, batchSpan := telemetry.StartMCPSpan(ctx, batchInfo, nil, h.serverAddr, h.serverPort)
-	batchSpan.SetAttributes(attribute.Int("jsonrpc.batch.size", len(parsed.Requests)))
-	defer batchSpan.End()
+	// Track initialize handshake
+	if reqInfo.Method == "initialize" && !respInfo.HasError {
+		respSessionID := sessionID
+		if respSessionID == "" {
+			respSessionID = respHeaders.Get("Mcp-Session-Id")
+		}
+		h.sessions.TrackInitialize(resp, respSessionID, reqBody)
+		if respSessionID != "" {
+			span.SetAttributes(attribute.String("mcp.session.id", respSessionID))
+		}
+		if upstreamSessionID != "" {
+			h.sessions.SetUpstream(respSessionID, upstreamSessionID, span.SpanContext())
+			span.SetAttributes(attribute.String("mcp.proxy.upstream.session.id", upstreamSessionID))
+		}
+		if key, ok := h.clientKey(r, up, h.state.Load()); ok && respSessionID != "" {
+			h.clientSessions.Store(key, respSessionID)
+			h.logger.InfoContext(ctx, "cached session ID for client", "client", key, "upstream", up.name, "session-id", respSessionID)
+		}
+	}
 
-	// Inject context into batch
-	bodyToSend := reqBody
-	if h.config.ContextPropagation {
-		modified, err := telemetry.InjectContextIntoBatchBody(ctx, reqBody)
-		if err == nil {
-			bodyToSend = modified
+	// Cache tool definitions for argument and structuredContent validation
+	if reqInfo.Method == "tools/list" && !respInfo.HasError && sessionID != "" {
+		if session := h.sessions.Get(sessionID); session != nil {
+			h.cacheTools(ctx, session, req, resp)
 		}
 	}
 
-	// Forward to upstream
-	upstreamStart := time.Now()
-	respBody, respHeaders, statusCode, err := h.doUpstreamRequest(ctx, r, bodyToSend)
-	upstreamDuration := time.Since(upstreamStart)
+	// Opt-in payload capture
+	if h.config().CapturePayload && reqInfo.Method == "tools/call" {
+		telemetry.SetPayloadAttributes(span, string(req.Params), string(resp.Result))
+	}
+
+	return respInfo
+}
 
+// forwardRaw relays a request the proxy does not trace, such as a body it
+// could not parse or a POST of client responses. SSE responses are relayed
+// event by event until the upstream ends the stream.
+func (h *Handler) forwardRaw(w http.ResponseWriter, r *http.Request, up *upstream, reqBody []byte) {
+	ctx := r.Context()
+	resp, err := h.openUpstream(ctx, r, up, reqBody)
 	if err != nil {
-		h.logger.ErrorContext(ctx, "upstream batch request failed",
-			"error", err,
-			"upstream.url", h.config.UpstreamURL,
-		)
-		h.metrics.ErrorsTotal.Add(ctx, 1, telemetry.ErrorAttr("upstream_error"))
+		h.logger.ErrorContext(ctx, "raw upstream request failed", "error", err, "upstream.name", up.name)
 		http.Error(w, "upstream request failed", http.StatusBadGateway)
 		return
 	}
+	defer func() { _ = resp.Body.Close() }()
 
-	h.metrics.UpstreamLatency.Record(ctx, upstreamDuration.Seconds(), telemetry.MethodAttr("batch"))
+	fromUpstreamSession(resp.Header, r.Header.Get("Mcp-Session-Id"))
+	copyHeaders(w.Header(), resp.Header)
+	w.WriteHeader(resp.StatusCode)
 
-	// Record metrics for each request in the batch
-	for i := range parsed.Requests {
-		reqInfo := mcp.ExtractRequestInfo(&parsed.Requests[i])
-		h.metrics.RequestCount.Add(ctx, 1, telemetry.MethodToolAttrs(reqInfo.Method, reqInfo.ToolName))
+	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") && isEventStream(resp.Header) {
+		// No request ID to wait for: the stream is relayed to its end
+		if _, _, err := h.streamSSE(ctx, w, resp.Body, trace.SpanFromContext(ctx), nil, "", nil, nil); err != nil {
+			h.logger.ErrorContext(ctx, "failed to stream raw SSE response", "error", err)
+		}
+		return
+	}
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		h.logger.ErrorContext(ctx, "failed to write raw response to client", "error", err)
 	}
+}
 
-	totalDuration := time.Since(start)
-	h.metrics.RequestDuration.Record(ctx, totalDuration.Seconds(), telemetry.MethodAttr("batch"))
-	h.metrics.MessageSize.Record(ctx, int64(len(reqBody)), telemetry.DirectionAttr("request"), telemetry.MethodAttr("batch"))
-	h.metrics.MessageSize.Record(ctx, int64(len(respBody)), telemetry.DirectionAttr("response"), telemetry.MethodAttr("batch"))
+func (h *Handler) doUpstreamRequest(ctx context.Context, originalReq *http.Request, up *upstream, body []byte) ([]byte, http.Header, int, error) {
+	resp, err := h.openUpstream(ctx, originalReq, up, body)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
 
-	// Write response
-	copyHeaders(w.Header(), respHeaders)
-	w.WriteHeader(statusCode)
-	if _, err := w.Write(respBody); err != nil {
-		h.logger.ErrorContext(ctx, "failed to write batch response to client", "error", err)
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, 0, err
 	}
+
+	return respBody, resp.Header, resp.StatusCode, nil
 }
 
-func (h *Handler) forwardRaw(w http.ResponseWriter, r *http.Request, reqBody []byte) {
-	// Use streaming for SSE-capable clients
-	if strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
-		_, _, _, err := h.doUpstreamStreamingRequest(r.Context(), w, r, reqBody)
-		if err != nil {
-			h.logger.ErrorContext(r.Context(), "failed to stream raw SSE response", "error", err)
-		}
-		return
-	}
-	respBody, respHeaders, statusCode, err := h.doUpstreamRequest(r.Context(), r, reqBody)
+// openUpstream forwards the request upstream and returns the response with
+// its body unread. The caller must close the body.
+func (h *Handler) openUpstream(ctx context.Context, originalReq *http.Request, up *upstream, body []byte) (*http.Response, error) {
+	req, err := newUpstreamRequest(ctx, originalReq, up, body)
 	if err != nil {
-		http.Error(w, "upstream request failed", http.StatusBadGateway)
-		return
+		return nil, err
 	}
-	copyHeaders(w.Header(), respHeaders)
-	w.WriteHeader(statusCode)
-	if _, err := w.Write(respBody); err != nil {
-		h.logger.ErrorContext(r.Context(), "failed to write raw response to client", "error", err)
+	h.toUpstreamSession(req)
+	if up.legacySSE {
+		h.toLegacyUpstreamSession(req)
 	}
+	return up.client.Do(req)
 }
 
-func (h *Handler) doUpstreamRequest(ctx context.Context, originalReq *http.Request, body []byte) ([]byte, http.Header, int, error) {
-	upstreamURL := h.upstream.String() + originalReq.URL.Path
+// fromUpstreamSession restores the client's session ID on a response. After
+// a reinit the upstream answers with its new session ID, which the client
+// must never see.
+func fromUpstreamSession(header http.Header, sessionID string) {
+	if sessionID != "" && header.Get("Mcp-Session-Id") != "" {
+		header.Set("Mcp-Session-Id", sessionID)
+	}
+}
+
+// sessionExpired answers a request carrying a session ID the proxy does not
+// know, such as one evicted after SESSION_TTL, with 404 and reports whether
+// it did. The upstream session may live on, but without the mapping and the
+// initialize request the proxy cannot reach or recover it; 404 tells the
+// client to initialize a new session.
+func (h *Handler) sessionExpired(w http.ResponseWriter, r *http.Request, up *upstream) bool {
+	sessionID := r.Header.Get("Mcp-Session-Id")
+	if sessionID == "" || up.legacySSE || h.sessions.Get(sessionID) != nil {
+		return false
+	}
+	h.logger.InfoContext(r.Context(), "unknown or expired session",
+		"mcp.session.id", sessionID,
+		"upstream.name", up.name,
+	)
+	http.Error(w, "session not found", http.StatusNotFound)
+	return true
+}
+
+// toUpstreamSession replaces the client's session ID on an outgoing request
+// with the upstream session currently serving it.
+func (h *Handler) toUpstreamSession(req *http.Request) {
+	if id := req.Header.Get("Mcp-Session-Id"); id != "" {
+		if upstreamID, _ := h.sessions.Upstream(id); upstreamID != "" {
+			req.Header.Set("Mcp-Session-Id", upstreamID)
+		}
+	}
+}
+
+// newUpstreamRequest builds the upstream request for originalReq, copying
+// its headers transparently.
+func newUpstreamRequest(ctx context.Context, originalReq *http.Request, up *upstream, body []byte) (*http.Request, error) {
+	upstreamURL := up.url.String() + originalReq.URL.Path
 	if originalReq.URL.RawQuery != "" {
 		upstreamURL += "?" + originalReq.URL.RawQuery
 	}
 
 	req, err := http.NewRequestWithContext(ctx, originalReq.Method, upstreamURL, bytes.NewReader(body))
 	if err != nil {
-		return nil, nil, 0, err
+		return nil, err
 	}
 
 	// Copy all headers transparently
@@ -450,22 +723,10 @@ func (h *Handler) doUpstreamRequest(ctx context.Context, originalReq *http.Reque
 	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
 	// Ensure upstream always gets Accept: text/event-stream (supergateway requires it)
 	req.Header.Set("Accept", "text/event-stream, application/json")
-
-	resp, err := h.client.Do(req)
-	if err != nil {
-		return nil, nil, 0, err
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	respBody, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, nil, 0, err
-	}
-
-	return respBody, resp.Header, resp.StatusCode, nil
+	up.applyHeaders(req)
+	return req, nil
 }
 
-
 // extractSSEData extracts the last "data: " payload from SSE response bytes.
 func extractSSEData(sseBody []byte) []byte {
 	var lastData []byte
@@ -476,108 +737,91 @@ func extractSSEData(sseBody []byte) []byte {
 	}
 	return lastData
 }
-// doUpstreamStreamingRequest sends the request upstream and streams SSE response directly to the client.
-// Returns the full buffered SSE data for telemetry parsing, plus the response headers.
-func (h *Handler) doUpstreamStreamingRequest(ctx context.Context, w http.ResponseWriter, originalReq *http.Request, body []byte) ([]byte, http.Header, int, error) {
-	upstreamURL := h.upstream.String() + originalReq.URL.Path
-	if originalReq.URL.RawQuery != "" {
-		upstreamURL += "?" + originalReq.URL.RawQuery
-	}
 
-	req, err := http.NewRequestWithContext(ctx, originalReq.Method, upstreamURL, bytes.NewReader(body))
-	if err != nil {
-		return nil, nil, 0, err
-	}
-
-	for k, vv := range originalReq.Header {
+func copyHeaders(dst, src http.Header) {
+	for k, vv := range src {
 		for _, v := range vv {
-			req.Header.Add(k, v)
+			dst.Add(k, v)
 		}
 	}
-	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
-	// Ensure upstream always gets Accept: text/event-stream (supergateway requires it)
-	req.Header.Set("Accept", "text/event-stream, application/json")
-
-	resp, err := h.client.Do(req)
-	if err != nil {
-		return nil, nil, 0, err
-	}
-	defer func() { _ = resp.Body.Close() }()
-
-	// Copy response headers to client
-	copyHeaders(w.Header(), resp.Header)
-	w.WriteHeader(resp.StatusCode)
+}
 
-	// Stream SSE and buffer for telemetry.
-	// SSE streams stay open, so we read with a timeout: once we see a blank line
-	// (SSE event boundary) after data lines, we stop reading.
-	flusher, canFlush := w.(http.Flusher)
-	var buf bytes.Buffer
-	scanner := bufio.NewScanner(resp.Body)
-	scanner.Buffer(make([]byte, 0, 256*1024), 1024*1024)
-
-	// Use a goroutine + channel to avoid blocking forever on scanner.Scan()
-	type scanResult struct {
-		line []byte
-		ok   bool
-	}
-	lineCh := make(chan scanResult, 1)
-	go func() {
-		for scanner.Scan() {
-			line := make([]byte, len(scanner.Bytes()))
-			copy(line, scanner.Bytes())
-			lineCh <- scanResult{line: line, ok: true}
-		}
-		lineCh <- scanResult{ok: false}
-	}()
-
-	sawData := false
-	idleTimeout := 5 * time.Second
-	for {
-		select {
-		case result := <-lineCh:
-			if !result.ok {
-				goto done
+// finalRewrite returns the rewrite of the final response to a request, or
+// nil if there is none: structuredContent promotion and validation,
+// compression and truncation of tool results, list results filtered by the
+// policy, and the page tool added to tools/list.
+//
+// Compression only rewrites text blocks, so a promoted structuredContent
+// keeps the full JSON for clients that read it while older clients get the
+// compact text.
+func (h *Handler) finalRewrite(ctx context.Context, span trace.Span, up *upstream, session *mcp.Session, sessionID string, reqInfo *mcp.RequestInfo) rewriteFunc {
+	settings := up.settings.Load()
+	switch reqInfo.Method {
+	case "tools/call":
+		tool := reqInfo.ToolName
+		promote := settings.PromoteTool(tool)
+		validate := settings.ValidateToolOutput(tool)
+		compressOn := settings.CompressTool(tool)
+		budget := settings.ToolBudget(tool)
+		if !promote && !validate && !compressOn && !budget.Enabled() {
+			return nil
+		}
+		return func(resp *jsonrpc.Response, data []byte) []byte {
+			if mcp.ExtractResponseInfo(resp, reqInfo.Method).HasError {
+				return data
+			}
+			if promote {
+				data = promoteStructuredContent(span, data)
 			}
-			buf.Write(result.line)
-			buf.WriteByte(0x0a)
-			_, _ = w.Write(result.line)
-			_, _ = w.Write([]byte{0x0a})
-			if canFlush {
-				flusher.Flush()
+			if validate {
+				h.validateOutput(ctx, span, up, session, tool, data)
 			}
-			if bytes.HasPrefix(result.line, []byte("data: ")) {
-				sawData = true
+			if compressOn {
+				parsed, err := jsonrpc.ParseResponse(data)
+				if err != nil || parsed.IsBatch {
+					return data
+				}
+				data = h.compressResponse(ctx, span, up, session, tool, data, parsed)
 			}
-			// Empty line after data = end of SSE event
-			if sawData && len(result.line) == 0 {
-				goto done
+			// The budget applies to what the client receives
+			if budget.Enabled() {
+				data = h.truncateResponse(ctx, span, up, sessionID, tool, budget, data)
 			}
-		case <-time.After(idleTimeout):
-			goto done
-		case <-ctx.Done():
-			goto done
+			return data
 		}
-	}
-done:
-
-	return buf.Bytes(), resp.Header, resp.StatusCode, scanner.Err()
-}
 
-func copyHeaders(dst, src http.Header) {
-	for k, vv := range src {
-		for _, v := range vv {
-			dst.Add(k, v)
+	case "tools/list", "prompts/list", "resources/list":
+		filter := policyFrom(ctx) != nil
+		pageTool := reqInfo.Method == "tools/list" && settings.PagesResponses()
+		if !filter && !pageTool {
+			return nil
+		}
+		return func(_ *jsonrpc.Response, data []byte) []byte {
+			if filter {
+				data = h.filterList(ctx, span, up, reqInfo.Method, data)
+			}
+			if pageTool {
+				data = withPageTool(data)
+			}
+			return data
 		}
 	}
+	return nil
 }
 
-// compressResponse applies JSON→Markdown compression to MCP tool call response content blocks.
-// It modifies text content blocks in-place and returns the re-serialized response body.
-func (h *Handler) compressResponse(ctx context.Context, span trace.Span, respBody []byte, respParsed *jsonrpc.ParseResult) []byte {
+// compressResponse re-encodes JSON in MCP tool call response content blocks
+// in the compression format configured for the tool. It modifies text
+// content blocks in-place and returns the re-serialized response body.
+// Tokens saved are added to session, which may be nil.
+func (h *Handler) compressResponse(ctx context.Context, span trace.Span, up *upstream, session *mcp.Session, tool string, respBody []byte, respParsed *jsonrpc.ParseResult) []byte {
 	if respParsed == nil || len(respParsed.Responses) == 0 {
 		return respBody
 	}
+	settings := up.settings.Load()
+	compressor, err := compress.New(settings.ToolCompressFormat(tool), settings.ToolCompressOptions(tool))
+	if err != nil {
+		return respBody
+	}
 
 	// Create a child span for the compression operation
 	ctx, compressSpan := otel.Tracer("mcp-otel-proxy").Start(ctx, "mcp.response.compress",
@@ -609,7 +853,9 @@ func (h *Handler) compressResponse(ctx context.Context, span trace.Span, respBod
 	}
 
 	originalSize := len(respBody)
-	compressed := false
+	format := ""
+	counter := h.state.Load().tokens
+	var originalTokens, compressedTokens int
 
 	for i, block := range content {
 		typeRaw, ok := block["type"]
@@ -630,15 +876,19 @@ func (h *Handler) compressResponse(ctx context.Context, span trace.Span, respBod
 			continue
 		}
 
-		converted, didConvert := compress.CompressJSONToMarkdown(text)
+		converted, used, didConvert := compressor.Compress(text)
 		if didConvert {
 			newText, _ := json.Marshal(converted)
 			content[i]["text"] = newText
-			compressed = true
+			if format == "" {
+				format = used
+			}
+			originalTokens += counter.Count(text)
+			compressedTokens += counter.Count(converted)
 		}
 	}
 
-	if !compressed {
+	if format == "" {
 		return respBody
 	}
 
@@ -662,24 +912,38 @@ func (h *Handler) compressResponse(ctx context.Context, span trace.Span, respBod
 	}
 
 	// Set compression telemetry attributes on both parent and child spans
-	span.SetAttributes(
+	compressAttrs := []attribute.KeyValue{
 		attribute.Bool("mcp.response.compressed", true),
+		attribute.String("mcp.response.compression.format", format),
 		attribute.Int("mcp.response.original_bytes", originalSize),
 		attribute.Int("mcp.response.compressed_bytes", len(newRespBody)),
-	)
-	compressSpan.SetAttributes(
-		attribute.Bool("mcp.response.compressed", true),
-		attribute.Int("mcp.response.original_bytes", originalSize),
-		attribute.Int("mcp.response.compressed_bytes", len(newRespBody)),
-	)
+		attribute.Int("mcp.response.original_tokens", originalTokens),
+		attribute.Int("mcp.response.compressed_tokens", compressedTokens),
+	}
+	span.SetAttributes(compressAttrs...)
+	compressSpan.SetAttributes(compressAttrs...)
 
 	// Record compression ratio metric
 	if originalSize > 0 {
 		ratio := float64(len(newRespBody)) / float64(originalSize)
-		h.metrics.CompressionRatio.Record(ctx, ratio)
+		h.metrics.CompressionRatio.Record(ctx, ratio, telemetry.UpstreamAttr(up.name), telemetry.CompressFormatAttr(format))
+	}
+
+	// Token accounting, per tool and upstream and as a running session total
+	toolAttrs := telemetry.MethodToolAttrs("tools/call", tool)
+	upstreamAttr := telemetry.UpstreamAttr(up.name)
+	saved := int64(originalTokens - compressedTokens)
+	h.metrics.CompressionTokens.Record(ctx, int64(originalTokens), toolAttrs, upstreamAttr, telemetry.TokenTypeAttr("original"))
+	h.metrics.CompressionTokens.Record(ctx, int64(compressedTokens), toolAttrs, upstreamAttr, telemetry.TokenTypeAttr("compressed"))
+	h.metrics.TokensSaved.Add(ctx, saved, toolAttrs, upstreamAttr)
+	if session != nil {
+		span.SetAttributes(attribute.Int64("mcp.session.compression.tokens_saved", session.AddTokensSaved(saved)))
 	}
 
 	h.logger.DebugContext(ctx, "compressed response",
+		"format", format,
+		"original_tokens", originalTokens,
+		"compressed_tokens", compressedTokens,
 		"original_bytes", originalSize,
 		"compressed_bytes", len(newRespBody),
 	)
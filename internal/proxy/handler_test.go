@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// sessionUpstream issues the session up-1 on initialize and records the
+// HTTP method, JSON-RPC method and Mcp-Session-Id of every request it gets.
+// DELETE is answered with deleteStatus.
+type sessionUpstream struct {
+	*httptest.Server
+	deleteStatus int
+
+	mu       sync.Mutex
+	received []string
+}
+
+func newSessionUpstream(t *testing.T) *sessionUpstream {
+	t.Helper()
+	u := &sessionUpstream{deleteStatus: http.StatusOK}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		_ = json.Unmarshal(body, &req)
+		u.mu.Lock()
+		u.received = append(u.received, strings.TrimSpace(fmt.Sprintf("%s %s %s", r.Method, r.Header.Get("Mcp-Session-Id"), req.Method)))
+		u.mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(u.deleteStatus)
+		case req.Method == "initialize":
+			w.Header().Set("Mcp-Session-Id", "up-1")
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":{"protocolVersion":"2025-06-18"}}`, req.ID)
+		case len(req.ID) == 0:
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			w.Header().Set("Mcp-Session-Id", r.Header.Get("Mcp-Session-Id"))
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":{}}`, req.ID)
+		}
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+// requests returns what the upstream received and forgets it.
+func (u *sessionUpstream) requests() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	got := u.received
+	u.received = nil
+	return got
+}
+
+// send makes a request to h with the session ID and JSON-RPC body given.
+func send(h *Handler, method, sessionID, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/mcp", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json, text/event-stream")
+	if sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", sessionID)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// initializeSession opens a session through h and returns the proxy's
+// session ID for it.
+func initializeSession(t *testing.T, h *Handler) string {
+	t.Helper()
+	rec := send(h, http.MethodPost, "", initializeBody)
+	sessionID := rec.Header().Get("Mcp-Session-Id")
+	if rec.Code != http.StatusOK || sessionID == "" {
+		t.Fatalf("expected a session, got %d: %s", rec.Code, rec.Body)
+	}
+	return sessionID
+}
+
+func TestServeHTTP_SessionMapping(t *testing.T) {
+	up := newSessionUpstream(t)
+	h := newTestHandler(t, "upstreams:\n  - name: up\n    url: "+up.URL+"\n")
+
+	sessionID := initializeSession(t, h)
+	if sessionID == "up-1" {
+		t.Fatal("expected the proxy to issue its own session ID")
+	}
+	up.requests()
+
+	rec := send(h, http.MethodPost, sessionID, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if got := up.requests(); len(got) != 1 || got[0] != "POST up-1 tools/list" {
+		t.Errorf("expected tools/list on up-1, got %v", got)
+	}
+	if got := rec.Header().Get("Mcp-Session-Id"); got != sessionID {
+		t.Errorf("expected the client to see session %s, got %s", sessionID, got)
+	}
+}
+
+func TestServeHTTP_UnknownSession(t *testing.T) {
+	up := newSessionUpstream(t)
+	h := newTestHandler(t, "upstreams:\n  - name: up\n    url: "+up.URL+"\n")
+	expired := initializeSession(t, h)
+	h.sessions.Remove(expired)
+	up.requests()
+
+	tests := []struct {
+		name, method, sessionID, body string
+		wantStatus                    int
+		wantForwarded                 bool
+	}{
+		{"unknown POST", http.MethodPost, "unknown", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, http.StatusNotFound, false},
+		{"expired POST", http.MethodPost, expired, `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`, http.StatusNotFound, false},
+		{"expired notification", http.MethodPost, expired, `{"jsonrpc":"2.0","method":"notifications/initialized"}`, http.StatusNotFound, false},
+		{"expired batch", http.MethodPost, expired, `[{"jsonrpc":"2.0","id":1,"method":"ping"}]`, http.StatusNotFound, false},
+		{"expired GET", http.MethodGet, expired, "", http.StatusNotFound, false},
+		{"expired DELETE", http.MethodDelete, expired, "", http.StatusNotFound, false},
+		{"initialize starts over", http.MethodPost, expired, initializeBody, http.StatusOK, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := send(h, tt.method, tt.sessionID, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body)
+			}
+			if forwarded := len(up.requests()) > 0; forwarded != tt.wantForwarded {
+				t.Errorf("expected forwarded %v, got %v", tt.wantForwarded, forwarded)
+			}
+		})
+	}
+}
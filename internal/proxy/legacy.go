@@ -0,0 +1,342 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/jsonrpc"
+	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
+	"github.com/isitobservable/mcp-otel-proxy/internal/telemetry"
+)
+
+// The legacy HTTP+SSE transport (protocol 2024-11-05) splits each exchange:
+// the client POSTs a request to the message endpoint announced on its GET
+// stream, the POST returns 202, and the response arrives later on the
+// stream. Spans start at the POST and end when the stream delivers the
+// response with the same JSON-RPC ID. As on the streamable transport, the
+// client only sees session IDs issued by the proxy.
+
+// handleLegacyStream proxies the GET stream of a legacy upstream. The
+// endpoint event is rewritten to point at the proxy, and responses on the
+// stream end the spans of the requests POSTed to that endpoint.
+func (h *Handler) handleLegacyStream(w http.ResponseWriter, r *http.Request, up *upstream) {
+	ctx := r.Context()
+
+	resp, rc, ok := h.openStream(w, r, up, "")
+	if !ok {
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	opened := time.Now()
+	sessionID := ""
+	reader := newSSEReader(resp.Body)
+	for {
+		ev, err := reader.next()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				h.logger.ErrorContext(ctx, "upstream stream error", "error", err, "mcp.session.id", sessionID)
+			}
+			break
+		}
+
+		raw := ev.raw
+		if ev.event == "endpoint" {
+			h.legacySessions.Delete(sessionID)
+			endpoint, id := h.rewriteEndpoint(up, string(ev.data))
+			sessionID = id
+			raw = []byte(fmt.Sprintf("event: endpoint\ndata: %s\n\n", endpoint))
+			h.logger.InfoContext(ctx, "legacy SSE stream opened",
+				"mcp.session.id", sessionID,
+				"endpoint", endpoint,
+				"upstream.name", up.name,
+			)
+		} else {
+			h.observeServerMessage(ctx, up, sessionID, ev.data)
+			if parsed, err := jsonrpc.ParseResponse(ev.data); err == nil {
+				if data := h.finishLegacyCalls(up, sessionID, parsed, ev.data); !bytes.Equal(data, ev.data) {
+					raw = ev.withData(data).raw
+				}
+			}
+		}
+
+		if _, err := w.Write(raw); err != nil {
+			break
+		}
+		_ = rc.Flush()
+	}
+
+	// Responses only travel on this stream, so requests still waiting
+	// for one will never get it.
+	for _, call := range h.pending.takeSession(sessionID) {
+		call.span.SetAttributes(attribute.String("error.type", "stream_closed"))
+		call.span.SetStatus(codes.Error, "SSE stream closed before response")
+		call.span.End()
+	}
+	h.legacySessions.Delete(sessionID)
+
+	h.logger.InfoContext(ctx, "legacy SSE stream closed",
+		"mcp.session.id", sessionID,
+		"upstream.name", up.name,
+		"duration_ms", time.Since(opened).Milliseconds(),
+	)
+}
+
+// rewriteEndpoint maps the message endpoint announced by the upstream onto
+// the proxy's path for this upstream, and returns it with the session ID it
+// carries. The upstream's session ID is replaced with one issued by the
+// proxy. Only endpoints under the upstream URL's path can be reached
+// through the proxy; others are returned as absolute upstream URLs, with
+// the upstream's session ID.
+func (h *Handler) rewriteEndpoint(up *upstream, endpoint string) (string, string) {
+	ref, err := url.Parse(strings.TrimSpace(endpoint))
+	if err != nil {
+		return endpoint, ""
+	}
+	abs := up.url.ResolveReference(ref)
+	sessionID := legacySessionID(abs.Query())
+	if abs.Host != up.url.Host {
+		// Messages go to another host; the proxy cannot sit in between.
+		h.logger.Warn("legacy SSE endpoint on a different host, not rewritten",
+			"endpoint", endpoint, "upstream.name", up.name)
+		return endpoint, sessionID
+	}
+
+	// The proxy forwards prefix+p to the upstream URL's path plus p
+	p, ok := strings.CutPrefix(abs.Path, strings.TrimRight(up.url.Path, "/"))
+	if !ok || (p != "" && !strings.HasPrefix(p, "/")) {
+		h.logger.Warn("legacy SSE endpoint outside the upstream path, not rewritten",
+			"endpoint", endpoint, "upstream.name", up.name)
+		return abs.String(), sessionID
+	}
+	if p == "" {
+		p = "/"
+	}
+	rewritten := up.prefix + p
+	if sessionID != "" {
+		upstreamID := sessionID
+		sessionID = mcp.NewSessionID()
+		h.legacySessions.Store(sessionID, upstreamID)
+		abs.RawQuery = withLegacySessionID(abs.Query(), sessionID)
+	}
+	if abs.RawQuery != "" {
+		rewritten += "?" + abs.RawQuery
+	}
+	return rewritten, sessionID
+}
+
+// legacySessionID returns the session ID from a legacy message endpoint's
+// query. Servers disagree on the parameter name.
+func legacySessionID(q url.Values) string {
+	if id := q.Get("sessionId"); id != "" {
+		return id
+	}
+	return q.Get("session_id")
+}
+
+// withLegacySessionID returns q encoded with its session ID replaced by id,
+// under whichever parameter name the server used.
+func withLegacySessionID(q url.Values, id string) string {
+	if q.Get("sessionId") != "" {
+		q.Set("sessionId", id)
+	} else {
+		q.Set("session_id", id)
+	}
+	return q.Encode()
+}
+
+// toLegacyUpstreamSession replaces the proxy's session ID in the query of a
+// request to a legacy message endpoint with the upstream's.
+func (h *Handler) toLegacyUpstreamSession(req *http.Request) {
+	q := req.URL.Query()
+	if upstreamID, ok := h.legacySessions.Load(legacySessionID(q)); ok {
+		req.URL.RawQuery = withLegacySessionID(q, upstreamID.(string))
+	}
+}
+
+// handleLegacyPost forwards client messages to a legacy message endpoint.
+// Request spans are registered as pending before forwarding, since the
+// response may reach the stream before the POST returns.
+func (h *Handler) handleLegacyPost(w http.ResponseWriter, r *http.Request, up *upstream, reqBody []byte, parsed *jsonrpc.ParseResult) {
+	ctx := r.Context()
+	sessionID := legacySessionID(r.URL.Query())
+	var session *mcp.Session
+	if sessionID != "" {
+		session = h.sessions.Get(sessionID)
+	}
+	upstreamAttr := telemetry.UpstreamAttr(up.name)
+
+	type started struct {
+		key  string
+		call *pendingCall
+	}
+	calls := make([]started, 0, len(parsed.Requests))
+	bodyToSend := reqBody
+	for i := range parsed.Requests {
+		msg := &parsed.Requests[i]
+		reqInfo := mcp.ExtractRequestInfo(msg)
+
+		// Spans outlive the POST, so they must not inherit its cancellation.
+		msgCtx := telemetry.ExtractContextFromMeta(context.WithoutCancel(ctx), msg.Params, propagation.HeaderCarrier(r.Header))
+		msgCtx, span := telemetry.StartMCPSpan(msgCtx, reqInfo, session, up.peer)
+		h.recordPolicy(msgCtx, span, r, up, msg, reqInfo)
+		h.warnArguments(msgCtx, span, up, session, reqInfo)
+
+		h.metrics.RequestCount.Add(msgCtx, 1, telemetry.MethodToolAttrs(reqInfo.Method, reqInfo.ToolName), upstreamAttr)
+		if !parsed.IsBatch {
+			h.metrics.MessageSize.Record(msgCtx, int64(len(reqBody)), telemetry.DirectionAttr("request"), telemetry.MethodAttr(reqInfo.Method), upstreamAttr)
+			if h.config().ContextPropagation {
+				if modified, err := telemetry.InjectContextIntoBody(msgCtx, reqBody); err == nil {
+					bodyToSend = modified
+				}
+			}
+		}
+
+		call := &pendingCall{span: span, reqInfo: reqInfo, params: msg.Params, policy: policyFrom(ctx), start: time.Now()}
+		if reqInfo.Method == "initialize" && !parsed.IsBatch {
+			call.body = reqBody
+		}
+		key := ""
+		if !msg.IsNotification() {
+			key = pendingKey(sessionID, fromClient, msg.ID)
+			h.pending.add(key, call, expirePending)
+		}
+		calls = append(calls, started{key: key, call: call})
+	}
+
+	resp, err := h.openUpstream(ctx, r, up, bodyToSend)
+	if err == nil && resp.StatusCode >= http.StatusBadRequest {
+		_ = resp.Body.Close()
+		err = fmt.Errorf("upstream returned %s", resp.Status)
+		resp = nil
+	}
+	if err != nil {
+		h.logger.ErrorContext(ctx, "upstream request failed",
+			"error", err,
+			"mcp.session.id", sessionID,
+			"upstream.name", up.name,
+			"upstream.url", up.url.String(),
+		)
+		h.metrics.ErrorsTotal.Add(ctx, 1, telemetry.ErrorAttr("upstream_error"), upstreamAttr)
+		for _, c := range calls {
+			if c.key != "" && h.pending.take(c.key) == nil {
+				continue // already answered on the stream
+			}
+			c.call.span.SetAttributes(attribute.String("error.type", "upstream_error"))
+			c.call.span.SetStatus(codes.Error, err.Error())
+			c.call.span.End()
+		}
+		http.Error(w, "upstream request failed", http.StatusBadGateway)
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	for _, c := range calls {
+		if c.key == "" {
+			c.call.span.End()
+		}
+	}
+
+	copyHeaders(w.Header(), resp.Header)
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		h.logger.ErrorContext(ctx, "failed to write response to client", "error", err)
+	}
+}
+
+// finishLegacyCalls ends the spans of the requests the responses of a
+// stream event answer. It returns data, the event's payload, rewritten like
+// final responses on the streamable transport.
+func (h *Handler) finishLegacyCalls(up *upstream, sessionID string, parsed *jsonrpc.ParseResult, data []byte) []byte {
+	if !parsed.IsBatch {
+		return h.finishLegacyCall(up, sessionID, &parsed.Responses[0], data, len(data))
+	}
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil || len(raws) != len(parsed.Responses) {
+		raws = make([]json.RawMessage, len(parsed.Responses))
+	}
+	rewritten := false
+	for i := range parsed.Responses {
+		before := raws[i]
+		raws[i] = h.finishLegacyCall(up, sessionID, &parsed.Responses[i], before, len(data))
+		rewritten = rewritten || !bytes.Equal(before, raws[i])
+	}
+	if !rewritten {
+		return data
+	}
+	newData, err := json.Marshal(raws)
+	if err != nil {
+		return data
+	}
+	return newData
+}
+
+// finishLegacyCall ends the span of the request a stream response answers
+// and returns raw, the response as received, with the rewrites finalRewrite
+// applies to it.
+func (h *Handler) finishLegacyCall(up *upstream, sessionID string, resp *jsonrpc.Response, raw []byte, size int) []byte {
+	call := h.pending.take(pendingKey(sessionID, fromClient, resp.ID))
+	if call == nil {
+		return raw
+	}
+	ctx := trace.ContextWithSpan(context.Background(), call.span)
+	if call.policy != nil {
+		ctx = context.WithValue(ctx, policyContextKey{}, call.policy)
+	}
+	reqInfo := call.reqInfo
+	respInfo := mcp.ExtractResponseInfo(resp, reqInfo.Method)
+
+	if reqInfo.Method == "initialize" && !respInfo.HasError {
+		h.sessions.TrackInitialize(resp, sessionID, call.body)
+		if upstreamID, ok := h.legacySessions.Load(sessionID); ok {
+			h.sessions.SetUpstream(sessionID, upstreamID.(string), call.span.SpanContext())
+			call.span.SetAttributes(attribute.String("mcp.proxy.upstream.session.id", upstreamID.(string)))
+		}
+	}
+	var session *mcp.Session
+	if sessionID != "" {
+		session = h.sessions.Get(sessionID)
+	}
+	// Cache tool definitions for argument and structuredContent validation
+	if reqInfo.Method == "tools/list" && !respInfo.HasError && session != nil {
+		h.cacheTools(ctx, session, &jsonrpc.Request{Params: call.params}, resp)
+	}
+
+	if rewrite := h.finalRewrite(ctx, call.span, up, session, sessionID, reqInfo); rewrite != nil && raw != nil {
+		raw = rewrite(resp, raw)
+	}
+	if h.config().CapturePayload && reqInfo.Method == "tools/call" {
+		telemetry.SetPayloadAttributes(call.span, string(call.params), string(resp.Result))
+	}
+
+	upstreamAttr := telemetry.UpstreamAttr(up.name)
+	duration := time.Since(call.start)
+	h.metrics.UpstreamLatency.Record(ctx, duration.Seconds(), telemetry.MethodToolAttrs(reqInfo.Method, reqInfo.ToolName), upstreamAttr)
+	h.metrics.RequestDuration.Record(ctx, duration.Seconds(),
+		telemetry.MethodToolErrorAttrs(reqInfo.Method, reqInfo.ToolName, respInfo), upstreamAttr)
+	h.metrics.MessageSize.Record(ctx, int64(size), telemetry.DirectionAttr("response"), telemetry.MethodAttr(reqInfo.Method), upstreamAttr)
+
+	telemetry.EndMCPSpan(call.span, respInfo)
+
+	h.logger.DebugContext(ctx, "MCP response",
+		"mcp.method.name", reqInfo.Method,
+		"gen_ai.tool.name", reqInfo.ToolName,
+		"duration_ms", duration.Milliseconds(),
+		"mcp.session.id", sessionID,
+		"upstream.name", up.name,
+	)
+	return raw
+}
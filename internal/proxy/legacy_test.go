@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRewriteEndpoint(t *testing.T) {
+	h := &Handler{logger: slog.New(slog.DiscardHandler)}
+	// Endpoints reached through the proxy carry a session ID of the
+	// proxy's, written as {session}
+	tests := []struct {
+		name, url, endpoint, want, upstreamSession string
+	}{
+		{"root upstream", "http://up:9092", "/messages?sessionId=abc", "/legacy/messages?sessionId={session}", "abc"},
+		{"base path", "http://up:9092/mcp", "/mcp/messages?session_id=abc", "/legacy/messages?session_id={session}", "abc"},
+		{"base path with slash", "http://up:9092/mcp/", "messages?sessionId=abc", "/legacy/messages?sessionId={session}", "abc"},
+		{"other parameters kept", "http://up:9092", "/messages?sessionId=abc&v=2", "/legacy/messages?sessionId={session}&v=2", "abc"},
+		{"absolute endpoint", "http://up:9092/mcp", "http://up:9092/mcp/messages", "/legacy/messages", ""},
+		{"outside base path", "http://up:9092/mcp", "/messages?sessionId=abc", "http://up:9092/messages?sessionId=abc", ""},
+		{"sibling of base path", "http://up:9092/mcp", "/mcp2/messages", "http://up:9092/mcp2/messages", ""},
+		{"other host", "http://up:9092", "http://other:9092/messages?sessionId=abc", "http://other:9092/messages?sessionId=abc", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := url.Parse(tt.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, session := h.rewriteEndpoint(&upstream{name: "legacy", prefix: "/legacy", url: u}, tt.endpoint)
+			if want := strings.ReplaceAll(tt.want, "{session}", session); got != want {
+				t.Errorf("expected %q, got %q", want, got)
+			}
+			upstreamID, _ := h.legacySessions.Load(session)
+			if tt.upstreamSession == "" {
+				if upstreamID != nil || (session != "" && session != "abc") {
+					t.Errorf("expected the upstream's session abc unmapped, got %q mapped to %v", session, upstreamID)
+				}
+				return
+			}
+			if session == tt.upstreamSession || upstreamID != tt.upstreamSession {
+				t.Errorf("expected a session of the proxy's mapped to %s, got %q mapped to %v", tt.upstreamSession, session, upstreamID)
+			}
+		})
+	}
+}
+
+// fakeLegacyUpstream serves the legacy HTTP+SSE transport for the session
+// s1. Every request POSTed to its message endpoint is answered on the
+// stream with a tools/list result listing get_pods, whose inputSchema
+// requires a string namespace, and delete_ns.
+func fakeLegacyUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	messages := make(chan []byte, 8)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /sse", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "event: endpoint\ndata: /messages?sessionId=s1\n\n")
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case m := <-messages:
+				_, _ = fmt.Fprintf(w, "event: message\ndata: %s\n\n", m)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+	mux.HandleFunc("POST /messages", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("sessionId") != "s1" {
+			http.Error(w, "unknown session", http.StatusNotFound)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		var reqs []struct {
+			ID json.RawMessage `json:"id"`
+		}
+		batch := bytes.HasPrefix(bytes.TrimSpace(body), []byte("["))
+		if !batch {
+			body = append(append([]byte("["), body...), ']')
+		}
+		_ = json.Unmarshal(body, &reqs)
+		var resps []string
+		for _, req := range reqs {
+			resps = append(resps, fmt.Sprintf(`{"jsonrpc":"2.0","id":%s,"result":{"tools":[`+
+				`{"name":"get_pods","inputSchema":{"type":"object","properties":{"namespace":{"type":"string"}}}},`+
+				`{"name":"delete_ns"}]}}`, req.ID))
+		}
+		msg := strings.Join(resps, ",")
+		if batch {
+			msg = "[" + msg + "]"
+		}
+		w.WriteHeader(http.StatusAccepted)
+		messages <- []byte(msg)
+	})
+	up := httptest.NewServer(mux)
+	t.Cleanup(up.Close)
+	return up
+}
+
+// openLegacyStream opens the legacy stream of the proxy at proxyURL and
+// returns the message endpoint it announces and the data of the events
+// that follow.
+func openLegacyStream(t *testing.T, proxyURL string) (string, <-chan string) {
+	t.Helper()
+	stream, err := http.Get(proxyURL + "/sse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = stream.Body.Close() })
+	data := make(chan string)
+	go func() {
+		scanner := bufio.NewScanner(stream.Body)
+		for scanner.Scan() {
+			if d, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				data <- d
+			}
+		}
+		close(data)
+	}()
+	return <-data, data
+}
+
+// postLegacy POSTs body to the legacy message endpoint of the proxy at
+// proxyURL and returns the status and body of the response.
+func postLegacy(t *testing.T, proxyURL, endpoint, body string) (int, string) {
+	t.Helper()
+	resp, err := http.Post(proxyURL+endpoint, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	got, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(got)
+}
+
+func TestLegacyStream_ListsFilteredByPolicy(t *testing.T) {
+	tests := []struct {
+		name, body string
+	}{
+		{"single", `{"jsonrpc":"2.0","id":1,"method":"tools/list"}`},
+		{"batch", `[{"jsonrpc":"2.0","id":1,"method":"tools/list"},{"jsonrpc":"2.0","id":2,"method":"tools/list"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up := fakeLegacyUpstream(t)
+			proxy := httptest.NewServer(newTestHandler(t, `
+upstreams:
+  - name: legacy
+    url: `+up.URL+`
+    transport: sse
+policy:
+  rules:
+    - name: no-deletes
+      action: deny
+      tools: ["delete_*"]
+`))
+			t.Cleanup(proxy.Close)
+
+			endpoint, data := openLegacyStream(t, proxy.URL)
+			if status, _ := postLegacy(t, proxy.URL, endpoint, tt.body); status != http.StatusAccepted {
+				t.Fatalf("expected 202 for the POST to %s, got %d", endpoint, status)
+			}
+
+			got := <-data
+			if !strings.Contains(got, "get_pods") || strings.Contains(got, "delete_ns") {
+				t.Errorf("expected delete_ns to be hidden from every list, got %s", got)
+			}
+		})
+	}
+}
+
+func TestLegacyStream_Session(t *testing.T) {
+	up := fakeLegacyUpstream(t)
+	h := newTestHandler(t, `
+upstreams:
+  - name: legacy
+    url: `+up.URL+`
+    transport: sse
+    validateInputSchema: reject
+`)
+	proxy := httptest.NewServer(h)
+	t.Cleanup(proxy.Close)
+
+	endpoint, data := openLegacyStream(t, proxy.URL)
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessionID := u.Query().Get("sessionId")
+	if sessionID == "" || sessionID == "s1" {
+		t.Fatalf("expected a session ID of the proxy's in the endpoint, got %s", endpoint)
+	}
+
+	// The upstream only knows s1, so every 202 shows the ID was mapped
+	for i, body := range []string{initializeBody, `{"jsonrpc":"2.0","id":2,"method":"tools/list"}`} {
+		if status, _ := postLegacy(t, proxy.URL, endpoint, body); status != http.StatusAccepted {
+			t.Fatalf("request %d: expected 202, got %d", i+1, status)
+		}
+		<-data
+	}
+	if upstreamID, _ := h.sessions.Upstream(sessionID); upstreamID != "s1" {
+		t.Errorf("expected the session to be served by s1, got %q", upstreamID)
+	}
+	if h.sessions.InitRequest(sessionID) == nil {
+		t.Error("expected the initialize request to be kept for the session")
+	}
+
+	status, body := postLegacy(t, proxy.URL, endpoint,
+		`{"jsonrpc":"2.0","id":3,"method":"tools/call","params":{"name":"get_pods","arguments":{"namespace":1}}}`)
+	if status != http.StatusOK || !strings.Contains(body, fmt.Sprint(invalidParamsCode)) {
+		t.Errorf("expected the cached schema to reject the call, got %d: %s", status, body)
+	}
+}
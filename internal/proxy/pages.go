@@ -0,0 +1,373 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/jsonrpc"
+	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
+	"github.com/isitobservable/mcp-otel-proxy/internal/paging"
+	"github.com/isitobservable/mcp-otel-proxy/internal/telemetry"
+)
+
+// pageToolName is the tool the proxy adds to tools/list of upstreams with a
+// response budget, and answers itself, to fetch the pages of truncated
+// results.
+const pageToolName = "proxy_next_page"
+
+// pageTool is the tools/list entry for pageToolName.
+var pageTool = json.RawMessage(`{"name":"` + pageToolName + `",` +
+	`"description":"Fetch the next page of a tool result that was too large to return at once. Pass the cursor from the truncation note at the end of the previous page.",` +
+	`"inputSchema":{"type":"object","properties":{"cursor":{"type":"string","description":"Cursor from the truncation note"}},"required":["cursor"]}}`)
+
+// invalidParamsCode is the JSON-RPC error code for malformed parameters.
+const invalidParamsCode = -32602
+
+// pageStore keeps the pages of truncated tool results until clients fetch
+// them. It is bounded in total size, evicting the oldest results first, and
+// results expire retention after they were last read.
+type pageStore struct {
+	mu        sync.Mutex
+	maxBytes  int
+	retention time.Duration
+	results   map[string]*pagedResult
+	order     []string // oldest first
+	size      int
+}
+
+// pagedResult is a truncated result. Page 0 was returned with the result
+// itself; the rest wait to be fetched within the session that called.
+type pagedResult struct {
+	sessionID string
+	tool      string
+	pages     []string
+	size      int
+	touched   time.Time
+}
+
+func newPageStore(maxBytes int, retention time.Duration) *pageStore {
+	return &pageStore{
+		maxBytes:  maxBytes,
+		retention: retention,
+		results:   make(map[string]*pagedResult),
+	}
+}
+
+// put stores pages and returns the id to fetch them by, or ok false when
+// they are too large for the store.
+func (s *pageStore) put(sessionID, tool string, pages []string) (id string, ok bool) {
+	size := 0
+	for _, p := range pages[1:] {
+		size += len(p)
+	}
+	if size > s.maxBytes {
+		return "", false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+	for s.size+size > s.maxBytes && len(s.order) > 0 {
+		s.remove(s.order[0])
+	}
+	id = mcp.NewSessionID()
+	s.results[id] = &pagedResult{sessionID: sessionID, tool: tool, pages: pages, size: size, touched: time.Now()}
+	s.order = append(s.order, id)
+	s.size += size
+	return id, true
+}
+
+// get returns a stored result if it belongs to sessionID.
+func (s *pageStore) get(id, sessionID string) (*pagedResult, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sweep()
+	res, ok := s.results[id]
+	if !ok || res.sessionID != sessionID {
+		return nil, false
+	}
+	res.touched = time.Now()
+	return res, true
+}
+
+// dropSession drops every result of a terminated session.
+func (s *pageStore) dropSession(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, res := range s.results {
+		if res.sessionID == sessionID {
+			s.remove(id)
+		}
+	}
+}
+
+// sweep drops expired results. The caller holds mu.
+func (s *pageStore) sweep() {
+	cutoff := time.Now().Add(-s.retention)
+	for id, res := range s.results {
+		if res.touched.Before(cutoff) {
+			s.remove(id)
+		}
+	}
+}
+
+// remove drops a result. The caller holds mu.
+func (s *pageStore) remove(id string) {
+	res, ok := s.results[id]
+	if !ok {
+		return
+	}
+	delete(s.results, id)
+	s.size -= res.size
+	for i, other := range s.order {
+		if other == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// pageCursor names page n of the stored result id.
+func pageCursor(id string, n int) string {
+	return id + ":" + strconv.Itoa(n)
+}
+
+func parseCursor(cursor string) (id string, n int, ok bool) {
+	id, num, found := strings.Cut(cursor, ":")
+	if !found {
+		return "", 0, false
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil || n < 1 {
+		return "", 0, false
+	}
+	return id, n, true
+}
+
+// withNote ends page n of a result of count pages with a note on where it
+// stands and how to fetch the next page.
+func withNote(page, id string, n, count int) string {
+	var note string
+	switch {
+	case id == "":
+		note = fmt.Sprintf("[Result truncated: page 1 of %d. The full result is too large to keep; narrow the request to see more.]", count)
+	case n+1 == count:
+		note = fmt.Sprintf("[Page %d of %d: end of result.]", n+1, count)
+	default:
+		note = fmt.Sprintf("[Result truncated: page %d of %d. Call the %s tool with cursor %q for the next page.]",
+			n+1, count, pageToolName, pageCursor(id, n+1))
+	}
+	return strings.TrimRight(page, "\n") + "\n\n" + note
+}
+
+// truncateResponse returns the first page of a tool call response whose
+// text content is over budget, keeping the remaining pages for the page
+// tool. Text blocks are joined into one text before paging; other content
+// blocks are kept, and structuredContent is dropped since it would repeat
+// the full result.
+func (h *Handler) truncateResponse(ctx context.Context, span trace.Span, up *upstream, sessionID, tool string, budget paging.Budget, respBody []byte) []byte {
+	var resp jsonrpc.Response
+	if err := json.Unmarshal(respBody, &resp); err != nil || len(resp.Result) == 0 {
+		return respBody
+	}
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return respBody
+	}
+	var content []map[string]json.RawMessage
+	if err := json.Unmarshal(result["content"], &content); err != nil {
+		return respBody
+	}
+
+	var texts []string
+	var other []map[string]json.RawMessage
+	for _, block := range content {
+		var blockType, text string
+		if json.Unmarshal(block["type"], &blockType) == nil && blockType == "text" && json.Unmarshal(block["text"], &text) == nil {
+			texts = append(texts, text)
+			continue
+		}
+		other = append(other, block)
+	}
+	text := strings.Join(texts, "\n")
+	pages := paging.Split(text, budget, h.state.Load().tokens)
+	if len(pages) == 1 {
+		return respBody
+	}
+
+	id, stored := h.pages.put(sessionID, tool, pages)
+	textBlock, _ := json.Marshal(map[string]string{"type": "text", "text": withNote(pages[0], id, 0, len(pages))})
+	blocks := []json.RawMessage{textBlock}
+	for _, block := range other {
+		raw, _ := json.Marshal(block)
+		blocks = append(blocks, raw)
+	}
+	newContent, err := json.Marshal(blocks)
+	if err != nil {
+		return respBody
+	}
+	result["content"] = newContent
+	delete(result, "structuredContent")
+	if resp.Result, err = json.Marshal(result); err != nil {
+		return respBody
+	}
+	newRespBody, err := json.Marshal(resp)
+	if err != nil {
+		return respBody
+	}
+
+	attrs := []attribute.KeyValue{
+		attribute.Bool("mcp.response.truncated", true),
+		attribute.Int("mcp.response.truncation.pages", len(pages)),
+		attribute.Int("mcp.response.truncation.original_bytes", len(text)),
+		attribute.Bool("mcp.response.truncation.stored", stored),
+	}
+	if budget.Tokens > 0 {
+		attrs = append(attrs, attribute.Int("mcp.response.truncation.budget.tokens", budget.Tokens))
+	}
+	if budget.Bytes > 0 {
+		attrs = append(attrs, attribute.Int("mcp.response.truncation.budget.bytes", budget.Bytes))
+	}
+	span.SetAttributes(attrs...)
+	h.metrics.Truncations.Add(ctx, 1, telemetry.MethodToolAttrs("tools/call", tool), telemetry.UpstreamAttr(up.name))
+	h.logger.DebugContext(ctx, "truncated response",
+		"gen_ai.tool.name", tool,
+		"pages", len(pages),
+		"original_bytes", len(text),
+		"stored", stored,
+	)
+	return newRespBody
+}
+
+// withPageTool adds the page tool to the last page of a tools/list result.
+func withPageTool(respBody []byte) []byte {
+	var resp jsonrpc.Response
+	if err := json.Unmarshal(respBody, &resp); err != nil || resp.Error != nil || len(resp.Result) == 0 {
+		return respBody
+	}
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return respBody
+	}
+	var nextCursor string
+	if json.Unmarshal(result["nextCursor"], &nextCursor) == nil && nextCursor != "" {
+		return respBody
+	}
+	var tools []json.RawMessage
+	if err := json.Unmarshal(result["tools"], &tools); err != nil {
+		return respBody
+	}
+	tools = append(tools, pageTool)
+
+	var err error
+	if result["tools"], err = json.Marshal(tools); err != nil {
+		return respBody
+	}
+	if resp.Result, err = json.Marshal(result); err != nil {
+		return respBody
+	}
+	newRespBody, err := json.Marshal(resp)
+	if err != nil {
+		return respBody
+	}
+	return newRespBody
+}
+
+// servePage answers a call of the page tool from the page store and reports
+// whether the request was one.
+func (h *Handler) servePage(w http.ResponseWriter, r *http.Request, up *upstream, req *jsonrpc.Request) bool {
+	ctx := telemetry.ExtractContextFromMeta(r.Context(), req.Params, propagation.HeaderCarrier(r.Header))
+	resp, ok := h.pageResponse(ctx, r, up, req)
+	if !ok {
+		return false
+	}
+	body, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+	return true
+}
+
+// pageResponse answers a call of the page tool from the page store, in a
+// span that is a child of ctx, and reports whether the request was one.
+func (h *Handler) pageResponse(ctx context.Context, r *http.Request, up *upstream, req *jsonrpc.Request) (*jsonrpc.Response, bool) {
+	if req.Method != "tools/call" || !up.settings.Load().PagesResponses() {
+		return nil, false
+	}
+	var params struct {
+		Name      string `json:"name"`
+		Arguments struct {
+			Cursor string `json:"cursor"`
+		} `json:"arguments"`
+	}
+	if json.Unmarshal(req.Params, &params) != nil || params.Name != pageToolName {
+		return nil, false
+	}
+
+	sessionID := sessionKey(r, up)
+	var session *mcp.Session
+	if sessionID != "" {
+		session = h.sessions.Get(sessionID)
+	}
+	reqInfo := mcp.ExtractRequestInfo(req)
+	ctx, span := telemetry.StartMCPSpan(ctx, reqInfo, session, up.peer)
+	defer span.End()
+	h.recordPolicy(ctx, span, r, up, req, reqInfo)
+	upstreamAttr := telemetry.UpstreamAttr(up.name)
+	h.metrics.RequestCount.Add(ctx, 1, telemetry.MethodToolAttrs(reqInfo.Method, reqInfo.ToolName), upstreamAttr)
+
+	resp := jsonrpc.Response{JSONRPC: "2.0", ID: req.ID}
+	errType := ""
+	id, n, ok := parseCursor(params.Arguments.Cursor)
+	var res *pagedResult
+	if ok {
+		res, ok = h.pages.get(id, sessionID)
+	}
+	switch {
+	case params.Arguments.Cursor == "":
+		resp.Error = &jsonrpc.Error{Code: invalidParamsCode, Message: "cursor is required"}
+		errType = "invalid_params"
+		span.SetStatus(codes.Error, "cursor is required")
+
+	case !ok || n >= len(res.pages):
+		// A tool error the model can read, rather than a protocol error
+		resp.Result, _ = json.Marshal(map[string]any{
+			"content": []map[string]string{{"type": "text", "text": "This page is no longer available. Call the original tool again."}},
+			"isError": true,
+		})
+		errType = "page_not_found"
+		span.SetStatus(codes.Error, "page not found")
+		h.metrics.PageFetches.Add(ctx, 1, telemetry.PageResultAttr("miss"), upstreamAttr)
+
+	default:
+		resp.Result, _ = json.Marshal(map[string]any{
+			"content": []map[string]string{{"type": "text", "text": withNote(res.pages[n], id, n, len(res.pages))}},
+		})
+		span.SetAttributes(
+			attribute.String("mcp.response.page.tool", res.tool),
+			attribute.Int("mcp.response.page.number", n+1),
+			attribute.Int("mcp.response.page.count", len(res.pages)),
+		)
+		h.metrics.PageFetches.Add(ctx, 1, telemetry.PageResultAttr("hit"), upstreamAttr)
+	}
+	if errType != "" {
+		span.SetAttributes(attribute.String("error.type", errType))
+	}
+
+	h.logger.DebugContext(ctx, "served result page",
+		"cursor", params.Arguments.Cursor,
+		"error.type", errType,
+		"upstream.name", up.name,
+	)
+	return &resp, true
+}
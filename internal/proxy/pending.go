@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/jsonrpc"
+	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
+)
+
+// pendingTimeout bounds how long a span waits for a response that arrives on
+// a different HTTP exchange than its request.
+const pendingTimeout = 10 * time.Minute
+
+// pendingCall is an open span for a request whose response travels
+// separately, such as a server-initiated request the client answers with a
+// later POST.
+type pendingCall struct {
+	span    trace.Span
+	reqInfo *mcp.RequestInfo
+	// params is kept for payload capture once the response arrives.
+	params json.RawMessage
+	// body is the initialize request, kept for the session; nil for other
+	// methods.
+	body []byte
+	// policy is what the policy decided for the request, for filtering
+	// list results; nil without a policy.
+	policy *policyContext
+	start  time.Time
+	timer  *time.Timer
+}
+
+// pendingCalls tracks open spans keyed by session, direction and JSON-RPC
+// ID.
+type pendingCalls struct {
+	mu    sync.Mutex
+	calls map[string]*pendingCall
+	// timeout is how long a call waits to be taken; pendingTimeout unless
+	// a test shortens it.
+	timeout time.Duration
+}
+
+func newPendingCalls() *pendingCalls {
+	return &pendingCalls{calls: make(map[string]*pendingCall), timeout: pendingTimeout}
+}
+
+// Directions of a pending request. Client and server each number their own
+// requests, so the same ID can be in flight both ways at once.
+const (
+	fromClient = "c"
+	fromServer = "s"
+)
+
+func pendingKey(sessionID, direction string, id json.RawMessage) string {
+	return sessionID + "|" + direction + "|" + jsonrpc.IDString(id)
+}
+
+// add registers call under key. If nothing takes it within p.timeout,
+// it is removed and onExpire is called.
+func (p *pendingCalls) add(key string, call *pendingCall, onExpire func(*pendingCall)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if prev, ok := p.calls[key]; ok {
+		prev.timer.Stop()
+		onExpire(prev)
+	}
+	call.timer = time.AfterFunc(p.timeout, func() {
+		if c := p.take(key); c != nil {
+			onExpire(c)
+		}
+	})
+	p.calls[key] = call
+}
+
+// take removes and returns the call registered under key, or nil.
+func (p *pendingCalls) take(key string) *pendingCall {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	call, ok := p.calls[key]
+	if !ok {
+		return nil
+	}
+	delete(p.calls, key)
+	call.timer.Stop()
+	return call
+}
+
+// takeSession removes and returns every call registered for sessionID.
+func (p *pendingCalls) takeSession(sessionID string) []*pendingCall {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var calls []*pendingCall
+	for key, call := range p.calls {
+		if strings.HasPrefix(key, sessionID+"|") {
+			delete(p.calls, key)
+			call.timer.Stop()
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
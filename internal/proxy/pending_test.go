@@ -0,0 +1,49 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
+)
+
+func TestPendingCalls_DirectionsDoNotCollide(t *testing.T) {
+	p := newPendingCalls()
+	span := trace.SpanFromContext(context.Background())
+	id := json.RawMessage(`1`)
+	expired := 0
+	onExpire := func(*pendingCall) { expired++ }
+
+	client := &pendingCall{span: span, reqInfo: &mcp.RequestInfo{Method: "tools/call"}}
+	server := &pendingCall{span: span, reqInfo: &mcp.RequestInfo{Method: "sampling/createMessage"}}
+	p.add(pendingKey("s1", fromClient, id), client, onExpire)
+	p.add(pendingKey("s1", fromServer, id), server, onExpire)
+	if expired != 0 {
+		t.Fatalf("expected no call to be displaced, %d were", expired)
+	}
+
+	if got := p.take(pendingKey("s1", fromServer, id)); got != server {
+		t.Errorf("expected the server request, got %+v", got)
+	}
+	if got := p.take(pendingKey("s1", fromClient, id)); got != client {
+		t.Errorf("expected the client request, got %+v", got)
+	}
+}
+
+func TestPendingCalls_TakeSession(t *testing.T) {
+	p := newPendingCalls()
+	span := trace.SpanFromContext(context.Background())
+	p.add(pendingKey("s1", fromClient, json.RawMessage(`1`)), &pendingCall{span: span}, expirePending)
+	p.add(pendingKey("s1", fromServer, json.RawMessage(`"a"`)), &pendingCall{span: span}, expirePending)
+	p.add(pendingKey("s10", fromClient, json.RawMessage(`1`)), &pendingCall{span: span}, expirePending)
+
+	if got := len(p.takeSession("s1")); got != 2 {
+		t.Errorf("expected both calls of s1, got %d", got)
+	}
+	if p.take(pendingKey("s10", fromClient, json.RawMessage(`1`))) == nil {
+		t.Error("expected the call of s10 to be kept")
+	}
+}
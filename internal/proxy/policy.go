@@ -0,0 +1,468 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/config"
+	"github.com/isitobservable/mcp-otel-proxy/internal/jsonrpc"
+	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
+	"github.com/isitobservable/mcp-otel-proxy/internal/telemetry"
+)
+
+// policyDeniedCode is the JSON-RPC error code returned for requests the
+// policy denies.
+const policyDeniedCode = -32001
+
+// defaultRuleName identifies the policy default in telemetry.
+const defaultRuleName = "default"
+
+// JSON-RPC error codes for bodies that are not JSON, and for JSON that is
+// not a request.
+const (
+	parseErrorCode     = -32700
+	invalidRequestCode = -32600
+)
+
+// evaluatePolicy returns the action for a request from client, which is
+// empty when the client could not be identified, and the name of the rule
+// that decided it. When listing, the request stands for an item of a list
+// result, whose arguments are only known once the item is called: rules on
+// arguments are taken to match some call, so a deny rule on arguments is
+// skipped and any other rule on arguments keeps the item usable.
+func evaluatePolicy(policy *config.Policy, upstreamName, client string, reqInfo *mcp.RequestInfo, listing bool) (action, rule string) {
+	for i := range policy.Rules {
+		r := &policy.Rules[i]
+		if listing && len(r.Arguments) > 0 {
+			if r.Action == config.PolicyDeny {
+				continue
+			}
+			anyArguments := *r
+			anyArguments.Arguments = nil
+			r = &anyArguments
+		}
+		if ruleMatches(r, upstreamName, client, reqInfo) {
+			return r.Action, r.Name
+		}
+	}
+	return policy.Default, defaultRuleName
+}
+
+// ruleMatches reports whether every matcher set on r matches the request.
+// Tool and argument matchers only match tools/call requests, and resource
+// matchers only requests naming a resource.
+func ruleMatches(r *config.PolicyRule, upstreamName, client string, reqInfo *mcp.RequestInfo) bool {
+	if len(r.Upstreams) > 0 && !slices.Contains(r.Upstreams, upstreamName) {
+		return false
+	}
+	if len(r.Methods) > 0 && !matchAny(r.Methods, reqInfo.Method) {
+		return false
+	}
+	if len(r.Tools) > 0 && (reqInfo.ToolName == "" || !matchAny(r.Tools, reqInfo.ToolName)) {
+		return false
+	}
+	if len(r.Resources) > 0 && (reqInfo.ResourceURI == "" || !globAny(r.Resources, reqInfo.ResourceURI)) {
+		return false
+	}
+	if len(r.Clients) > 0 && (client == "" || !globAny(r.Clients, client)) {
+		return false
+	}
+	if len(r.Arguments) > 0 && !argumentsMatch(r.Arguments, reqInfo.ToolArguments) {
+		return false
+	}
+	return true
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func globAny(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if globMatch(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// globMatch reports whether name matches pattern, in which * matches any
+// run of characters, / included, and ? any single character. Unlike
+// path.Match it suits URIs and file paths, where a pattern such as
+// file:///etc/* should cover every file below /etc.
+func globMatch(pattern, name string) bool {
+	p, n := []rune(pattern), []rune(name)
+	// star and next are where to resume after the last *, should the rest
+	// fail to match
+	star, next := -1, 0
+	i, j := 0, 0
+	for j < len(n) {
+		switch {
+		case i < len(p) && (p[i] == '?' || p[i] == n[j]):
+			i++
+			j++
+		case i < len(p) && p[i] == '*':
+			star, next = i, j
+			i++
+		case star >= 0:
+			next++
+			i, j = star+1, next
+		default:
+			return false
+		}
+	}
+	for i < len(p) && p[i] == '*' {
+		i++
+	}
+	return i == len(p)
+}
+
+// argumentsMatch reports whether every argument path in patterns is set and
+// matches its pattern. Strings are matched as they are and other values as
+// JSON; an array matches when any of its elements does.
+func argumentsMatch(patterns map[string]string, rawArgs json.RawMessage) bool {
+	var args any
+	if len(rawArgs) == 0 || json.Unmarshal(rawArgs, &args) != nil {
+		return false
+	}
+	for key, pattern := range patterns {
+		v := args
+		for _, part := range strings.Split(key, ".") {
+			obj, ok := v.(map[string]any)
+			if !ok {
+				return false
+			}
+			if v, ok = obj[part]; !ok {
+				return false
+			}
+		}
+		values := []any{v}
+		if list, ok := v.([]any); ok {
+			values = list
+		}
+		if !slices.ContainsFunc(values, func(v any) bool { return globMatch(pattern, argumentString(v)) }) {
+			return false
+		}
+	}
+	return true
+}
+
+func argumentString(v any) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// policyDecision is the outcome of the policy for one request.
+type policyDecision struct {
+	action, rule string
+	// approvalID names the approval a require-approval request was held
+	// for, or forwarded on when approved is set.
+	approvalID string
+	approved   bool
+}
+
+// held reports whether the request waits for approval.
+func (d policyDecision) held() bool {
+	return d.action == config.PolicyRequireApproval && !d.approved
+}
+
+// blocks reports whether the request is answered by the proxy.
+func (d policyDecision) blocks() bool {
+	return d.action == config.PolicyDeny || d.held()
+}
+
+func (d policyDecision) attributes() []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("mcp.proxy.policy.rule", d.rule),
+		attribute.String("mcp.proxy.policy.action", d.action),
+	}
+	if d.action == config.PolicyRequireApproval {
+		status := "pending"
+		if d.approved {
+			status = "granted"
+		}
+		attrs = append(attrs,
+			attribute.String("mcp.proxy.policy.approval.id", d.approvalID),
+			attribute.String("mcp.proxy.policy.approval.status", status),
+		)
+	}
+	return attrs
+}
+
+// policyContextKey carries a policyContext in the context of a request the
+// policy let through.
+type policyContextKey struct{}
+
+// policyContext is what enforcePolicy decided for a forwarded request, for
+// its spans, audit log and list filtering.
+type policyContext struct {
+	client    string
+	decisions map[*jsonrpc.Request]policyDecision
+}
+
+func policyFrom(ctx context.Context) *policyContext {
+	pc, _ := ctx.Value(policyContextKey{}).(*policyContext)
+	return pc
+}
+
+// enforcePolicy decides every message of a request. When the policy denies
+// any of them or holds one for approval, it answers the request itself and
+// reports true; a batch is rejected as a whole so an allowed message is
+// never forwarded alongside a blocked one. Otherwise it returns r with the
+// decisions attached, for recordPolicy to record once the request spans
+// exist.
+func (h *Handler) enforcePolicy(w http.ResponseWriter, r *http.Request, up *upstream, parsed *jsonrpc.ParseResult) (*http.Request, bool) {
+	state := h.state.Load()
+	policy := &state.config.Policy
+	if !policy.Enabled() {
+		return r, false
+	}
+
+	client, _ := state.identity.Resolve(r)
+	sessionID := r.Header.Get("Mcp-Session-Id")
+	pc := &policyContext{client: client, decisions: make(map[*jsonrpc.Request]policyDecision, len(parsed.Requests))}
+	blocked := false
+	for i := range parsed.Requests {
+		req := &parsed.Requests[i]
+		if req.Method == "" {
+			continue
+		}
+		reqInfo := mcp.ExtractRequestInfo(req)
+		var d policyDecision
+		d.action, d.rule = evaluatePolicy(policy, up.name, client, reqInfo, false)
+		if d.action == config.PolicyRequireApproval {
+			key := approvalKey(up.name, client, sessionID, req)
+			if a, ok := h.approvals.take(key); ok {
+				d.approvalID, d.approved = a.ID, true
+			} else {
+				d.approvalID = h.approvals.hold(&approval{
+					Rule:      d.rule,
+					Upstream:  up.name,
+					Client:    client,
+					SessionID: sessionID,
+					Method:    reqInfo.Method,
+					Target:    reqInfo.Target(),
+					Arguments: reqInfo.ToolArguments,
+					key:       key,
+				}, time.Duration(policy.ApprovalTTLSeconds)*time.Second).ID
+			}
+		}
+		pc.decisions[req] = d
+		blocked = blocked || d.blocks()
+	}
+	if !blocked {
+		return r.WithContext(context.WithValue(r.Context(), policyContextKey{}, pc)), false
+	}
+
+	var session *mcp.Session
+	if sessionID != "" {
+		session = h.sessions.Get(sessionID)
+	}
+	var responses []jsonrpc.Response
+	for i := range parsed.Requests {
+		req := &parsed.Requests[i]
+		if req.Method == "" {
+			continue
+		}
+		d := pc.decisions[req]
+		rpcErr := &jsonrpc.Error{Code: policyDeniedCode, Message: "batch rejected: another request in it was blocked by policy"}
+		switch {
+		case d.action == config.PolicyDeny:
+			rpcErr.Message = fmt.Sprintf("denied by policy rule %q", d.rule)
+		case d.held():
+			data, _ := json.Marshal(map[string]string{"approvalId": d.approvalID, "rule": d.rule})
+			rpcErr = &jsonrpc.Error{
+				Code:    approvalRequiredCode,
+				Message: fmt.Sprintf("approval required by policy rule %q: retry the request once an operator has approved %s", d.rule, d.approvalID),
+				Data:    data,
+			}
+		}
+		h.recordBlocked(r, up, req, session, client, d)
+		if !req.IsNotification() {
+			responses = append(responses, jsonrpc.Response{JSONRPC: "2.0", ID: req.ID, Error: rpcErr})
+		}
+	}
+
+	if len(responses) == 0 {
+		w.WriteHeader(http.StatusAccepted)
+		return r, true
+	}
+	var body []byte
+	if parsed.IsBatch {
+		body, _ = json.Marshal(responses)
+	} else {
+		body, _ = json.Marshal(responses[0])
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(body)
+	return r, true
+}
+
+// rejectUnparsed answers a request body that does not parse as JSON-RPC
+// while a policy is enabled. Forwarding it would let the upstream act on
+// messages the policy never saw.
+func rejectUnparsed(w http.ResponseWriter, body []byte) {
+	rpcErr := &jsonrpc.Error{Code: parseErrorCode, Message: "Parse error"}
+	if json.Valid(body) {
+		rpcErr = &jsonrpc.Error{Code: invalidRequestCode, Message: "Invalid Request"}
+	}
+	resp, _ := json.Marshal(jsonrpc.Response{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: rpcErr})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	_, _ = w.Write(resp)
+}
+
+// recordBlocked gives a message of a request the policy blocked its span,
+// error metric and audit log entry. Messages of a blocked batch that the
+// policy itself allowed are recorded with their own decision.
+func (h *Handler) recordBlocked(r *http.Request, up *upstream, req *jsonrpc.Request, session *mcp.Session, client string, d policyDecision) {
+	reqInfo := mcp.ExtractRequestInfo(req)
+	ctx := telemetry.ExtractContextFromMeta(r.Context(), req.Params, propagation.HeaderCarrier(r.Header))
+	ctx, span := telemetry.StartMCPSpan(ctx, reqInfo, session, up.peer)
+	defer span.End()
+
+	errType, message := "policy_denied", "denied by policy"
+	switch {
+	case d.held():
+		errType, message = "approval_required", "awaiting policy approval"
+	case !d.blocks():
+		errType, message = "policy_denied", "batch blocked by policy"
+	}
+	span.SetAttributes(attribute.String("error.type", errType))
+	span.SetStatus(codes.Error, message)
+	h.metrics.ErrorsTotal.Add(ctx, 1, telemetry.ErrorAttr(errType), telemetry.UpstreamAttr(up.name))
+	h.recordDecision(ctx, span, up, r.Header.Get("Mcp-Session-Id"), client, reqInfo, d)
+}
+
+// recordPolicy records the policy decision for a forwarded request on its
+// span and in the audit log. It does nothing when no policy is configured.
+func (h *Handler) recordPolicy(ctx context.Context, span trace.Span, r *http.Request, up *upstream, req *jsonrpc.Request, reqInfo *mcp.RequestInfo) {
+	pc := policyFrom(r.Context())
+	if pc == nil {
+		return
+	}
+	if d, ok := pc.decisions[req]; ok {
+		h.recordDecision(ctx, span, up, r.Header.Get("Mcp-Session-Id"), pc.client, reqInfo, d)
+	}
+}
+
+// recordDecision sets the policy attributes of span and writes the audit
+// log entry of a decision.
+func (h *Handler) recordDecision(ctx context.Context, span trace.Span, up *upstream, sessionID, client string, reqInfo *mcp.RequestInfo, d policyDecision) {
+	span.SetAttributes(d.attributes()...)
+
+	level := slog.LevelInfo
+	if d.blocks() {
+		level = slog.LevelWarn
+	}
+	attrs := []any{
+		"policy.rule", d.rule,
+		"policy.action", d.action,
+		"client.id", client,
+		"mcp.session.id", sessionID,
+		"mcp.method.name", reqInfo.Method,
+		"mcp.target", reqInfo.Target(),
+		"jsonrpc.request.id", reqInfo.RequestID,
+		"upstream.name", up.name,
+	}
+	if d.approvalID != "" {
+		attrs = append(attrs, "policy.approval.id", d.approvalID, "policy.approval.granted", d.approved)
+	}
+	h.logger.Log(ctx, level, "policy decision", attrs...)
+}
+
+// listedItem describes the items of a list result: the field holding them,
+// the key naming each item, and the method that uses one.
+type listedItem struct {
+	field, key, method string
+}
+
+var listedItems = map[string]listedItem{
+	"tools/list":     {"tools", "name", "tools/call"},
+	"prompts/list":   {"prompts", "name", "prompts/get"},
+	"resources/list": {"resources", "uri", "resources/read"},
+}
+
+// filterList drops the items of a tools/list, prompts/list or
+// resources/list result that the policy would deny the client to use.
+// Items behind require-approval rules stay listed.
+func (h *Handler) filterList(ctx context.Context, span trace.Span, up *upstream, method string, respBody []byte) []byte {
+	items, ok := listedItems[method]
+	pc := policyFrom(ctx)
+	if !ok || pc == nil {
+		return respBody
+	}
+	var resp jsonrpc.Response
+	if err := json.Unmarshal(respBody, &resp); err != nil || resp.Error != nil || len(resp.Result) == 0 {
+		return respBody
+	}
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return respBody
+	}
+	var list []json.RawMessage
+	if err := json.Unmarshal(result[items.field], &list); err != nil {
+		return respBody
+	}
+
+	policy := &h.config().Policy
+	kept := list[:0]
+	for _, raw := range list {
+		var item map[string]json.RawMessage
+		var name string
+		if json.Unmarshal(raw, &item) != nil || json.Unmarshal(item[items.key], &name) != nil {
+			kept = append(kept, raw)
+			continue
+		}
+		info := &mcp.RequestInfo{Method: items.method}
+		switch items.method {
+		case "tools/call":
+			info.ToolName = name
+		case "prompts/get":
+			info.PromptName = name
+		case "resources/read":
+			info.ResourceURI = name
+		}
+		if action, _ := evaluatePolicy(policy, up.name, pc.client, info, true); action != config.PolicyDeny {
+			kept = append(kept, raw)
+		}
+	}
+	hidden := len(list) - len(kept)
+	if hidden == 0 {
+		return respBody
+	}
+
+	var err error
+	if result[items.field], err = json.Marshal(kept); err != nil {
+		return respBody
+	}
+	if resp.Result, err = json.Marshal(result); err != nil {
+		return respBody
+	}
+	newRespBody, err := json.Marshal(resp)
+	if err != nil {
+		return respBody
+	}
+	span.SetAttributes(attribute.Int("mcp.proxy.policy.hidden_items", hidden))
+	return newRespBody
+}
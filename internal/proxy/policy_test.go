@@ -0,0 +1,393 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/config"
+	"github.com/isitobservable/mcp-otel-proxy/internal/jsonrpc"
+	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
+	"github.com/isitobservable/mcp-otel-proxy/internal/telemetry"
+)
+
+// newTestHandler returns a handler configured by the given YAML config
+// file.
+func newTestHandler(t *testing.T, content string) *Handler {
+	t.Helper()
+	return newTestHandlerWithSessions(t, content, mcp.NewSessionStore(time.Hour, nil, nil))
+}
+
+// newTestHandlerWithSessions is newTestHandler with the session store given.
+func newTestHandlerWithSessions(t *testing.T, content string, sessions *mcp.SessionStore) *Handler {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "proxy.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.LoadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := telemetry.InitMetrics()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := New(cfg, metrics, sessions, slog.New(slog.DiscardHandler))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+// countingUpstream answers every request with an empty result and counts
+// the requests that reached it.
+func countingUpstream(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var forwarded atomic.Int32
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"jsonrpc":"2.0","id":1,"result":{}}`)
+	}))
+	t.Cleanup(up.Close)
+	return up, &forwarded
+}
+
+func TestServeHTTP_UnparsedBodyUnderPolicy(t *testing.T) {
+	const denyDeletes = `
+policy:
+  rules:
+    - name: no-deletes
+      action: deny
+      tools: ["delete_*"]
+`
+	smuggled := `[{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"delete_ns"}}, 0]`
+	tests := []struct {
+		name, policy, body string
+		// wantCode is the JSON-RPC error expected, or 0 when the body
+		// should be forwarded
+		wantCode int
+	}{
+		{"batch with a non-object", denyDeletes, smuggled, invalidRequestCode},
+		{"truncated JSON", denyDeletes, `{"jsonrpc":"2.0","id":1,"method":"tools/call"`, parseErrorCode},
+		{"no policy forwards raw", "", smuggled, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up, forwarded := countingUpstream(t)
+			h := newTestHandler(t, "upstreams:\n  - name: up\n    url: "+up.URL+"\n"+tt.policy)
+
+			req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if tt.wantCode == 0 {
+				if forwarded.Load() != 1 {
+					t.Errorf("expected the body to be forwarded, got status %d: %s", rec.Code, rec.Body)
+				}
+				return
+			}
+			if forwarded.Load() != 0 {
+				t.Error("expected the body not to reach the upstream")
+			}
+			var resp jsonrpc.Response
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("expected a JSON-RPC error, got %s", rec.Body)
+			}
+			if rec.Code != http.StatusBadRequest || resp.Error == nil || resp.Error.Code != tt.wantCode || string(resp.ID) != "null" {
+				t.Errorf("expected 400 with error %d and a null id, got %d: %s", tt.wantCode, rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestEvaluatePolicy(t *testing.T) {
+	policy := &config.Policy{
+		Default: config.PolicyDeny,
+		Rules: []config.PolicyRule{
+			{Name: "dev-exec", Action: config.PolicyAllow, Tools: []string{"exec_in_pod"}, Arguments: map[string]string{"ns": "dev"}},
+			{Name: "no-exec", Action: config.PolicyDeny, Tools: []string{"exec_in_pod"}},
+			{Name: "no-prod-deletes", Action: config.PolicyDeny, Tools: []string{"delete_*"}, Arguments: map[string]string{"ns": "prod-*"}},
+			{Name: "prod-scale", Action: config.PolicyRequireApproval, Tools: []string{"scale"}, Arguments: map[string]string{"ns": "prod-*"}},
+			{Name: "no-scale", Action: config.PolicyDeny, Tools: []string{"scale"}},
+			{Name: "sre", Action: config.PolicyAllow, Clients: []string{"sre-*"}},
+			{Name: "reads", Action: config.PolicyAllow, Methods: []string{"*/list", "tools/call"}, Upstreams: []string{"k8s"}},
+		},
+	}
+	call := func(tool, args string) *mcp.RequestInfo {
+		return &mcp.RequestInfo{Method: "tools/call", ToolName: tool, ToolArguments: json.RawMessage(args)}
+	}
+	tests := []struct {
+		name, upstream, client string
+		reqInfo                *mcp.RequestInfo
+		listing                bool
+		wantAction, wantRule   string
+	}{
+		{"allowed arguments", "k8s", "", call("exec_in_pod", `{"ns":"dev"}`), false, config.PolicyAllow, "dev-exec"},
+		{"other arguments", "k8s", "", call("exec_in_pod", `{"ns":"prod"}`), false, config.PolicyDeny, "no-exec"},
+		{"denied arguments", "k8s", "", call("delete_ns", `{"ns":"prod-eu"}`), false, config.PolicyDeny, "no-prod-deletes"},
+		{"arguments the deny rule misses", "k8s", "", call("delete_ns", `{"ns":"dev"}`), false, config.PolicyAllow, "reads"},
+		{"held arguments", "k8s", "", call("scale", `{"ns":"prod-eu"}`), false, config.PolicyRequireApproval, "prod-scale"},
+		{"client rule", "other", "sre-alice", call("get_pods", `{}`), false, config.PolicyAllow, "sre"},
+		{"client without identity", "other", "", call("get_pods", `{}`), false, config.PolicyDeny, defaultRuleName},
+		{"upstream rule", "other", "", call("get_pods", `{}`), false, config.PolicyDeny, defaultRuleName},
+
+		{"listing an item allowed for some arguments", "k8s", "", call("exec_in_pod", ""), true, config.PolicyAllow, "dev-exec"},
+		{"listing an item denied for some arguments", "k8s", "", call("delete_ns", ""), true, config.PolicyAllow, "reads"},
+		{"listing an item held for some arguments", "k8s", "", call("scale", ""), true, config.PolicyRequireApproval, "prod-scale"},
+		{"listing on another upstream", "other", "", call("delete_ns", ""), true, config.PolicyDeny, defaultRuleName},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, rule := evaluatePolicy(policy, tt.upstream, tt.client, tt.reqInfo, tt.listing)
+			if action != tt.wantAction || rule != tt.wantRule {
+				t.Errorf("expected %s by %q, got %s by %q", tt.wantAction, tt.wantRule, action, rule)
+			}
+		})
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"", "", true},
+		{"abc", "abc", true},
+		{"abc", "abcd", false},
+		{"*", "", true},
+		{"*", "a/b/c", true},
+		{"file:///etc/*", "file:///etc/ssl/certs/ca.pem", true},
+		{"file:///etc/*", "file:///home/etc/passwd", false},
+		{"k8s://*/secrets/*", "k8s://prod/secrets/db", true},
+		{"k8s://*/secrets/*", "k8s://prod/configmaps/db", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"a*b*c", "axxbyybzc", true},
+		{"a*b*c", "axxbyy", false},
+		{"*.log", "var/log/app.log", true},
+		{"prod-*", "dev", false},
+		{"**", "x", true},
+	}
+	for _, tt := range tests {
+		if got := globMatch(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("globMatch(%q, %q) = %v, expected %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestArgumentsMatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns map[string]string
+		args     string
+		want     bool
+	}{
+		{"string", map[string]string{"ns": "prod-*"}, `{"ns":"prod-eu"}`, true},
+		{"string mismatch", map[string]string{"ns": "prod-*"}, `{"ns":"dev"}`, false},
+		{"missing argument", map[string]string{"ns": "*"}, `{"name":"x"}`, false},
+		{"nested path", map[string]string{"filter.namespace": "kube-*"}, `{"filter":{"namespace":"kube-system"}}`, true},
+		{"path through a non-object", map[string]string{"filter.namespace": "*"}, `{"filter":"kube-system"}`, false},
+		{"number as JSON", map[string]string{"replicas": "1?"}, `{"replicas":10}`, true},
+		{"bool as JSON", map[string]string{"force": "true"}, `{"force":true}`, true},
+		{"object as JSON", map[string]string{"selector": `{"app":"*"}`}, `{"selector":{"app":"web"}}`, true},
+		{"any array element", map[string]string{"ns": "prod-*"}, `{"ns":["dev","prod-eu"]}`, true},
+		{"no array element", map[string]string{"ns": "prod-*"}, `{"ns":["dev","test"]}`, false},
+		{"every pattern", map[string]string{"ns": "prod-*", "name": "db-*"}, `{"ns":"prod-eu","name":"web"}`, false},
+		{"no arguments", map[string]string{"ns": "*"}, ``, false},
+		{"invalid JSON", map[string]string{"ns": "*"}, `{"ns":`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := argumentsMatch(tt.patterns, json.RawMessage(tt.args)); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestRuleMatches(t *testing.T) {
+	callTool := &mcp.RequestInfo{Method: "tools/call", ToolName: "delete_ns", ToolArguments: json.RawMessage(`{"ns":"prod"}`)}
+	listTools := &mcp.RequestInfo{Method: "tools/list"}
+	readSecret := &mcp.RequestInfo{Method: "resources/read", ResourceURI: "k8s://prod/secrets/db"}
+	tests := []struct {
+		name     string
+		rule     config.PolicyRule
+		upstream string
+		client   string
+		reqInfo  *mcp.RequestInfo
+		want     bool
+	}{
+		{"empty rule", config.PolicyRule{}, "k8s", "", listTools, true},
+		{"upstream", config.PolicyRule{Upstreams: []string{"k8s"}}, "k8s", "", listTools, true},
+		{"other upstream", config.PolicyRule{Upstreams: []string{"k8s"}}, "git", "", listTools, false},
+		{"method glob", config.PolicyRule{Methods: []string{"tools/*"}}, "k8s", "", listTools, true},
+		{"method glob stops at /", config.PolicyRule{Methods: []string{"*"}}, "k8s", "", listTools, false},
+		{"tool", config.PolicyRule{Tools: []string{"delete_*"}}, "k8s", "", callTool, true},
+		{"tool on a non-call", config.PolicyRule{Tools: []string{"*"}}, "k8s", "", listTools, false},
+		{"resource", config.PolicyRule{Resources: []string{"k8s://*/secrets/*"}}, "k8s", "", readSecret, true},
+		{"resource on a call", config.PolicyRule{Resources: []string{"*"}}, "k8s", "", callTool, false},
+		{"client", config.PolicyRule{Clients: []string{"sre-*"}}, "k8s", "sre-alice", listTools, true},
+		{"client without identity", config.PolicyRule{Clients: []string{"*"}}, "k8s", "", listTools, false},
+		{"arguments", config.PolicyRule{Arguments: map[string]string{"ns": "prod"}}, "k8s", "", callTool, true},
+		{"arguments on a non-call", config.PolicyRule{Arguments: map[string]string{"ns": "*"}}, "k8s", "", listTools, false},
+		{"every field", config.PolicyRule{Tools: []string{"delete_*"}, Clients: []string{"sre-*"}}, "k8s", "dev-bob", callTool, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ruleMatches(&tt.rule, tt.upstream, tt.client, tt.reqInfo); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEnforcePolicy_Batch(t *testing.T) {
+	const policy = `
+policy:
+  approvers:
+    alice: alice-token-0123456789
+  rules:
+    - name: no-deletes
+      action: deny
+      tools: ["delete_*"]
+    - name: prod-scale
+      action: require-approval
+      tools: [scale]
+    - name: quiet
+      action: deny
+      methods: ["notifications/cancelled"]
+`
+	getPods := `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"get_pods"}}`
+	tests := []struct {
+		name, body string
+		// want maps the request IDs answered by the proxy to their
+		// error codes; nil when the batch should be forwarded
+		want       map[string]int
+		wantStatus int
+	}{
+		{"allowed", `[` + getPods + `,{"jsonrpc":"2.0","method":"notifications/initialized"}]`, nil, http.StatusOK},
+		{"denied", `[` + getPods + `,{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"delete_ns"}},{"jsonrpc":"2.0","method":"notifications/initialized"}]`,
+			map[string]int{"1": policyDeniedCode, "2": policyDeniedCode}, http.StatusOK},
+		{"held", `[` + getPods + `,{"jsonrpc":"2.0","id":"s","method":"tools/call","params":{"name":"scale"}}]`,
+			map[string]int{"1": policyDeniedCode, `"s"`: approvalRequiredCode}, http.StatusOK},
+		{"only notifications", `[{"jsonrpc":"2.0","method":"notifications/cancelled"}]`, map[string]int{}, http.StatusAccepted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up, forwarded := countingUpstream(t)
+			h := newTestHandler(t, "upstreams:\n  - name: up\n    url: "+up.URL+"\n"+policy)
+
+			req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if tt.want == nil {
+				if forwarded.Load() != 1 {
+					t.Errorf("expected the batch to be forwarded, got %d: %s", rec.Code, rec.Body)
+				}
+				return
+			}
+			if forwarded.Load() != 0 {
+				t.Error("expected no part of the batch to reach the upstream")
+			}
+			if rec.Code != tt.wantStatus {
+				t.Errorf("expected status %d, got %d", tt.wantStatus, rec.Code)
+			}
+			if len(tt.want) == 0 {
+				if rec.Body.Len() != 0 {
+					t.Errorf("expected no body, got %s", rec.Body)
+				}
+				return
+			}
+			var resps []jsonrpc.Response
+			if err := json.Unmarshal(rec.Body.Bytes(), &resps); err != nil {
+				t.Fatalf("expected a batch of errors, got %s", rec.Body)
+			}
+			got := make(map[string]int, len(resps))
+			for _, resp := range resps {
+				if resp.Error != nil {
+					got[string(resp.ID)] = resp.Error.Code
+				}
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("expected errors %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFilterList(t *testing.T) {
+	h := newTestHandler(t, `
+upstreams:
+  - name: up
+    url: http://127.0.0.1:1
+policy:
+  approvers:
+    alice: alice-token-0123456789
+  rules:
+    - name: no-deletes
+      action: deny
+      tools: ["delete_*"]
+    - name: prod-scale
+      action: require-approval
+      tools: [scale]
+    - name: no-secrets
+      action: deny
+      resources: ["k8s://*/secrets/*"]
+    - name: no-prompts-for-bots
+      action: deny
+      methods: [prompts/get]
+      clients: ["bot-*"]
+`)
+	up := &upstream{name: "up"}
+	tests := []struct {
+		name, method, client, body, want string
+		noPolicy                         bool
+	}{
+		{"tools", "tools/list", "", `{"jsonrpc":"2.0","id":1,"result":{"tools":[{"name":"get_pods"},{"name":"delete_ns"},{"name":"scale"}],"nextCursor":"c"}}`,
+			`{"jsonrpc":"2.0","result":{"nextCursor":"c","tools":[{"name":"get_pods"},{"name":"scale"}]},"id":1}`, false},
+		{"resources", "resources/list", "", `{"jsonrpc":"2.0","id":1,"result":{"resources":[{"uri":"k8s://prod/secrets/db"},{"uri":"k8s://prod/pods/web"}]}}`,
+			`{"jsonrpc":"2.0","result":{"resources":[{"uri":"k8s://prod/pods/web"}]},"id":1}`, false},
+		{"prompts for a denied client", "prompts/list", "bot-1", `{"jsonrpc":"2.0","id":1,"result":{"prompts":[{"name":"triage"}]}}`,
+			`{"jsonrpc":"2.0","result":{"prompts":[]},"id":1}`, false},
+		{"prompts for another client", "prompts/list", "sre-1", `{"jsonrpc":"2.0","id":1,"result":{"prompts":[{"name":"triage"}]}}`,
+			`{"jsonrpc":"2.0","id":1,"result":{"prompts":[{"name":"triage"}]}}`, false},
+		{"nothing hidden", "tools/list", "", `{"jsonrpc":"2.0","id":1,"result":{"tools":[{"name":"get_pods"}]}}`,
+			`{"jsonrpc":"2.0","id":1,"result":{"tools":[{"name":"get_pods"}]}}`, false},
+		{"error", "tools/list", "", `{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"boom"}}`,
+			`{"jsonrpc":"2.0","id":1,"error":{"code":-32603,"message":"boom"}}`, false},
+		{"not a list", "tools/call", "", `{"jsonrpc":"2.0","id":1,"result":{"tools":[{"name":"delete_ns"}]}}`,
+			`{"jsonrpc":"2.0","id":1,"result":{"tools":[{"name":"delete_ns"}]}}`, false},
+		{"no policy context", "tools/list", "", `{"jsonrpc":"2.0","id":1,"result":{"tools":[{"name":"delete_ns"}]}}`,
+			`{"jsonrpc":"2.0","id":1,"result":{"tools":[{"name":"delete_ns"}]}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if !tt.noPolicy {
+				ctx = context.WithValue(ctx, policyContextKey{}, &policyContext{client: tt.client})
+			}
+			got := h.filterList(ctx, trace.SpanFromContext(ctx), up, tt.method, []byte(tt.body))
+			if string(got) != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package proxy
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log/slog"
@@ -10,35 +11,48 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
+	"github.com/isitobservable/mcp-otel-proxy/internal/telemetry"
 )
 
+// reinitializer re-establishes upstream sessions that died under a client,
+// replaying each session's own initialize handshake.
 type reinitializer struct {
-	mu              sync.Mutex
-	lastInitReqBody []byte
-	lastSessionID   string
-	upstream        string
-	client          *http.Client
-	logger          *slog.Logger
-	reinitInFlight  bool
+	upstream string
+	client   *http.Client
+	headers  map[string]string
+	sessions *mcp.SessionStore
+	logger   *slog.Logger
+
+	mu       sync.Mutex
+	inflight map[string]*reinitCall
 }
 
-func newReinitializer(upstream string, client *http.Client, logger *slog.Logger) *reinitializer {
+// reinitCall is one reinit in progress. Requests for the same session that
+// fail while it runs wait for it instead of starting their own.
+type reinitCall struct {
+	done       chan struct{}
+	upstreamID string
+	err        error
+}
+
+func newReinitializer(upstream string, client *http.Client, headers map[string]string, sessions *mcp.SessionStore, logger *slog.Logger) *reinitializer {
 	return &reinitializer{
 		upstream: upstream,
 		client:   client,
+		headers:  headers,
+		sessions: sessions,
 		logger:   logger,
+		inflight: make(map[string]*reinitCall),
 	}
 }
 
-func (r *reinitializer) cacheInitRequest(body []byte, sessionID string) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	r.lastInitReqBody = make([]byte, len(body))
-	copy(r.lastInitReqBody, body)
-	r.lastSessionID = sessionID
-	r.logger.Info("cached initialize request for reinit", "session-id", sessionID)
-}
-
 func shouldReinit(statusCode int, method string) bool {
 	if method == "initialize" || method == "notifications/initialized" {
 		return false
@@ -46,60 +60,122 @@ func shouldReinit(statusCode int, method string) bool {
 	return statusCode == 400 || statusCode == 404 || statusCode == 502
 }
 
-func (r *reinitializer) reinitAndRetry(originalReq *http.Request, originalBody []byte, path string) ([]byte, http.Header, int, error) {
+// reinitAndRetry re-establishes the upstream session behind sessionID,
+// which the original request found dead as failedID, and retries the
+// request on it.
+func (r *reinitializer) reinitAndRetry(ctx context.Context, sessionID, failedID string, originalBody []byte, path string) ([]byte, http.Header, int, error) {
+	upstreamID, err := r.reinit(ctx, sessionID, failedID, path)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	r.logger.Info("retrying original request after reinit", "session-id", sessionID, "upstream-session-id", upstreamID)
+	return r.doRawRequest(telemetry.WithResendCount(ctx, 1), "POST", path, originalBody, upstreamID)
+}
+
+// reinit replays the session's initialize handshake, coalescing concurrent
+// calls for the same session. Different sessions reinit in parallel. A
+// request that failed on an upstream session an earlier reinit has already
+// replaced gets the current one without another replay, which would throw
+// that session away.
+func (r *reinitializer) reinit(ctx context.Context, sessionID, failedID, path string) (string, error) {
+	if sessionID == "" {
+		return "", fmt.Errorf("no session to reinitialize")
+	}
+
 	r.mu.Lock()
-	if r.reinitInFlight {
+	if c, ok := r.inflight[sessionID]; ok {
 		r.mu.Unlock()
-		return nil, nil, 0, fmt.Errorf("reinit already in flight")
+		<-c.done
+		return c.upstreamID, c.err
 	}
-	if r.lastInitReqBody == nil {
+	if current := r.current(sessionID); current != failedID {
 		r.mu.Unlock()
-		return nil, nil, 0, fmt.Errorf("no cached initialize request to replay")
+		r.logger.Info("upstream session already replaced, skipping reinit",
+			"session-id", sessionID, "failed-upstream-session-id", failedID, "upstream-session-id", current)
+		return current, nil
 	}
-	r.reinitInFlight = true
-	initBody := make([]byte, len(r.lastInitReqBody))
-	copy(initBody, r.lastInitReqBody)
+	c := &reinitCall{done: make(chan struct{})}
+	r.inflight[sessionID] = c
 	r.mu.Unlock()
 
+	c.upstreamID, c.err = r.replay(ctx, sessionID, path)
+
+	r.mu.Lock()
+	delete(r.inflight, sessionID)
+	r.mu.Unlock()
+	close(c.done)
+	return c.upstreamID, c.err
+}
+
+// current returns the upstream session ID requests for sessionID are sent
+// with: the mapped one, or the client's own if there is none.
+func (r *reinitializer) current(sessionID string) string {
+	if id, _ := r.sessions.Upstream(sessionID); id != "" {
+		return id
+	}
+	return sessionID
+}
+
+// replay establishes a new upstream session for sessionID. Its span links
+// to the span that established the previous upstream session.
+func (r *reinitializer) replay(ctx context.Context, sessionID, path string) (upstreamID string, err error) {
+	prevID, prevSpan := r.sessions.Upstream(sessionID)
+	opts := []trace.SpanStartOption{
+		trace.WithAttributes(
+			attribute.String("mcp.session.id", sessionID),
+			attribute.String("mcp.proxy.upstream.session.previous_id", prevID),
+		),
+	}
+	if prevSpan.IsValid() {
+		opts = append(opts, trace.WithLinks(trace.Link{
+			SpanContext: prevSpan,
+			Attributes:  []attribute.KeyValue{attribute.String("mcp.proxy.link.type", "previous_upstream_session")},
+		}))
+	}
+	ctx, span := otel.Tracer("mcp-otel-proxy").Start(ctx, "mcp.session.reinit", opts...)
 	defer func() {
-		r.mu.Lock()
-		r.reinitInFlight = false
-		r.mu.Unlock()
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
 	}()
 
-	r.logger.Info("starting reinit sequence")
+	initBody := r.sessions.InitRequest(sessionID)
+	if initBody == nil {
+		return "", fmt.Errorf("no cached initialize request to replay for session %s", sessionID)
+	}
+
+	r.logger.Info("starting reinit sequence", "session-id", sessionID)
 
-	initResp, initHeaders, initStatus, err := r.doRawRequest("POST", path, initBody, "")
+	_, initHeaders, initStatus, err := r.doRawRequest(ctx, "POST", path, initBody, "")
 	if err != nil {
-		return nil, nil, 0, fmt.Errorf("reinit initialize failed: %w", err)
+		return "", fmt.Errorf("reinit initialize failed: %w", err)
 	}
 	if initStatus != 200 {
-		return nil, nil, 0, fmt.Errorf("reinit initialize returned status %d", initStatus)
+		return "", fmt.Errorf("reinit initialize returned status %d", initStatus)
 	}
 
-	_ = initResp
 	newSessionID := initHeaders.Get("Mcp-Session-Id")
-	r.logger.Info("reinit initialize succeeded", "new-session-id", newSessionID)
-
-	r.mu.Lock()
-	r.lastSessionID = newSessionID
-	r.mu.Unlock()
+	r.logger.Info("reinit initialize succeeded", "session-id", sessionID, "new-upstream-session-id", newSessionID)
 
 	notifBody := []byte(`{"jsonrpc":"2.0","method":"notifications/initialized"}`)
-	_, _, _, notifErr := r.doRawRequest("POST", path, notifBody, newSessionID)
+	_, _, _, notifErr := r.doRawRequest(ctx, "POST", path, notifBody, newSessionID)
 	if notifErr != nil {
 		r.logger.Warn("reinit notifications/initialized failed", "error", notifErr)
 	}
 
 	time.Sleep(100 * time.Millisecond)
 
-	r.logger.Info("retrying original request after reinit", "session-id", newSessionID)
-	return r.doRawRequest("POST", path, originalBody, newSessionID)
+	span.SetAttributes(attribute.String("mcp.proxy.upstream.session.id", newSessionID))
+	r.sessions.SetUpstream(sessionID, newSessionID, span.SpanContext())
+	return newSessionID, nil
 }
 
-func (r *reinitializer) doRawRequest(method, path string, body []byte, sessionID string) ([]byte, http.Header, int, error) {
+// doRawRequest sends a request of the reinit sequence. ctx parents its span;
+// its cancellation is ignored, since waiting requests share the reinit.
+func (r *reinitializer) doRawRequest(ctx context.Context, method, path string, body []byte, sessionID string) ([]byte, http.Header, int, error) {
 	reqURL := r.upstream + path
-	req, err := http.NewRequest(method, reqURL, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(context.WithoutCancel(ctx), method, reqURL, bytes.NewReader(body))
 	if err != nil {
 		return nil, nil, 0, err
 	}
@@ -109,6 +185,9 @@ func (r *reinitializer) doRawRequest(method, path string, body []byte, sessionID
 	if sessionID != "" {
 		req.Header.Set("Mcp-Session-Id", sessionID)
 	}
+	for k, v := range r.headers {
+		req.Header.Set(k, v)
+	}
 
 	resp, err := r.client.Do(req)
 	if err != nil {
@@ -127,10 +206,3 @@ func (r *reinitializer) doRawRequest(method, path string, body []byte, sessionID
 
 	return respBody, resp.Header, resp.StatusCode, nil
 }
-
-func (r *reinitializer) getSessionID() string {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	return r.lastSessionID
-}
-
@@ -0,0 +1,304 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/jsonrpc"
+	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
+)
+
+const initializeBody = `{"jsonrpc":"2.0","id":0,"method":"initialize","params":{"protocolVersion":"2025-06-18","capabilities":{},"clientInfo":{"name":"test","version":"1"}}}`
+
+// reinitUpstream is an MCP server whose sessions can be killed. initialize
+// issues the sessions up-1, up-2, ... and other requests on a dead session
+// get 404. Results name the session that served them.
+type reinitUpstream struct {
+	*httptest.Server
+	inits atomic.Int32
+	// onInit, if set, runs before initialize is answered.
+	onInit func()
+	// hold, if set, delays the 404 of a "slow" request until it is closed.
+	hold chan struct{}
+
+	mu   sync.Mutex
+	dead map[string]bool
+}
+
+func newReinitUpstream(t *testing.T) *reinitUpstream {
+	t.Helper()
+	u := &reinitUpstream{dead: make(map[string]bool)}
+	u.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		_ = json.Unmarshal(body, &req)
+		sessionID := r.Header.Get("Mcp-Session-Id")
+		switch {
+		case req.Method == "initialize":
+			n := u.inits.Add(1)
+			if u.onInit != nil {
+				u.onInit()
+			}
+			w.Header().Set("Mcp-Session-Id", fmt.Sprintf("up-%d", n))
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":{"protocolVersion":"2025-06-18"}}`, req.ID)
+		case req.Method == "notifications/initialized":
+			w.WriteHeader(http.StatusAccepted)
+		case u.isDead(sessionID):
+			if req.Method == "slow" && u.hold != nil {
+				<-u.hold
+			}
+			http.Error(w, "session not found", http.StatusNotFound)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":{"session":%q}}`, req.ID, sessionID)
+		}
+	}))
+	t.Cleanup(u.Close)
+	return u
+}
+
+func (u *reinitUpstream) kill(sessionID string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.dead[sessionID] = true
+}
+
+func (u *reinitUpstream) isDead(sessionID string) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.dead[sessionID]
+}
+
+// newTestReinitializer returns a reinitializer for up with the client
+// sessions given, each mapped to the dead upstream session "<id>-up".
+func newTestReinitializer(t *testing.T, up *reinitUpstream, sessionIDs ...string) (*reinitializer, *mcp.SessionStore) {
+	t.Helper()
+	sessions := mcp.NewSessionStore(time.Hour, nil, nil)
+	for _, id := range sessionIDs {
+		sessions.TrackInitialize(&jsonrpc.Response{}, id, []byte(initializeBody))
+		sessions.SetUpstream(id, id+"-up", trace.SpanContext{})
+		up.kill(id + "-up")
+	}
+	return newReinitializer(up.URL, up.Client(), nil, sessions, slog.New(slog.DiscardHandler)), sessions
+}
+
+// retry stands for a request that failed on failedID and is retried.
+func retry(t *testing.T, r *reinitializer, sessionID, failedID string) string {
+	t.Helper()
+	body, _, status, err := r.reinitAndRetry(context.Background(), sessionID, failedID, []byte(`{"jsonrpc":"2.0","id":1,"method":"ping"}`), "/mcp")
+	if err != nil || status != http.StatusOK {
+		t.Errorf("expected the retry to succeed, got %d, %v", status, err)
+		return ""
+	}
+	var resp struct {
+		Result struct {
+			Session string `json:"session"`
+		} `json:"result"`
+	}
+	_ = json.Unmarshal(body, &resp)
+	return resp.Result.Session
+}
+
+func TestReinit_ConcurrentFailuresShareOneReinit(t *testing.T) {
+	up := newReinitUpstream(t)
+	r, sessions := newTestReinitializer(t, up, "c1")
+
+	var wg sync.WaitGroup
+	served := make([]string, 5)
+	for i := range served {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			served[i] = retry(t, r, "c1", "c1-up")
+		}()
+	}
+	wg.Wait()
+
+	if n := up.inits.Load(); n != 1 {
+		t.Errorf("expected one reinit, got %d", n)
+	}
+	for _, s := range served {
+		if s != "up-1" {
+			t.Errorf("expected every retry on up-1, got %v", served)
+			break
+		}
+	}
+	if id, _ := sessions.Upstream("c1"); id != "up-1" {
+		t.Errorf("expected c1 mapped to up-1, got %s", id)
+	}
+}
+
+func TestReinit_LateFailureKeepsTheNewSession(t *testing.T) {
+	up := newReinitUpstream(t)
+	r, sessions := newTestReinitializer(t, up, "c1")
+
+	if s := retry(t, r, "c1", "c1-up"); s != "up-1" {
+		t.Fatalf("expected the first retry on up-1, got %s", s)
+	}
+	// A request sent on the old session before the reinit finished
+	if s := retry(t, r, "c1", "c1-up"); s != "up-1" {
+		t.Errorf("expected the late retry on up-1, got %s", s)
+	}
+	if n := up.inits.Load(); n != 1 {
+		t.Errorf("expected the late failure not to reinit again, got %d reinits", n)
+	}
+
+	// Once the new session dies too, it is replaced
+	up.kill("up-1")
+	if s := retry(t, r, "c1", "up-1"); s != "up-2" {
+		t.Errorf("expected a retry on up-2, got %s", s)
+	}
+	if id, _ := sessions.Upstream("c1"); id != "up-2" {
+		t.Errorf("expected c1 mapped to up-2, got %s", id)
+	}
+}
+
+func TestReinit_SessionsReinitInParallel(t *testing.T) {
+	up := newReinitUpstream(t)
+	r, _ := newTestReinitializer(t, up, "c1", "c2")
+
+	// Each initialize waits for the other to arrive
+	var arrived atomic.Int32
+	both := make(chan struct{})
+	var overlapped atomic.Bool
+	overlapped.Store(true)
+	up.onInit = func() {
+		if arrived.Add(1) == 2 {
+			close(both)
+		}
+		select {
+		case <-both:
+		case <-time.After(2 * time.Second):
+			overlapped.Store(false)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, id := range []string{"c1", "c2"} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			retry(t, r, id, id+"-up")
+		}()
+	}
+	wg.Wait()
+
+	if n := up.inits.Load(); n != 2 {
+		t.Errorf("expected a reinit per session, got %d", n)
+	}
+	if !overlapped.Load() {
+		t.Error("expected the reinits of different sessions to run in parallel")
+	}
+}
+
+func TestReinit_SpanLinksThePreviousSession(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	prevProvider := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(prevProvider) })
+
+	up := newReinitUpstream(t)
+	r, sessions := newTestReinitializer(t, up, "c1")
+	established := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{2},
+		TraceFlags: trace.FlagsSampled,
+	})
+	sessions.SetUpstream("c1", "c1-up", established)
+
+	retry(t, r, "c1", "c1-up")
+	up.kill("up-1")
+	retry(t, r, "c1", "up-1")
+
+	var reinits []sdktrace.ReadOnlySpan
+	for _, s := range recorder.Ended() {
+		if s.Name() == "mcp.session.reinit" {
+			reinits = append(reinits, s)
+		}
+	}
+	if len(reinits) != 2 {
+		t.Fatalf("expected two reinit spans, got %d", len(reinits))
+	}
+	// Each reinit links to the span that established the session it replaced
+	for i, want := range []trace.SpanContext{established, reinits[0].SpanContext()} {
+		links := reinits[i].Links()
+		if len(links) != 1 || !links[0].SpanContext.Equal(want) {
+			t.Errorf("reinit %d: expected a link to %s, got %+v", i, want.SpanID(), links)
+			continue
+		}
+		if attrs := links[0].Attributes; len(attrs) != 1 || attrs[0].Value.AsString() != "previous_upstream_session" {
+			t.Errorf("reinit %d: expected the link type, got %v", i, attrs)
+		}
+	}
+	for i, want := range []string{"c1-up", "up-1"} {
+		for _, kv := range reinits[i].Attributes() {
+			if kv.Key == "mcp.proxy.upstream.session.previous_id" && kv.Value.AsString() != want {
+				t.Errorf("reinit %d: expected previous_id %s, got %s", i, want, kv.Value.AsString())
+			}
+		}
+	}
+	if _, sc := sessions.Upstream("c1"); !sc.Equal(reinits[1].SpanContext()) {
+		t.Error("expected the session to remember the span of the last reinit")
+	}
+}
+
+func TestServeHTTP_ReinitAfterAConcurrentReinit(t *testing.T) {
+	up := newReinitUpstream(t)
+	up.hold = make(chan struct{})
+	h := newTestHandler(t, "upstreams:\n  - name: up\n    url: "+up.URL+"\n")
+	post := func(sessionID, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json, text/event-stream")
+		if sessionID != "" {
+			req.Header.Set("Mcp-Session-Id", sessionID)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	sessionID := post("", initializeBody).Header().Get("Mcp-Session-Id")
+	if sessionID == "" || sessionID == "up-1" {
+		t.Fatalf("expected a proxy session ID, got %q", sessionID)
+	}
+	up.kill("up-1")
+
+	// slow is sent on up-1 but only fails once ping has replaced it
+	slow := make(chan *httptest.ResponseRecorder)
+	go func() { slow <- post(sessionID, `{"jsonrpc":"2.0","id":1,"method":"slow"}`) }()
+	time.Sleep(50 * time.Millisecond)
+	if rec := post(sessionID, `{"jsonrpc":"2.0","id":2,"method":"ping"}`); !strings.Contains(rec.Body.String(), "up-2") {
+		t.Fatalf("expected ping to be retried on up-2, got %d: %s", rec.Code, rec.Body)
+	}
+	close(up.hold)
+
+	if rec := <-slow; !strings.Contains(rec.Body.String(), "up-2") {
+		t.Errorf("expected slow to be retried on up-2, got %d: %s", rec.Code, rec.Body)
+	}
+	if n := up.inits.Load(); n != 2 {
+		t.Errorf("expected the initialize and one reinit, got %d", n)
+	}
+	if rec := post(sessionID, `{"jsonrpc":"2.0","id":3,"method":"ping"}`); !strings.Contains(rec.Body.String(), "up-2") {
+		t.Errorf("expected the session to stay on up-2, got %s", rec.Body)
+	}
+}
@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/telemetry"
+)
+
+// replayBuffer keeps the most recent SSE events of every session so a client
+// whose connection dropped can resume with Last-Event-ID, whether or not the
+// upstream server supports resumability itself.
+type replayBuffer struct {
+	mu        sync.Mutex
+	size      int
+	retention time.Duration
+	sessions  map[string]*sessionEvents
+}
+
+// sessionEvents is the buffer of one session, bounded to size events.
+type sessionEvents struct {
+	events     []*bufferedEvent // oldest first
+	nextStream int
+	touched    time.Time
+}
+
+type bufferedEvent struct {
+	stream *eventStream
+	seq    int
+	id     string
+	raw    []byte
+	at     time.Time
+}
+
+// eventStream is one SSE response: the stream of a POST or a GET. Events are
+// only replayed within the stream they were sent on.
+type eventStream struct {
+	num    int
+	seq    int
+	listen bool
+	done   bool
+	// changed is closed and replaced whenever an event is appended or the
+	// stream finishes, waking resumed clients tailing the stream.
+	changed chan struct{}
+}
+
+func newReplayBuffer(size int, retention time.Duration) *replayBuffer {
+	return &replayBuffer{
+		size:      size,
+		retention: retention,
+		sessions:  make(map[string]*sessionEvents),
+	}
+}
+
+// open starts buffering a new stream of sessionID.
+func (b *replayBuffer) open(sessionID string, listen bool) *eventStream {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sweep()
+	se, ok := b.sessions[sessionID]
+	if !ok {
+		se = &sessionEvents{}
+		b.sessions[sessionID] = se
+	}
+	se.nextStream++
+	se.touched = time.Now()
+	return &eventStream{num: se.nextStream, listen: listen, changed: make(chan struct{})}
+}
+
+// append buffers ev and returns the bytes to send to the client. Events
+// without an id get one so the client can resume from them.
+func (b *replayBuffer) append(sessionID string, st *eventStream, ev *sseEvent) []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	st.seq++
+	raw := ev.raw
+	id := ev.id
+	if id == "" {
+		id = fmt.Sprintf("%d-%d", st.num, st.seq)
+		raw = append([]byte("id: "+id+"\n"), ev.raw...)
+	}
+
+	se, ok := b.sessions[sessionID]
+	if !ok {
+		// Swept while the stream sat idle.
+		se = &sessionEvents{nextStream: st.num}
+		b.sessions[sessionID] = se
+	}
+	now := time.Now()
+	se.touched = now
+	se.events = append(se.events, &bufferedEvent{stream: st, seq: st.seq, id: id, raw: raw, at: now})
+	if over := len(se.events) - b.size; over > 0 {
+		se.events = append(se.events[:0:0], se.events[over:]...)
+	}
+	close(st.changed)
+	st.changed = make(chan struct{})
+	return raw
+}
+
+// finish marks the stream complete.
+func (b *replayBuffer) finish(st *eventStream) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	st.done = true
+	close(st.changed)
+	st.changed = make(chan struct{})
+}
+
+// lookup finds the event a client last received. ok is false when it is no
+// longer, or never was, in the buffer.
+func (b *replayBuffer) lookup(sessionID, lastEventID string) (*eventStream, int, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	se, ok := b.sessions[sessionID]
+	if !ok {
+		return nil, 0, false
+	}
+	for i := len(se.events) - 1; i >= 0; i-- {
+		if ev := se.events[i]; ev.id == lastEventID && time.Since(ev.at) <= b.retention {
+			se.touched = time.Now()
+			return ev.stream, ev.seq, true
+		}
+	}
+	return nil, 0, false
+}
+
+// since returns the buffered events of st after seq, whether st is done, and
+// a channel closed on its next change.
+func (b *replayBuffer) since(sessionID string, st *eventStream, seq int) ([]*bufferedEvent, bool, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []*bufferedEvent
+	if se, ok := b.sessions[sessionID]; ok {
+		for _, ev := range se.events {
+			if ev.stream == st && ev.seq > seq {
+				out = append(out, ev)
+			}
+		}
+	}
+	return out, st.done, st.changed
+}
+
+// drop forgets a session's events.
+func (b *replayBuffer) drop(sessionID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.sessions, sessionID)
+}
+
+// sweep drops expired events and sessions idle for longer than the
+// retention. The caller must hold b.mu.
+func (b *replayBuffer) sweep() {
+	cutoff := time.Now().Add(-b.retention)
+	for id, se := range b.sessions {
+		i := 0
+		for i < len(se.events) && se.events[i].at.Before(cutoff) {
+			i++
+		}
+		se.events = se.events[i:]
+		if len(se.events) == 0 && se.touched.Before(cutoff) {
+			delete(b.sessions, id)
+		}
+	}
+}
+
+// handleResume serves a client reconnecting with Last-Event-ID from the
+// replay buffer: the events it missed, then the rest of the stream as it
+// arrives. A resumed GET stream continues on a fresh upstream stream. When
+// the event is not buffered, the request goes to the upstream, which may
+// support resumption itself.
+func (h *Handler) handleResume(w http.ResponseWriter, r *http.Request, up *upstream) {
+	ctx := r.Context()
+	sessionID := r.Header.Get("Mcp-Session-Id")
+	lastEventID := r.Header.Get("Last-Event-ID")
+	upstreamAttr := telemetry.UpstreamAttr(up.name)
+
+	st, seq, ok := h.replay.lookup(sessionID, lastEventID)
+	if !ok {
+		h.metrics.SSEReplays.Add(ctx, 1, telemetry.ReplayResultAttr("miss"), upstreamAttr)
+		h.logger.InfoContext(ctx, "SSE resume not in proxy buffer, forwarding upstream",
+			"mcp.session.id", sessionID,
+			"last_event_id", lastEventID,
+			"upstream.name", up.name,
+		)
+		h.handleListen(w, r, up)
+		return
+	}
+	h.metrics.SSEReplays.Add(ctx, 1, telemetry.ReplayResultAttr("hit"), upstreamAttr)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Mcp-Session-Id", sessionID)
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	_ = rc.Flush()
+
+	replayed := 0
+	defer func() {
+		h.metrics.SSEReplayEvents.Add(ctx, int64(replayed), upstreamAttr)
+		h.logger.InfoContext(ctx, "SSE stream resumed from proxy buffer",
+			"mcp.session.id", sessionID,
+			"last_event_id", lastEventID,
+			"replayed_events", replayed,
+			"upstream.name", up.name,
+		)
+	}()
+
+	for {
+		events, done, changed := h.replay.since(sessionID, st, seq)
+		for _, ev := range events {
+			if _, err := w.Write(ev.raw); err != nil {
+				return
+			}
+			seq = ev.seq
+			replayed++
+		}
+		_ = rc.Flush()
+		if done {
+			break
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	if !st.listen {
+		return
+	}
+	r = r.Clone(ctx)
+	r.Header.Del("Last-Event-ID")
+	req, err := newUpstreamRequest(ctx, r, up, nil)
+	if err != nil {
+		return
+	}
+	h.toUpstreamSession(req)
+	resp, err := up.streamClient.Do(req)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "upstream stream request failed", "error", err, "mcp.session.id", sessionID)
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK || !isEventStream(resp.Header) {
+		return
+	}
+	h.pipeListen(ctx, w, rc, resp.Body, up, sessionID)
+}
@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/jsonrpc"
+)
+
+// recordMetrics sends metrics to the returned reader for the rest of the
+// test. Call it before creating the handler.
+func recordMetrics(t *testing.T) *sdkmetric.ManualReader {
+	t.Helper()
+	reader := sdkmetric.NewManualReader()
+	prev := otel.GetMeterProvider()
+	otel.SetMeterProvider(sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader)))
+	t.Cleanup(func() { otel.SetMeterProvider(prev) })
+	return reader
+}
+
+// counterSum adds up the points of a counter whose attributes include attrs.
+func counterSum(t *testing.T, reader *sdkmetric.ManualReader, name string, attrs ...attribute.KeyValue) int64 {
+	t.Helper()
+	var rm metricdata.ResourceMetrics
+	if err := reader.Collect(context.Background(), &rm); err != nil {
+		t.Fatal(err)
+	}
+	var total int64
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			sum, ok := m.Data.(metricdata.Sum[int64])
+			if m.Name != name || !ok {
+				continue
+			}
+		points:
+			for _, dp := range sum.DataPoints {
+				for _, kv := range attrs {
+					if v, ok := dp.Attributes.Value(kv.Key); !ok || v != kv.Value {
+						continue points
+					}
+				}
+				total += dp.Value
+			}
+		}
+	}
+	return total
+}
+
+func TestReplayBuffer_EventIDs(t *testing.T) {
+	b := newReplayBuffer(10, time.Minute)
+	first := b.open("s1", false)
+	second := b.open("s1", true)
+	other := b.open("s2", false)
+
+	tests := []struct {
+		name    string
+		session string
+		st      *eventStream
+		ev      *sseEvent
+		wantRaw string
+	}{
+		{"assigned", "s1", first, &sseEvent{raw: []byte("data: a\n\n")}, "id: 1-1\ndata: a\n\n"},
+		{"next in stream", "s1", first, &sseEvent{raw: []byte("data: b\n\n")}, "id: 1-2\ndata: b\n\n"},
+		{"second stream", "s1", second, &sseEvent{raw: []byte("data: c\n\n")}, "id: 2-1\ndata: c\n\n"},
+		{"other session", "s2", other, &sseEvent{raw: []byte("data: d\n\n")}, "id: 1-1\ndata: d\n\n"},
+		{"upstream id kept", "s1", first, &sseEvent{id: "up-7", raw: []byte("id: up-7\ndata: e\n\n")}, "id: up-7\ndata: e\n\n"},
+	}
+	for _, tt := range tests {
+		if got := string(b.append(tt.session, tt.st, tt.ev)); got != tt.wantRaw {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.wantRaw, got)
+		}
+	}
+
+	// Lookups stay within the session and find the stream the event was on
+	if st, seq, ok := b.lookup("s1", "2-1"); !ok || st != second || seq != 1 {
+		t.Errorf("expected 2-1 at seq 1 of the second stream, got %v %d %v", st == second, seq, ok)
+	}
+	if st, seq, ok := b.lookup("s1", "up-7"); !ok || st != first || seq != 3 {
+		t.Errorf("expected up-7 at seq 3 of the first stream, got %v %d %v", st == first, seq, ok)
+	}
+	if events, _, _ := b.since("s1", first, 1); len(events) != 2 {
+		t.Errorf("expected two events after 1-1 in the first stream, got %d", len(events))
+	}
+}
+
+func TestReplayBuffer_Lookup(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int
+		age         time.Duration
+		session, id string
+		wantOK      bool
+	}{
+		{"buffered", 3, 0, "s1", "1-5", true},
+		{"oldest kept", 3, 0, "s1", "1-3", true},
+		{"evicted by the bound", 3, 0, "s1", "1-2", false},
+		{"unknown id", 3, 0, "s1", "9-9", false},
+		{"unknown session", 3, 0, "s2", "1-5", false},
+		{"expired", 10, 2 * time.Minute, "s1", "1-5", false},
+		{"within retention", 10, 30 * time.Second, "s1", "1-5", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newReplayBuffer(tt.size, time.Minute)
+			st := b.open("s1", false)
+			for range 5 {
+				b.append("s1", st, &sseEvent{raw: []byte("data: {}\n\n")})
+			}
+			for _, ev := range b.sessions["s1"].events {
+				ev.at = ev.at.Add(-tt.age)
+			}
+			if _, _, ok := b.lookup(tt.session, tt.id); ok != tt.wantOK {
+				t.Errorf("expected found %v, got %v", tt.wantOK, ok)
+			}
+			if n := len(b.sessions["s1"].events); n > tt.size {
+				t.Errorf("expected at most %d events, got %d", tt.size, n)
+			}
+		})
+	}
+}
+
+func TestReplayBuffer_SweepsIdleSessions(t *testing.T) {
+	b := newReplayBuffer(10, time.Minute)
+	st := b.open("idle", false)
+	b.append("idle", st, &sseEvent{raw: []byte("data: {}\n\n")})
+	b.sessions["idle"].events[0].at = time.Now().Add(-2 * time.Minute)
+	b.sessions["idle"].touched = time.Now().Add(-2 * time.Minute)
+	b.open("active", false)
+
+	if _, ok := b.sessions["idle"]; ok {
+		t.Error("expected the idle session to be swept")
+	}
+	if _, ok := b.sessions["active"]; !ok {
+		t.Error("expected the new session to be kept")
+	}
+}
+
+func TestHandleResume(t *testing.T) {
+	tests := []struct {
+		name        string
+		lastEventID string
+		wantResult  string
+		wantBody    string
+		wantEvents  int64
+	}{
+		{"replays what was missed", "1-2", "hit", "id: 1-3\ndata: c\n\n", 1},
+		{"nothing missed", "1-3", "hit", "", 0},
+		{"evicted", "1-1", "miss", "upstream", 0},
+		{"unknown", "other", "miss", "upstream", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := recordMetrics(t)
+			up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/event-stream")
+				_, _ = w.Write([]byte("event: message\ndata: upstream\n\n"))
+			}))
+			t.Cleanup(up.Close)
+			h := newTestHandler(t, "proxy:\n  sseBuffer:\n    size: 2\nupstreams:\n  - name: up\n    url: "+up.URL+"\n")
+			h.sessions.TrackInitialize(&jsonrpc.Response{}, "s1", nil)
+			st := h.replay.open("s1", false)
+			for _, data := range []string{"a", "b", "c"} {
+				h.replay.append("s1", st, &sseEvent{raw: []byte("data: " + data + "\n\n")})
+			}
+			h.replay.finish(st)
+
+			req := httptest.NewRequest(http.MethodGet, "/mcp", nil)
+			req.Header.Set("Accept", "text/event-stream")
+			req.Header.Set("Mcp-Session-Id", "s1")
+			req.Header.Set("Last-Event-ID", tt.lastEventID)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != http.StatusOK {
+				t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+			}
+			if tt.wantResult == "hit" && rec.Body.String() != tt.wantBody {
+				t.Errorf("expected %q, got %q", tt.wantBody, rec.Body)
+			}
+			if tt.wantResult == "miss" && !strings.Contains(rec.Body.String(), tt.wantBody) {
+				t.Errorf("expected the upstream stream, got %q", rec.Body)
+			}
+			result := attribute.String("mcp.proxy.sse.replay.result", tt.wantResult)
+			if n := counterSum(t, reader, "mcp.proxy.sse.replays", result); n != 1 {
+				t.Errorf("expected one %s, got %d", tt.wantResult, n)
+			}
+			if n := counterSum(t, reader, "mcp.proxy.sse.replayed_events"); n != tt.wantEvents {
+				t.Errorf("expected %d replayed events, got %d", tt.wantEvents, n)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/jsonrpc"
+	"github.com/isitobservable/mcp-otel-proxy/internal/telemetry"
+)
+
+// sseEvent is one Server-Sent Event as read from an upstream stream.
+type sseEvent struct {
+	id    string
+	event string
+	data  []byte
+	// raw holds the event exactly as received, including the blank line
+	// that terminates it, so it can be relayed unchanged.
+	raw []byte
+}
+
+// sseReader splits an SSE stream into events.
+type sseReader struct {
+	r *bufio.Reader
+}
+
+func newSSEReader(r io.Reader) *sseReader {
+	return &sseReader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// next returns the next event, or io.EOF once the stream ends. A trailing
+// event without its terminating blank line is still returned.
+func (s *sseReader) next() (*sseEvent, error) {
+	ev := &sseEvent{}
+	var data [][]byte
+	for {
+		line, err := s.r.ReadBytes('\n')
+		ev.raw = append(ev.raw, line...)
+		trimmed := bytes.TrimRight(line, "\r\n")
+
+		if len(trimmed) == 0 && len(line) > 0 {
+			if len(ev.raw) == len(line) {
+				// Blank line with no preceding fields: skip it.
+				ev.raw = ev.raw[:0]
+				continue
+			}
+			ev.data = bytes.Join(data, []byte{'\n'})
+			return ev, nil
+		}
+
+		if len(trimmed) > 0 {
+			field, value, _ := bytes.Cut(trimmed, []byte(":"))
+			value = bytes.TrimPrefix(value, []byte(" "))
+			switch string(field) {
+			case "data":
+				data = append(data, value)
+			case "id":
+				ev.id = string(value)
+			case "event":
+				ev.event = string(value)
+			}
+		}
+
+		if err != nil {
+			if len(ev.raw) == 0 {
+				return nil, err
+			}
+			ev.data = bytes.Join(data, []byte{'\n'})
+			return ev, nil
+		}
+	}
+}
+
+// withData returns a copy of ev with its data replaced and its other
+// fields and framing kept. data must not contain newlines.
+func (ev *sseEvent) withData(data []byte) *sseEvent {
+	out := &sseEvent{id: ev.id, event: ev.event, data: data}
+	wroteData := false
+	for _, line := range bytes.SplitAfter(ev.raw, []byte("\n")) {
+		trimmed := bytes.TrimRight(line, "\r\n")
+		field, _, _ := bytes.Cut(trimmed, []byte(":"))
+		if string(field) != "data" {
+			out.raw = append(out.raw, line...)
+			continue
+		}
+		if !wroteData {
+			// Keep the line ending the stream uses
+			ending := line[len(trimmed):]
+			if len(ending) == 0 {
+				ending = []byte{'\n'}
+			}
+			out.raw = append(out.raw, "data: "...)
+			out.raw = append(out.raw, data...)
+			out.raw = append(out.raw, ending...)
+			wroteData = true
+		}
+	}
+	return out
+}
+
+// rewriteFunc returns new data for the event carrying the final response,
+// or data itself to leave the event unchanged.
+type rewriteFunc func(final *jsonrpc.Response, data []byte) []byte
+
+// rewriteSSEBody applies rewrite to the event of a buffered SSE body that
+// carries the response to reqID. Other events are kept byte for byte.
+func rewriteSSEBody(body []byte, reqID json.RawMessage, rewrite rewriteFunc) []byte {
+	wantID := jsonrpc.IDString(reqID)
+	reader := newSSEReader(bytes.NewReader(body))
+	var out []byte
+	changed := false
+	for {
+		ev, err := reader.next()
+		if err != nil {
+			break
+		}
+		if final := matchResponse(ev.data, wantID); final != nil {
+			if data := rewrite(final, ev.data); !bytes.Equal(data, ev.data) {
+				ev = ev.withData(data)
+				changed = true
+			}
+		}
+		out = append(out, ev.raw...)
+	}
+	if !changed {
+		return body
+	}
+	return out
+}
+
+// isEventStream reports whether headers describe an SSE response.
+func isEventStream(header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// streamSSE relays upstream SSE events to the client as they arrive. Every
+// data payload is parsed as JSON-RPC: notifications and server requests are
+// recorded as span events, and the stream ends after the response to reqID,
+// which is returned along with the number of bytes written.
+//
+// With st set, events are buffered for resumption and a client that drops
+// does not stop the stream: it is read to the end so the client can pick up
+// the rest with Last-Event-ID.
+//
+// A non-nil rewrite may replace the data of the final response event, as
+// response compression does.
+func (h *Handler) streamSSE(ctx context.Context, w http.ResponseWriter, body io.Reader, span trace.Span, reqID json.RawMessage, sessionID string, st *eventStream, rewrite rewriteFunc) (*jsonrpc.Response, int, error) {
+	flusher, canFlush := w.(http.Flusher)
+	reader := newSSEReader(body)
+	wantID := jsonrpc.IDString(reqID)
+	written := 0
+	clientGone := false
+
+	for {
+		ev, err := reader.next()
+		if err != nil {
+			if err == io.EOF {
+				return nil, written, nil
+			}
+			return nil, written, err
+		}
+
+		final := h.observeEvent(span, ev.data, wantID)
+		if final != nil && rewrite != nil {
+			if data := rewrite(final, ev.data); !bytes.Equal(data, ev.data) {
+				ev = ev.withData(data)
+			}
+		}
+
+		raw := ev.raw
+		if st != nil {
+			raw = h.replay.append(sessionID, st, ev)
+		}
+		if !clientGone {
+			n, err := w.Write(raw)
+			written += n
+			switch {
+			case err != nil && st == nil:
+				return final, written, err
+			case err != nil:
+				clientGone = true
+			case canFlush:
+				flusher.Flush()
+			}
+		}
+		if final != nil {
+			return final, written, nil
+		}
+		if st == nil && ctx.Err() != nil {
+			return nil, written, ctx.Err()
+		}
+	}
+}
+
+// observeEvent inspects one SSE data payload. Messages carrying a method are
+// recorded as span events; a response matching wantID is returned.
+func (h *Handler) observeEvent(span trace.Span, data []byte, wantID string) *jsonrpc.Response {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return nil
+	}
+
+	if msg, err := jsonrpc.ParseRequest(data); err == nil && !msg.IsBatch && msg.Requests[0].Method != "" {
+		telemetry.AddMessageEvent(span, &msg.Requests[0], h.config().CapturePayload)
+		return nil
+	}
+	return matchResponse(data, wantID)
+}
+
+// matchResponse returns the response to wantID in an SSE data payload.
+func matchResponse(data []byte, wantID string) *jsonrpc.Response {
+	parsed, err := jsonrpc.ParseResponse(data)
+	if err != nil {
+		return nil
+	}
+	for i := range parsed.Responses {
+		if wantID != "" && jsonrpc.IDString(parsed.Responses[i].ID) == wantID {
+			return &parsed.Responses[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/jsonrpc"
+)
+
+func TestSSEReader_Next(t *testing.T) {
+	type event struct{ id, event, data, raw string }
+	tests := []struct {
+		name  string
+		input string
+		want  []event
+	}{
+		{"fields", "id: 1\nevent: message\ndata: {}\n\n",
+			[]event{{"1", "message", "{}", "id: 1\nevent: message\ndata: {}\n\n"}}},
+		{"CRLF", "id: 1\r\nevent: message\r\ndata: {}\r\n\r\n",
+			[]event{{"1", "message", "{}", "id: 1\r\nevent: message\r\ndata: {}\r\n\r\n"}}},
+		{"multi-line data", "data: {\"a\":\ndata: 1}\n\n",
+			[]event{{"", "", "{\"a\":\n1}", "data: {\"a\":\ndata: 1}\n\n"}}},
+		{"no space after colon", "data:{}\n\n",
+			[]event{{"", "", "{}", "data:{}\n\n"}}},
+		{"comment in an event", "data: a\n: note\ndata: b\n\n",
+			[]event{{"", "", "a\nb", "data: a\n: note\ndata: b\n\n"}}},
+		{"comment alone", ": keepalive\n\ndata: a\n\n",
+			[]event{{"", "", "", ": keepalive\n\n"}, {"", "", "a", "data: a\n\n"}}},
+		{"blank lines between events", "\n\ndata: a\n\n\n\ndata: b\n\n",
+			[]event{{"", "", "a", "data: a\n\n"}, {"", "", "b", "data: b\n\n"}}},
+		{"final event without a blank line", "data: a\n\ndata: b",
+			[]event{{"", "", "a", "data: a\n\n"}, {"", "", "b", "data: b"}}},
+		{"final event without a newline after data", "id: 7\ndata: b\n",
+			[]event{{"7", "", "b", "id: 7\ndata: b\n"}}},
+		{"empty", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reader := newSSEReader(strings.NewReader(tt.input))
+			var got []event
+			for {
+				ev, err := reader.next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, event{ev.id, ev.event, string(ev.data), string(ev.raw)})
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %q, got %q", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("event %d: expected %q, got %q", i, tt.want[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+// clientWriter is a ResponseWriter whose client goes away on the write
+// numbered failFrom, counting from 1; 0 means never.
+type clientWriter struct {
+	header   http.Header
+	body     bytes.Buffer
+	writes   int
+	failFrom int
+}
+
+func (w *clientWriter) Header() http.Header { return w.header }
+func (w *clientWriter) WriteHeader(int)     {}
+
+func (w *clientWriter) Write(p []byte) (int, error) {
+	w.writes++
+	if w.failFrom > 0 && w.writes >= w.failFrom {
+		return 0, errors.New("client gone")
+	}
+	return w.body.Write(p)
+}
+
+func TestStreamSSE(t *testing.T) {
+	const upstream = "event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\",\"params\":{\"progress\":1}}\n\n" +
+		"event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\",\"params\":{\"progress\":2}}\n\n" +
+		"event: message\ndata: {\"jsonrpc\":\"2.0\",\"id\":1,\"result\":{}}\n\n" +
+		"event: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/after\"}\n\n"
+
+	tests := []struct {
+		name         string
+		resumable    bool
+		failFrom     int
+		cancel       bool
+		wantFinal    bool
+		wantErr      bool
+		wantWritten  int
+		wantBuffered int
+	}{
+		{"relays until the final response", false, 0, false, true, false, 3, 0},
+		{"client gone", false, 2, false, false, true, 1, 0},
+		{"client gone, resumable", true, 2, false, true, false, 1, 3},
+		{"cancelled", false, 0, true, false, true, 1, 0},
+		{"cancelled, resumable", true, 0, true, true, false, 3, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, "upstreams:\n  - name: up\n    url: http://127.0.0.1:1\n")
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if tt.cancel {
+				cancel()
+			}
+			var st *eventStream
+			if tt.resumable {
+				st = h.replay.open("s1", false)
+			}
+			w := &clientWriter{header: http.Header{}, failFrom: tt.failFrom}
+			body := strings.NewReader(upstream)
+
+			final, _, err := h.streamSSE(ctx, w, body, trace.SpanFromContext(ctx), json.RawMessage("1"), "s1", st, nil)
+			if (final != nil) != tt.wantFinal || (err != nil) != tt.wantErr {
+				t.Fatalf("expected final %v and error %v, got %v and %v", tt.wantFinal, tt.wantErr, final, err)
+			}
+			if n := strings.Count(w.body.String(), "event: message"); n != tt.wantWritten {
+				t.Errorf("expected %d events written, got %d", tt.wantWritten, n)
+			}
+			if strings.Contains(w.body.String(), "notifications/after") {
+				t.Error("expected the stream to end with the final response")
+			}
+			if tt.resumable {
+				if events, _, _ := h.replay.since("s1", st, 0); len(events) != tt.wantBuffered {
+					t.Errorf("expected %d buffered events, got %d", tt.wantBuffered, len(events))
+				}
+			}
+		})
+	}
+}
+
+func TestWithData(t *testing.T) {
+	tests := []struct {
+		name, raw, want string
+	}{
+		{"fields kept", "id: 5\nevent: message\ndata: {}\n\n", "id: 5\nevent: message\ndata: new\n\n"},
+		{"multi-line data", "id: 5\ndata: {\ndata: }\nevent: message\n\n", "id: 5\ndata: new\nevent: message\n\n"},
+		{"comments kept", ": note\ndata: {}\nretry: 10\n\n", ": note\ndata: new\nretry: 10\n\n"},
+		{"CRLF framing kept", "id: 5\r\ndata: {}\r\n\r\n", "id: 5\r\ndata: new\r\n\r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ev, err := newSSEReader(strings.NewReader(tt.raw)).next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := ev.withData([]byte("new"))
+			if string(out.raw) != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, out.raw)
+			}
+			if out.id != ev.id || out.event != ev.event || string(out.data) != "new" {
+				t.Errorf("expected id %q, event %q and the new data, got %+v", ev.id, ev.event, out)
+			}
+		})
+	}
+}
+
+func TestRewriteSSEBody(t *testing.T) {
+	const (
+		progress = "id: 1\nevent: message\ndata: {\"jsonrpc\":\"2.0\",\"method\":\"notifications/progress\"}\n\n"
+		comment  = ": keepalive\r\n\r\n"
+		other    = "id: 2\ndata: {\"jsonrpc\":\"2.0\",\"id\":9,\"result\":{}}\n\n"
+		final    = "id: 3\nevent: message\ndata: {\"jsonrpc\":\"2.0\",\ndata: \"id\":1,\"result\":{}}\n\n"
+		replaced = "{\"jsonrpc\":\"2.0\",\"id\":1,\"result\":{\"rewritten\":true}}"
+	)
+	rewrite := func(_ *jsonrpc.Response, _ []byte) []byte { return []byte(replaced) }
+	keep := func(_ *jsonrpc.Response, data []byte) []byte { return data }
+
+	tests := []struct {
+		name    string
+		body    string
+		rewrite rewriteFunc
+		want    string
+	}{
+		{"final rewritten, others byte for byte", progress + comment + other + final,
+			rewrite, progress + comment + other + "id: 3\nevent: message\ndata: " + replaced + "\n\n"},
+		{"final without a blank line", progress + strings.TrimSuffix(final, "\n\n"),
+			rewrite, progress + "id: 3\nevent: message\ndata: " + replaced + "\n"},
+		{"unchanged", progress + comment + final, keep, progress + comment + final},
+		{"no final response", progress + other, rewrite, progress + other},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rewriteSSEBody([]byte(tt.body), json.RawMessage("1"), tt.rewrite); string(got) != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestServeHTTP_RewriteDropsContentLength(t *testing.T) {
+	const list = `{"jsonrpc":"2.0","id":1,"result":{"tools":[{"name":"get_pods"},{"name":"delete_ns"}]}}`
+	tests := []struct {
+		name, contentType, body, accept string
+	}{
+		{"JSON", "application/json", list, "application/json, text/event-stream"},
+		{"SSE buffered", "text/event-stream", "id: 1\nevent: message\ndata: " + list + "\n\n", "application/json"},
+		{"SSE streamed", "text/event-stream", "id: 1\nevent: message\ndata: " + list + "\n\n", "application/json, text/event-stream"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.Header().Set("Content-Length", strconv.Itoa(len(tt.body)))
+				_, _ = io.WriteString(w, tt.body)
+			}))
+			t.Cleanup(up.Close)
+			h := newTestHandler(t, `
+upstreams:
+  - name: up
+    url: `+up.URL+`
+policy:
+  rules:
+    - name: no-deletes
+      action: deny
+      tools: ["delete_*"]
+`)
+			req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`))
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Accept", tt.accept)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if strings.Contains(rec.Body.String(), "delete_ns") || !strings.Contains(rec.Body.String(), "get_pods") {
+				t.Fatalf("expected the list to be filtered, got %s", rec.Body)
+			}
+			if tt.contentType == "text/event-stream" && !strings.HasPrefix(rec.Body.String(), "id: 1\nevent: message\n") {
+				t.Errorf("expected the event fields kept, got %q", rec.Body)
+			}
+			if cl := rec.Header().Get("Content-Length"); cl != "" {
+				t.Errorf("expected the stale Content-Length to be dropped, got %s for %d bytes", cl, rec.Body.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/jsonrpc"
+	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
+	"github.com/isitobservable/mcp-otel-proxy/internal/telemetry"
+)
+
+// handleListen proxies the long-lived GET stream a client opens to receive
+// server-initiated messages. The stream stays open until either side closes
+// it. Each request or notification the server sends gets its own span;
+// request spans end when the client POSTs the matching response.
+func (h *Handler) handleListen(w http.ResponseWriter, r *http.Request, up *upstream) {
+	ctx := r.Context()
+	sessionID := r.Header.Get("Mcp-Session-Id")
+
+	resp, rc, ok := h.openStream(w, r, up, sessionID)
+	if !ok {
+		return
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	h.pipeListen(ctx, w, rc, resp.Body, up, sessionID)
+}
+
+// pipeListen relays a server message stream to the client until either side
+// closes it, buffering events for resumption.
+func (h *Handler) pipeListen(ctx context.Context, w http.ResponseWriter, rc *http.ResponseController, body io.Reader, up *upstream, sessionID string) {
+	h.logger.InfoContext(ctx, "server message stream opened",
+		"mcp.session.id", sessionID,
+		"upstream.name", up.name,
+	)
+	opened := time.Now()
+
+	var st *eventStream
+	if h.replay != nil && sessionID != "" {
+		st = h.replay.open(sessionID, true)
+		defer h.replay.finish(st)
+	}
+
+	reader := newSSEReader(body)
+	for {
+		ev, err := reader.next()
+		if err != nil {
+			if !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				h.logger.ErrorContext(ctx, "upstream stream error", "error", err, "mcp.session.id", sessionID)
+			}
+			break
+		}
+		h.observeServerMessage(ctx, up, sessionID, ev.data)
+		raw := ev.raw
+		if st != nil {
+			raw = h.replay.append(sessionID, st, ev)
+		}
+		if _, err := w.Write(raw); err != nil {
+			break
+		}
+		_ = rc.Flush()
+	}
+
+	h.logger.InfoContext(ctx, "server message stream closed",
+		"mcp.session.id", sessionID,
+		"upstream.name", up.name,
+		"duration_ms", time.Since(opened).Milliseconds(),
+	)
+}
+
+// openStream opens a long-lived upstream GET stream and relays its headers.
+// ok is false when the response has already been written in full, either
+// because the upstream failed or because it did not answer with a stream.
+func (h *Handler) openStream(w http.ResponseWriter, r *http.Request, up *upstream, sessionID string) (*http.Response, *http.ResponseController, bool) {
+	ctx := r.Context()
+	upstreamAttr := telemetry.UpstreamAttr(up.name)
+
+	req, err := newUpstreamRequest(ctx, r, up, nil)
+	if err != nil {
+		http.Error(w, "upstream request failed", http.StatusBadGateway)
+		return nil, nil, false
+	}
+	h.toUpstreamSession(req)
+	resp, err := up.streamClient.Do(req)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "upstream stream request failed",
+			"error", err,
+			"mcp.session.id", sessionID,
+			"upstream.name", up.name,
+			"upstream.url", up.url.String(),
+		)
+		h.metrics.ErrorsTotal.Add(ctx, 1, telemetry.ErrorAttr("upstream_error"), upstreamAttr)
+		http.Error(w, "upstream request failed", http.StatusBadGateway)
+		return nil, nil, false
+	}
+
+	fromUpstreamSession(resp.Header, sessionID)
+	copyHeaders(w.Header(), resp.Header)
+	if resp.StatusCode == http.StatusOK && isEventStream(resp.Header) {
+		// Events are rewritten in transit, so the upstream length is stale.
+		w.Header().Del("Content-Length")
+	}
+	w.WriteHeader(resp.StatusCode)
+
+	// Servers without a GET stream answer 405; relay whatever they sent.
+	if resp.StatusCode != http.StatusOK || !isEventStream(resp.Header) {
+		_, _ = io.Copy(w, resp.Body)
+		_ = resp.Body.Close()
+		return nil, nil, false
+	}
+
+	// The stream outlives the server's WriteTimeout.
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	_ = rc.Flush()
+	return resp, rc, true
+}
+
+// observeServerMessage starts a span for every request or notification in one
+// server-sent payload. Notification spans end immediately; request spans wait
+// in h.pending for the client's response.
+func (h *Handler) observeServerMessage(ctx context.Context, up *upstream, sessionID string, data []byte) {
+	if len(bytes.TrimSpace(data)) == 0 {
+		return
+	}
+	parsed, err := jsonrpc.ParseRequest(data)
+	if err != nil {
+		return
+	}
+
+	var session *mcp.Session
+	if sessionID != "" {
+		session = h.sessions.Get(sessionID)
+	}
+
+	for i := range parsed.Requests {
+		msg := &parsed.Requests[i]
+		if msg.Method == "" {
+			continue
+		}
+		reqInfo := mcp.ExtractRequestInfo(msg)
+
+		// The server may carry its own trace context in params._meta; the
+		// client's GET request is not the cause of the message.
+		msgCtx := telemetry.ExtractContextFromMeta(context.WithoutCancel(ctx), msg.Params, propagation.HeaderCarrier{})
+		_, span := telemetry.StartServerMessageSpan(msgCtx, reqInfo, session, sessionID, up.peer)
+
+		h.logger.DebugContext(ctx, "server-initiated MCP message",
+			"mcp.method.name", reqInfo.Method,
+			"jsonrpc.request.id", reqInfo.RequestID,
+			"mcp.session.id", sessionID,
+			"upstream.name", up.name,
+		)
+
+		if msg.IsNotification() {
+			span.End()
+			continue
+		}
+		h.pending.add(pendingKey(sessionID, fromServer, msg.ID), &pendingCall{
+			span:    span,
+			reqInfo: reqInfo,
+			start:   time.Now(),
+		}, expirePending)
+	}
+}
+
+// handleClientResponses forwards a POST carrying only JSON-RPC responses, the
+// client's answers to server-initiated requests, and ends the matching spans.
+func (h *Handler) handleClientResponses(w http.ResponseWriter, r *http.Request, up *upstream, reqBody []byte) {
+	sessionID := sessionKey(r, up)
+	if parsed, err := jsonrpc.ParseResponse(reqBody); err == nil {
+		for i := range parsed.Responses {
+			resp := &parsed.Responses[i]
+			call := h.pending.take(pendingKey(sessionID, fromServer, resp.ID))
+			if call == nil {
+				continue
+			}
+			respInfo := mcp.ExtractResponseInfo(resp, call.reqInfo.Method)
+			h.logger.DebugContext(r.Context(), "client answered server request",
+				"mcp.method.name", call.reqInfo.Method,
+				"jsonrpc.request.id", jsonrpc.IDString(resp.ID),
+				"mcp.session.id", sessionID,
+				"duration_ms", time.Since(call.start).Milliseconds(),
+			)
+			telemetry.EndMCPSpan(call.span, respInfo)
+		}
+	}
+	h.forwardRaw(w, r, up, reqBody)
+}
+
+// isResponseOnly reports whether every message in a client POST is a
+// JSON-RPC response rather than a request or notification.
+func isResponseOnly(parsed *jsonrpc.ParseResult) bool {
+	for i := range parsed.Requests {
+		if parsed.Requests[i].Method != "" {
+			return false
+		}
+	}
+	return len(parsed.Requests) > 0
+}
+
+// sessionKey returns the session a client message belongs to: the
+// Mcp-Session-Id header, or the endpoint's session query parameter on the
+// legacy HTTP+SSE transport.
+func sessionKey(r *http.Request, up *upstream) string {
+	if up.legacySSE {
+		return legacySessionID(r.URL.Query())
+	}
+	return r.Header.Get("Mcp-Session-Id")
+}
+
+func expirePending(call *pendingCall) {
+	call.span.SetAttributes(attribute.String("error.type", "timeout"))
+	call.span.SetStatus(codes.Error, "no response from client")
+	call.span.End()
+}
@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
+)
+
+// recordSpans sends spans to the returned recorder for the rest of the test.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(prev) })
+	return recorder
+}
+
+// endedSpan returns the ended span with the given name, or nil.
+func endedSpan(recorder *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	for _, s := range recorder.Ended() {
+		if s.Name() == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestObserveServerMessage(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		wantEnded   []string
+		wantPending []json.RawMessage
+	}{
+		{"notification", `{"jsonrpc":"2.0","method":"notifications/tools/list_changed"}`,
+			[]string{"notifications/tools/list_changed"}, nil},
+		{"request", `{"jsonrpc":"2.0","id":5,"method":"sampling/createMessage","params":{}}`,
+			nil, []json.RawMessage{json.RawMessage(`5`)}},
+		{"batch", `[{"jsonrpc":"2.0","id":"a","method":"roots/list"},{"jsonrpc":"2.0","method":"notifications/progress"}]`,
+			[]string{"notifications/progress"}, []json.RawMessage{json.RawMessage(`"a"`)}},
+		{"response", `{"jsonrpc":"2.0","id":1,"result":{}}`, nil, nil},
+		{"not JSON", `keepalive`, nil, nil},
+		{"empty", ``, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := recordSpans(t)
+			h := newTestHandler(t, "upstreams:\n  - name: up\n    url: http://127.0.0.1:1\n")
+			up := h.state.Load().router.upstreams[0]
+
+			h.observeServerMessage(context.Background(), up, "s1", []byte(tt.data))
+
+			ended := recorder.Ended()
+			if len(ended) != len(tt.wantEnded) {
+				t.Fatalf("expected %d ended spans, got %d", len(tt.wantEnded), len(ended))
+			}
+			for i, s := range ended {
+				if s.Name() != tt.wantEnded[i] || s.SpanKind() != trace.SpanKindClient {
+					t.Errorf("expected a CLIENT span %s, got %s %s", tt.wantEnded[i], s.SpanKind(), s.Name())
+				}
+			}
+			if n := len(h.pending.calls); n != len(tt.wantPending) {
+				t.Errorf("expected %d pending requests, got %d", len(tt.wantPending), n)
+			}
+			for _, id := range tt.wantPending {
+				if _, ok := h.pending.calls[pendingKey("s1", fromServer, id)]; !ok {
+					t.Errorf("expected request %s of s1 to be pending", id)
+				}
+			}
+		})
+	}
+}
+
+func TestServeHTTP_ClientResponses(t *testing.T) {
+	recorder := recordSpans(t)
+	upstream := newSessionUpstream(t)
+	h := newTestHandler(t, "upstreams:\n  - name: up\n    url: "+upstream.URL+"\n")
+	up := h.state.Load().router.upstreams[0]
+	sessionID := initializeSession(t, h)
+	upstream.requests()
+
+	ctx := context.Background()
+	h.observeServerMessage(ctx, up, sessionID, []byte(`{"jsonrpc":"2.0","id":5,"method":"sampling/createMessage"}`))
+	h.observeServerMessage(ctx, up, sessionID, []byte(`{"jsonrpc":"2.0","id":6,"method":"elicitation/create"}`))
+	h.observeServerMessage(ctx, up, sessionID, []byte(`{"jsonrpc":"2.0","id":7,"method":"roots/list"}`))
+	h.observeServerMessage(ctx, up, "other", []byte(`{"jsonrpc":"2.0","id":5,"method":"ping"}`))
+	clientCall := &pendingCall{span: trace.SpanFromContext(ctx), reqInfo: &mcp.RequestInfo{Method: "tools/call"}}
+	h.pending.add(pendingKey(sessionID, fromClient, json.RawMessage(`5`)), clientCall, expirePending)
+
+	rec := send(h, http.MethodPost, sessionID, `[
+		{"jsonrpc":"2.0","id":5,"result":{"content":{"type":"text","text":"hi"}}},
+		{"jsonrpc":"2.0","id":6,"error":{"code":-1,"message":"declined"}},
+		{"jsonrpc":"2.0","id":99,"result":{}}
+	]`)
+	if rec.Code != http.StatusAccepted {
+		t.Fatalf("expected the upstream's 202, got %d: %s", rec.Code, rec.Body)
+	}
+	if got := upstream.requests(); len(got) != 1 || got[0] != "POST up-1" {
+		t.Errorf("expected the responses forwarded on up-1, got %v", got)
+	}
+
+	if s := endedSpan(recorder, "sampling/createMessage"); s == nil || s.Status().Code == codes.Error {
+		t.Errorf("expected the answered request to end without error, got %v", s)
+	}
+	if s := endedSpan(recorder, "elicitation/create"); s == nil || s.Status().Code != codes.Error {
+		t.Errorf("expected the declined request to end with an error, got %v", s)
+	}
+	for _, key := range []string{
+		pendingKey(sessionID, fromServer, json.RawMessage(`7`)),
+		pendingKey(sessionID, fromClient, json.RawMessage(`5`)),
+		pendingKey("other", fromServer, json.RawMessage(`5`)),
+	} {
+		if _, ok := h.pending.calls[key]; !ok {
+			t.Errorf("expected %s to stay pending", key)
+		}
+	}
+	if n := len(h.pending.calls); n != 3 {
+		t.Errorf("expected three pending requests, got %d", n)
+	}
+}
+
+func TestPendingCalls_Expiry(t *testing.T) {
+	recorder := recordSpans(t)
+	tracer := otel.Tracer("test")
+	p := newPendingCalls()
+	p.timeout = 10 * time.Millisecond
+
+	_, expiring := tracer.Start(context.Background(), "expiring")
+	_, answered := tracer.Start(context.Background(), "answered")
+	p.add(pendingKey("s1", fromServer, json.RawMessage(`1`)), &pendingCall{span: expiring}, expirePending)
+	p.add(pendingKey("s1", fromServer, json.RawMessage(`2`)), &pendingCall{span: answered}, expirePending)
+	if p.take(pendingKey("s1", fromServer, json.RawMessage(`2`))) == nil {
+		t.Fatal("expected the answered request to be pending")
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	if p.take(pendingKey("s1", fromServer, json.RawMessage(`1`))) != nil {
+		t.Error("expected the expired request to be gone")
+	}
+	s := endedSpan(recorder, "expiring")
+	if s == nil || s.Status().Code != codes.Error {
+		t.Fatalf("expected the expired span to end with an error, got %v", s)
+	}
+	errorType := ""
+	for _, kv := range s.Attributes() {
+		if kv.Key == "error.type" {
+			errorType = kv.Value.AsString()
+		}
+	}
+	if errorType != "timeout" {
+		t.Errorf("expected error.type timeout, got %q", errorType)
+	}
+	if endedSpan(recorder, "answered") != nil {
+		t.Error("expected the answered request not to expire")
+	}
+}
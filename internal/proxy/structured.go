@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/jsonrpc"
+	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
+	"github.com/isitobservable/mcp-otel-proxy/internal/schema"
+	"github.com/isitobservable/mcp-otel-proxy/internal/telemetry"
+)
+
+// maxReportedViolations bounds the schema violations recorded on a span.
+const maxReportedViolations = 10
+
+// cacheTools records the tools in a tools/list response on the session, so
+// later calls can be checked against their schemas.
+func (h *Handler) cacheTools(ctx context.Context, session *mcp.Session, req *jsonrpc.Request, resp *jsonrpc.Response) {
+	tools, problems := mcp.ParseTools(resp.Result)
+	for _, p := range problems {
+		h.logger.WarnContext(ctx, "ignoring tool schema", "problem", p, "mcp.session.id", session.ID)
+	}
+	var params struct {
+		Cursor string `json:"cursor"`
+	}
+	_ = json.Unmarshal(req.Params, &params)
+	session.SetTools(tools, params.Cursor == "")
+}
+
+// promoteStructuredContent sets the structuredContent of a tool result that
+// has none to the first text block holding a JSON object. The text blocks
+// stay as they are for clients that only read content.
+func promoteStructuredContent(span trace.Span, respBody []byte) []byte {
+	var resp jsonrpc.Response
+	if err := json.Unmarshal(respBody, &resp); err != nil || len(resp.Result) == 0 {
+		return respBody
+	}
+	var result map[string]json.RawMessage
+	if err := json.Unmarshal(resp.Result, &result); err != nil {
+		return respBody
+	}
+	if _, ok := result["structuredContent"]; ok {
+		return respBody
+	}
+	var content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(result["content"], &content); err != nil {
+		return respBody
+	}
+
+	for _, block := range content {
+		text := strings.TrimSpace(block.Text)
+		if block.Type != "text" || !strings.HasPrefix(text, "{") {
+			continue
+		}
+		var obj map[string]json.RawMessage
+		if json.Unmarshal([]byte(text), &obj) != nil {
+			continue
+		}
+		result["structuredContent"] = json.RawMessage(text)
+		newResult, err := json.Marshal(result)
+		if err != nil {
+			return respBody
+		}
+		resp.Result = newResult
+		newRespBody, err := json.Marshal(resp)
+		if err != nil {
+			return respBody
+		}
+		span.SetAttributes(attribute.Bool("mcp.response.structured_content.promoted", true))
+		return newRespBody
+	}
+	return respBody
+}
+
+// validateOutput checks the structuredContent of a tool result against the
+// outputSchema the session's tools/list declared for the tool. Violations
+// are recorded on the span and in the error metric; the result is passed
+// on unchanged either way.
+func (h *Handler) validateOutput(ctx context.Context, span trace.Span, up *upstream, session *mcp.Session, tool string, respBody []byte) {
+	if session == nil {
+		return
+	}
+	def := session.Tool(tool)
+	if def == nil || def.OutputSchema == nil {
+		return
+	}
+	var resp struct {
+		Result struct {
+			StructuredContent json.RawMessage `json:"structuredContent"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(respBody, &resp); err != nil {
+		return
+	}
+
+	var violations []schema.Violation
+	if structured := resp.Result.StructuredContent; len(structured) == 0 || string(structured) == "null" {
+		violations = []schema.Violation{{Path: "$", Message: "structuredContent is missing, but the tool declares an outputSchema"}}
+	} else if violations, _ = def.OutputSchema.ValidateJSON(structured); len(violations) == 0 {
+		span.SetAttributes(attribute.Bool("mcp.response.output_schema.valid", true))
+		return
+	}
+
+	reported := reportedViolations(violations)
+	span.SetAttributes(
+		attribute.Bool("mcp.response.output_schema.valid", false),
+		attribute.Int("mcp.response.output_schema.violation_count", len(violations)),
+		attribute.StringSlice("mcp.response.output_schema.violations", reported),
+		attribute.String("error.type", "output_schema_violation"),
+	)
+	span.SetStatus(codes.Error, "output schema violation")
+	h.metrics.ErrorsTotal.Add(ctx, 1, telemetry.ErrorAttr("output_schema_violation"), telemetry.UpstreamAttr(up.name))
+	h.logger.WarnContext(ctx, "tool result violates its output schema",
+		"gen_ai.tool.name", tool,
+		"violations", reported,
+		"upstream.name", up.name,
+	)
+}
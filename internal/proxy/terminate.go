@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
+	"github.com/isitobservable/mcp-otel-proxy/internal/telemetry"
+)
+
+// handleTerminate forwards a client's DELETE, which ends its session, and
+// drops everything the proxy holds for the session once the upstream
+// accepts it.
+func (h *Handler) handleTerminate(w http.ResponseWriter, r *http.Request, up *upstream, start time.Time) {
+	ctx := r.Context()
+	sessionID := r.Header.Get("Mcp-Session-Id")
+	if sessionID == "" {
+		http.Error(w, "missing Mcp-Session-Id header", http.StatusBadRequest)
+		return
+	}
+	session := h.sessions.Get(sessionID)
+
+	reqInfo := &mcp.RequestInfo{Method: "session/terminate"}
+	ctx = telemetry.ExtractContextFromMeta(ctx, nil, propagation.HeaderCarrier(r.Header))
+	ctx, span := telemetry.StartMCPSpan(ctx, reqInfo, session, up.peer)
+	defer span.End()
+	if session == nil {
+		span.SetAttributes(attribute.String("mcp.session.id", sessionID))
+	}
+
+	upstreamAttr := telemetry.UpstreamAttr(up.name)
+	h.metrics.RequestCount.Add(ctx, 1, telemetry.MethodAttr(reqInfo.Method), upstreamAttr)
+
+	respBody, respHeaders, statusCode, err := h.doUpstreamRequest(ctx, r, up, nil)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "upstream session termination failed",
+			"error", err,
+			"mcp.session.id", sessionID,
+			"upstream.name", up.name,
+			"upstream.url", up.url.String(),
+		)
+		h.metrics.ErrorsTotal.Add(ctx, 1, telemetry.ErrorAttr("upstream_error"), upstreamAttr)
+		span.SetAttributes(attribute.String("error.type", "upstream_error"))
+		span.SetStatus(codes.Error, err.Error())
+		http.Error(w, "upstream request failed", http.StatusBadGateway)
+		return
+	}
+
+	// 404 means the upstream already forgot the session. Anything else
+	// outside 2xx (405 in particular) means the session lives on.
+	ended := statusCode < 300 || statusCode == http.StatusNotFound
+	if ended {
+		h.endSession(sessionID)
+	} else {
+		span.SetAttributes(attribute.String("error.type", strconv.Itoa(statusCode)))
+		span.SetStatus(codes.Error, "upstream refused session termination")
+	}
+	span.SetAttributes(
+		attribute.Int("http.response.status_code", statusCode),
+		attribute.Bool("mcp.session.terminated", ended),
+	)
+
+	h.metrics.RequestDuration.Record(ctx, time.Since(start).Seconds(), telemetry.MethodAttr(reqInfo.Method), upstreamAttr)
+	h.logger.InfoContext(ctx, "session terminated by client",
+		"mcp.session.id", sessionID,
+		"status", statusCode,
+		"terminated", ended,
+		"upstream.name", up.name,
+	)
+
+	fromUpstreamSession(respHeaders, sessionID)
+	copyHeaders(w.Header(), respHeaders)
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(respBody)
+}
+
+// endSession removes a session from the session store, the cached client
+// sessions, the SSE replay buffer, the page store and the pending server
+// requests.
+func (h *Handler) endSession(sessionID string) {
+	h.sessions.Remove(sessionID)
+	if h.replay != nil {
+		h.replay.drop(sessionID)
+	}
+	h.pages.dropSession(sessionID)
+
+	h.clientSessions.Range(func(key, value any) bool {
+		if value.(string) == sessionID {
+			h.clientSessions.Delete(key)
+		}
+		return true
+	})
+
+	for _, call := range h.pending.takeSession(sessionID) {
+		call.span.SetAttributes(attribute.String("error.type", "session_terminated"))
+		call.span.SetStatus(codes.Error, "session terminated before response")
+		call.span.End()
+	}
+}
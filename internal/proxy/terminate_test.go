@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
+)
+
+func TestServeHTTP_Terminate(t *testing.T) {
+	tests := []struct {
+		name         string
+		deleteStatus int
+		wantEnded    bool
+	}{
+		{"accepted", http.StatusOK, true},
+		{"accepted without content", http.StatusNoContent, true},
+		{"already gone upstream", http.StatusNotFound, true},
+		{"not allowed", http.StatusMethodNotAllowed, false},
+		{"upstream error", http.StatusInternalServerError, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var ends []string
+			sessions := mcp.NewSessionStore(time.Hour, nil, func(_ *mcp.Session, reason string) {
+				mu.Lock()
+				defer mu.Unlock()
+				ends = append(ends, reason)
+			})
+			up := newSessionUpstream(t)
+			up.deleteStatus = tt.deleteStatus
+			h := newTestHandlerWithSessions(t, "upstreams:\n  - name: up\n    url: "+up.URL+"\n", sessions)
+			sessionID := initializeSession(t, h)
+			h.replay.open(sessionID, false)
+			up.requests()
+
+			rec := send(h, http.MethodDelete, sessionID, "")
+			if rec.Code != tt.deleteStatus {
+				t.Errorf("expected the upstream status %d, got %d", tt.deleteStatus, rec.Code)
+			}
+			if got := up.requests(); len(got) != 1 || got[0] != "DELETE up-1" {
+				t.Errorf("expected DELETE on up-1, got %v", got)
+			}
+
+			// Ending the session twice must not count it twice
+			again := send(h, http.MethodDelete, sessionID, "")
+			mu.Lock()
+			defer mu.Unlock()
+			if !tt.wantEnded {
+				if len(ends) != 0 || h.sessions.Get(sessionID) == nil {
+					t.Errorf("expected the session to live on, got ends %v", ends)
+				}
+				return
+			}
+			if len(ends) != 1 || ends[0] != mcp.EndTerminated {
+				t.Errorf("expected one %q end, got %v", mcp.EndTerminated, ends)
+			}
+			if again.Code != http.StatusNotFound || len(up.requests()) != 0 {
+				t.Errorf("expected a second DELETE to get 404 from the proxy, got %d", again.Code)
+			}
+			if _, ok := h.replay.sessions[sessionID]; ok {
+				t.Error("expected the replay buffer of the session to be dropped")
+			}
+		})
+	}
+}
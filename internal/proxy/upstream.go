@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/config"
+	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
+	"github.com/isitobservable/mcp-otel-proxy/internal/stdio"
+	"github.com/isitobservable/mcp-otel-proxy/internal/telemetry"
+)
+
+// upstream is one routable upstream MCP server with its own HTTP client,
+// per-upstream options and reinit state.
+type upstream struct {
+	name   string
+	prefix string
+	url    *url.URL
+	client *http.Client
+	// streamClient has no overall timeout, for long-lived GET streams.
+	streamClient *http.Client
+	headers      map[string]string
+	// settings holds the options that can change on reload without
+	// reconnecting, such as compression.
+	settings atomic.Pointer[config.Upstream]
+	// active counts requests in flight, so a retired upstream is closed
+	// only once they finish.
+	active atomic.Int64
+	// legacySSE is set for upstreams on the deprecated HTTP+SSE transport.
+	legacySSE bool
+	peer      telemetry.Peer
+	reinit    *reinitializer
+	// bridge is set for stdio upstreams and serves as the client transport.
+	bridge *stdio.Bridge
+}
+
+func newUpstream(cfg config.Upstream, metrics *telemetry.Metrics, sessions *mcp.SessionStore, idleTTL time.Duration, logger *slog.Logger) (*upstream, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	port := 80
+	if u.Port() != "" {
+		port, _ = strconv.Atoi(u.Port())
+	}
+	if u.Scheme == "https" && u.Port() == "" {
+		port = 443
+	}
+
+	up := &upstream{
+		name:      cfg.Name,
+		prefix:    cfg.PathPrefix,
+		url:       u,
+		headers:   cfg.Headers,
+		legacySSE: cfg.Transport == config.TransportSSE,
+		peer: telemetry.Peer{
+			Name:      cfg.Name,
+			Address:   u.Hostname(),
+			Port:      port,
+			Transport: "tcp",
+		},
+	}
+
+	up.client = &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second}
+	if cfg.Command != "" {
+		upstreamAttr := telemetry.UpstreamAttr(cfg.Name)
+		up.bridge = stdio.NewBridge(stdio.Options{
+			Name:    cfg.Name,
+			Command: cfg.Command,
+			Args:    cfg.Args,
+			Env:     cfg.Env,
+			IdleTTL: idleTTL,
+			Logger:  logger,
+			OnRestart: func() {
+				metrics.StdioRestarts.Add(context.Background(), 1, upstreamAttr)
+			},
+		})
+		up.client.Transport = up.bridge
+		up.peer.Transport = "pipe"
+	}
+	up.client.Transport = &telemetry.ClientTransport{Base: up.client.Transport, Peer: up.peer}
+	up.streamClient = &http.Client{Transport: up.client.Transport}
+
+	up.reinit = newReinitializer(u.String(), up.client, cfg.Headers, sessions, logger.With("upstream", cfg.Name))
+	up.settings.Store(&cfg)
+	return up, nil
+}
+
+// sameConnection reports whether cfg reaches the upstream the same way, so
+// the upstream can be kept across a reload with only its settings updated.
+func (u *upstream) sameConnection(cfg config.Upstream) bool {
+	current := *u.settings.Load()
+	current.CompressResponses, cfg.CompressResponses = false, false
+	current.CompressFormat, cfg.CompressFormat = "", ""
+	current.CompressFlattenDepth, cfg.CompressFlattenDepth = 0, 0
+	current.PromoteStructuredContent, cfg.PromoteStructuredContent = false, false
+	current.ValidateOutputSchema, cfg.ValidateOutputSchema = false, false
+	current.ValidateInputSchema, cfg.ValidateInputSchema = "", ""
+	current.MaxResponseTokens, cfg.MaxResponseTokens = 0, 0
+	current.MaxResponseBytes, cfg.MaxResponseBytes = 0, 0
+	current.Tools, cfg.Tools = nil, nil
+	return reflect.DeepEqual(current, cfg)
+}
+
+// retire closes the upstream once requests in flight have finished. Long
+// GET streams keep it open until they end. The first wait covers requests
+// routed just before the reload that have not yet been counted.
+func (u *upstream) retire() {
+	for {
+		time.Sleep(time.Second)
+		if u.active.Load() == 0 {
+			break
+		}
+	}
+	u.close()
+}
+
+// close releases resources held by the upstream, stopping stdio processes.
+func (u *upstream) close() {
+	if u.bridge != nil {
+		_ = u.bridge.Close()
+	}
+}
+
+// applyHeaders sets the configured per-upstream headers on an outgoing request.
+func (u *upstream) applyHeaders(req *http.Request) {
+	for k, v := range u.headers {
+		req.Header.Set(k, v)
+	}
+}
+
+// router selects an upstream by longest matching path prefix.
+type router struct {
+	upstreams []*upstream // sorted by descending prefix length
+}
+
+func newRouter(upstreams []*upstream) *router {
+	sorted := make([]*upstream, len(upstreams))
+	copy(sorted, upstreams)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].prefix) > len(sorted[j].prefix)
+	})
+	return &router{upstreams: sorted}
+}
+
+// match returns the upstream serving path and the path to forward upstream
+// with the routing prefix stripped.
+func (rt *router) match(path string) (*upstream, string, bool) {
+	for _, up := range rt.upstreams {
+		if up.prefix == "" {
+			return up, path, true
+		}
+		if path == up.prefix || strings.HasPrefix(path, up.prefix+"/") {
+			rest := strings.TrimPrefix(path, up.prefix)
+			if rest == "" {
+				rest = "/"
+			}
+			return up, rest, true
+		}
+	}
+	return nil, "", false
+}
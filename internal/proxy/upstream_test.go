@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestRouter_Match(t *testing.T) {
+	rt := newRouter([]*upstream{
+		{name: "k8s", prefix: "/k8s"},
+		{name: "catch-all", prefix: ""},
+		{name: "k8s-admin", prefix: "/k8s/admin"},
+	})
+	prefixedOnly := newRouter([]*upstream{{name: "k8s", prefix: "/k8s"}})
+
+	tests := []struct {
+		name     string
+		rt       *router
+		path     string
+		wantName string
+		wantPath string
+	}{
+		{"longest prefix first", rt, "/k8s/admin/mcp", "k8s-admin", "/mcp"},
+		{"shorter prefix", rt, "/k8s/mcp", "k8s", "/mcp"},
+		{"prefix alone", rt, "/k8s", "k8s", "/"},
+		{"prefix with trailing slash", rt, "/k8s/", "k8s", "/"},
+		{"longer prefix alone", rt, "/k8s/admin", "k8s-admin", "/"},
+		{"prefix of a segment only", rt, "/k8sx/mcp", "catch-all", "/k8sx/mcp"},
+		{"catch-all keeps the path", rt, "/mcp", "catch-all", "/mcp"},
+		{"root", rt, "/", "catch-all", "/"},
+		{"no match", prefixedOnly, "/mcp", "", ""},
+		{"no match on a segment prefix", prefixedOnly, "/k8sx", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up, path, ok := tt.rt.match(tt.path)
+			if tt.wantName == "" {
+				if ok {
+					t.Errorf("expected no match, got %s", up.name)
+				}
+				return
+			}
+			if !ok || up.name != tt.wantName || path != tt.wantPath {
+				t.Errorf("expected %s with %s, got %v with %s", tt.wantName, tt.wantPath, up, path)
+			}
+		})
+	}
+}
+
+func TestWithPath(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/k8s/a%2Fb?x=1", nil)
+	if withPath(r, r.URL.Path) != r {
+		t.Error("expected the request unchanged when the path is")
+	}
+
+	r2 := withPath(r, "/a/b")
+	if r2.URL.Path != "/a/b" || r2.URL.RawPath != "" || r2.URL.RawQuery != "x=1" {
+		t.Errorf("expected /a/b?x=1, got %s", r2.URL)
+	}
+	if r.URL.Path != "/k8s/a/b" || r.URL.RawPath != "/k8s/a%2Fb" {
+		t.Errorf("expected the original request untouched, got %s", r.URL)
+	}
+}
+
+func TestServeHTTP_Routing(t *testing.T) {
+	var mu sync.Mutex
+	var got []string
+	record := func(name string) string {
+		up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			got = append(got, name+" "+r.URL.RequestURI())
+			mu.Unlock()
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		t.Cleanup(up.Close)
+		return up.URL
+	}
+	h := newTestHandler(t, `
+upstreams:
+  - name: k8s
+    url: `+record("k8s")+`/base
+    pathPrefix: /k8s/
+  - name: k8s-admin
+    url: `+record("k8s-admin")+`
+    pathPrefix: /k8s/admin
+`)
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		want       string
+	}{
+		{"/k8s/mcp?x=1", http.StatusAccepted, "k8s /base/mcp?x=1"},
+		{"/k8s", http.StatusAccepted, "k8s /base/"},
+		{"/k8s/admin/mcp", http.StatusAccepted, "k8s-admin /mcp"},
+		{"/mcp", http.StatusNotFound, ""},
+		{"/k8sx/mcp", http.StatusNotFound, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got = nil
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(`{"jsonrpc":"2.0","method":"notifications/initialized"}`))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("expected %d, got %d: %s", tt.wantStatus, rec.Code, rec.Body)
+			}
+			if tt.want == "" {
+				if len(got) != 0 {
+					t.Errorf("expected no upstream request, got %v", got)
+				}
+				return
+			}
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("expected %q, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,431 @@
+// Package schema validates JSON values against the JSON Schema subset MCP
+// servers use to describe tool inputs and outputs: types, properties,
+// items, enums, bounds, patterns, combinators and local $ref. Keywords it
+// does not know, such as format, are ignored.
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a compiled JSON Schema.
+type Schema struct {
+	// always and never are the boolean schemas true and false.
+	always, never bool
+
+	types      []string
+	properties map[string]*Schema
+	required   []string
+	// additional applies to properties not in properties; nil allows any.
+	additional *Schema
+	items      *Schema
+	enum       []any
+	constant   any
+	hasConst   bool
+
+	minimum, maximum                   *float64
+	exclusiveMinimum, exclusiveMaximum *float64
+	minLength, maxLength               *int
+	minItems, maxItems                 *int
+	pattern                            *regexp.Regexp
+
+	allOf, anyOf, oneOf []*Schema
+	not                 *Schema
+
+	// ref is resolved against the root document on first use.
+	ref  string
+	root *document
+}
+
+// document is the schema a Schema was compiled from, for resolving $ref.
+type document struct {
+	raw      map[string]any
+	compiled map[string]*Schema
+}
+
+// Violation is one way a value fails a schema.
+type Violation struct {
+	// Path locates the value, such as $.items[2].name.
+	Path    string
+	Message string
+}
+
+func (v Violation) String() string {
+	return v.Path + ": " + v.Message
+}
+
+// Compile parses a JSON Schema document.
+func Compile(data []byte) (*Schema, error) {
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	doc := &document{compiled: make(map[string]*Schema)}
+	if m, ok := raw.(map[string]any); ok {
+		doc.raw = m
+	}
+	return doc.compile(raw, "#")
+}
+
+func (d *document) compile(raw any, at string) (*Schema, error) {
+	switch raw := raw.(type) {
+	case bool:
+		return &Schema{always: raw, never: !raw}, nil
+	case map[string]any:
+		return d.compileObject(raw, at)
+	}
+	return nil, fmt.Errorf("%s: schema must be an object or a boolean", at)
+}
+
+func (d *document) compileObject(m map[string]any, at string) (*Schema, error) {
+	s := &Schema{root: d}
+	var err error
+	sub := func(key string) *Schema {
+		raw, ok := m[key]
+		if !ok || err != nil {
+			return nil
+		}
+		var c *Schema
+		c, err = d.compile(raw, at+"/"+key)
+		return c
+	}
+	list := func(key string) []*Schema {
+		raw, ok := m[key].([]any)
+		if !ok || err != nil {
+			return nil
+		}
+		out := make([]*Schema, 0, len(raw))
+		for i, r := range raw {
+			var c *Schema
+			if c, err = d.compile(r, fmt.Sprintf("%s/%s/%d", at, key, i)); err != nil {
+				return nil
+			}
+			out = append(out, c)
+		}
+		return out
+	}
+
+	if ref, ok := m["$ref"].(string); ok {
+		if !strings.HasPrefix(ref, "#") {
+			return nil, fmt.Errorf("%s: only local $ref is supported, got %q", at, ref)
+		}
+		s.ref = ref
+	}
+	switch t := m["type"].(type) {
+	case string:
+		s.types = []string{t}
+	case []any:
+		for _, v := range t {
+			if name, ok := v.(string); ok {
+				s.types = append(s.types, name)
+			}
+		}
+	}
+	if props, ok := m["properties"].(map[string]any); ok {
+		s.properties = make(map[string]*Schema, len(props))
+		for name, raw := range props {
+			if s.properties[name], err = d.compile(raw, at+"/properties/"+name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if req, ok := m["required"].([]any); ok {
+		for _, v := range req {
+			if name, ok := v.(string); ok {
+				s.required = append(s.required, name)
+			}
+		}
+	}
+	s.additional = sub("additionalProperties")
+	s.items = sub("items")
+	s.not = sub("not")
+	s.allOf = list("allOf")
+	s.anyOf = list("anyOf")
+	s.oneOf = list("oneOf")
+	if err != nil {
+		return nil, err
+	}
+	if enum, ok := m["enum"].([]any); ok {
+		s.enum = enum
+	}
+	s.constant, s.hasConst = m["const"]
+
+	s.minimum = number(m["minimum"])
+	s.maximum = number(m["maximum"])
+	s.exclusiveMinimum = number(m["exclusiveMinimum"])
+	s.exclusiveMaximum = number(m["exclusiveMaximum"])
+	s.minLength = count(m["minLength"])
+	s.maxLength = count(m["maxLength"])
+	s.minItems = count(m["minItems"])
+	s.maxItems = count(m["maxItems"])
+	if p, ok := m["pattern"].(string); ok {
+		if s.pattern, err = regexp.Compile(p); err != nil {
+			return nil, fmt.Errorf("%s/pattern: %w", at, err)
+		}
+	}
+	return s, nil
+}
+
+func number(v any) *float64 {
+	f, ok := v.(float64)
+	if !ok {
+		return nil
+	}
+	return &f
+}
+
+func count(v any) *int {
+	f, ok := v.(float64)
+	if !ok {
+		return nil
+	}
+	n := int(f)
+	return &n
+}
+
+// resolve returns the schema a local $ref such as #/$defs/Pod points to.
+func (d *document) resolve(ref string) (*Schema, error) {
+	if s, ok := d.compiled[ref]; ok {
+		return s, nil
+	}
+	var node any = d.raw
+	for _, part := range strings.Split(strings.TrimPrefix(ref, "#"), "/")[1:] {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+		if node, ok = m[part]; !ok {
+			return nil, fmt.Errorf("unresolvable $ref %q", ref)
+		}
+	}
+	s, err := d.compile(node, ref)
+	if err != nil {
+		return nil, err
+	}
+	d.compiled[ref] = s
+	return s, nil
+}
+
+// ValidateJSON validates a JSON document. err is set only when data is not
+// JSON.
+func (s *Schema) ValidateJSON(data []byte) ([]Violation, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return s.Validate(v), nil
+}
+
+// Validate checks a value decoded by encoding/json into an any and returns
+// every violation found, ordered by path.
+func (s *Schema) Validate(v any) []Violation {
+	var out []Violation
+	s.validate(v, "$", &out)
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+func (s *Schema) validate(v any, path string, out *[]Violation) {
+	fail := func(format string, args ...any) {
+		*out = append(*out, Violation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+	switch {
+	case s.always:
+		return
+	case s.never:
+		fail("no value is allowed")
+		return
+	}
+	if s.ref != "" {
+		target, err := s.root.resolve(s.ref)
+		if err != nil {
+			fail("%v", err)
+			return
+		}
+		target.validate(v, path, out)
+	}
+
+	if len(s.types) > 0 && !matchesType(s.types, v) {
+		fail("expected %s, got %s", strings.Join(s.types, " or "), typeOf(v))
+		// Further keywords would only repeat the type mismatch
+		return
+	}
+	if s.enum != nil && !contains(s.enum, v) {
+		fail("must be one of %s", listValues(s.enum))
+	}
+	if s.hasConst && !reflect.DeepEqual(s.constant, v) {
+		fail("must be %s", display(s.constant))
+	}
+
+	switch v := v.(type) {
+	case map[string]any:
+		for _, name := range s.required {
+			if _, ok := v[name]; !ok {
+				fail("missing required property %q", name)
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			child := childPath(path, name)
+			if prop, ok := s.properties[name]; ok {
+				prop.validate(v[name], child, out)
+			} else if s.additional != nil {
+				if s.additional.never {
+					*out = append(*out, Violation{Path: child, Message: "property is not allowed"})
+				} else {
+					s.additional.validate(v[name], child, out)
+				}
+			}
+		}
+
+	case []any:
+		if s.minItems != nil && len(v) < *s.minItems {
+			fail("must have at least %d items, has %d", *s.minItems, len(v))
+		}
+		if s.maxItems != nil && len(v) > *s.maxItems {
+			fail("must have at most %d items, has %d", *s.maxItems, len(v))
+		}
+		if s.items != nil {
+			for i, item := range v {
+				s.items.validate(item, path+"["+strconv.Itoa(i)+"]", out)
+			}
+		}
+
+	case string:
+		n := utf8.RuneCountInString(v)
+		if s.minLength != nil && n < *s.minLength {
+			fail("must be at least %d characters long", *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			fail("must be at most %d characters long", *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(v) {
+			fail("must match pattern %q", s.pattern.String())
+		}
+
+	case float64:
+		if s.minimum != nil && v < *s.minimum {
+			fail("must be >= %v", *s.minimum)
+		}
+		if s.maximum != nil && v > *s.maximum {
+			fail("must be <= %v", *s.maximum)
+		}
+		if s.exclusiveMinimum != nil && v <= *s.exclusiveMinimum {
+			fail("must be > %v", *s.exclusiveMinimum)
+		}
+		if s.exclusiveMaximum != nil && v >= *s.exclusiveMaximum {
+			fail("must be < %v", *s.exclusiveMaximum)
+		}
+	}
+
+	for _, sub := range s.allOf {
+		sub.validate(v, path, out)
+	}
+	if len(s.anyOf) > 0 && countMatches(s.anyOf, v) == 0 {
+		fail("must match at least one schema in anyOf")
+	}
+	if len(s.oneOf) > 0 {
+		if n := countMatches(s.oneOf, v); n != 1 {
+			fail("must match exactly one schema in oneOf, matches %d", n)
+		}
+	}
+	if s.not != nil && len(s.not.Validate(v)) == 0 {
+		fail("must not match the schema in not")
+	}
+}
+
+func countMatches(schemas []*Schema, v any) int {
+	n := 0
+	for _, s := range schemas {
+		if len(s.Validate(v)) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func matchesType(types []string, v any) bool {
+	for _, t := range types {
+		switch t {
+		case "integer":
+			if f, ok := v.(float64); ok && f == math.Trunc(f) && !math.IsInf(f, 0) {
+				return true
+			}
+		case "number":
+			if _, ok := v.(float64); ok {
+				return true
+			}
+		default:
+			if typeOf(v) == t {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func typeOf(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func contains(values []any, v any) bool {
+	for _, e := range values {
+		if reflect.DeepEqual(e, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func listValues(values []any) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = display(v)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func display(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// childPath appends a property name to path, quoting names that are not
+// identifiers.
+func childPath(path, name string) string {
+	if identifier.MatchString(name) {
+		return path + "." + name
+	}
+	return path + "[" + strconv.Quote(name) + "]"
+}
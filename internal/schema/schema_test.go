@@ -0,0 +1,123 @@
+package schema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func mustCompile(t *testing.T, src string) *Schema {
+	t.Helper()
+	s, err := Compile([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func validate(t *testing.T, s *Schema, doc string) []string {
+	t.Helper()
+	var v any
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, violation := range s.Validate(v) {
+		got = append(got, violation.String())
+	}
+	return got
+}
+
+func TestValidate_Object(t *testing.T) {
+	s := mustCompile(t, `{
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "minLength": 1},
+			"replicas": {"type": "integer", "minimum": 0},
+			"phase": {"enum": ["Running", "Pending"]}
+		},
+		"required": ["name", "replicas"],
+		"additionalProperties": false
+	}`)
+
+	if got := validate(t, s, `{"name": "web", "replicas": 3, "phase": "Running"}`); len(got) != 0 {
+		t.Errorf("expected a valid object, got %q", got)
+	}
+
+	got := validate(t, s, `{"name": "", "replicas": 1.5, "phase": "Done", "extra": true}`)
+	want := []string{
+		`$.extra: property is not allowed`,
+		`$.name: must be at least 1 characters long`,
+		`$.phase: must be one of "Running", "Pending"`,
+		`$.replicas: expected integer, got number`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got violations:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	if got := validate(t, s, `{"name": "web"}`); len(got) != 1 || got[0] != `$: missing required property "replicas"` {
+		t.Errorf("expected a missing property, got %q", got)
+	}
+	if got := validate(t, s, `[]`); len(got) != 1 || got[0] != `$: expected object, got array` {
+		t.Errorf("expected a type mismatch, got %q", got)
+	}
+}
+
+func TestValidate_ArraysAndRefs(t *testing.T) {
+	s := mustCompile(t, `{
+		"type": "object",
+		"properties": {
+			"pods": {"type": "array", "items": {"$ref": "#/$defs/Pod"}, "maxItems": 2}
+		},
+		"$defs": {
+			"Pod": {
+				"type": "object",
+				"properties": {"labels": {"type": "object", "additionalProperties": {"type": "string"}}},
+				"required": ["name"]
+			}
+		}
+	}`)
+
+	got := validate(t, s, `{"pods": [{"name": "a"}, {"labels": {"app.kubernetes.io/name": 1}}, {"name": "c"}]}`)
+	want := []string{
+		`$.pods: must have at most 2 items, has 3`,
+		`$.pods[1]: missing required property "name"`,
+		`$.pods[1].labels["app.kubernetes.io/name"]: expected string, got number`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got violations:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestValidate_Combinators(t *testing.T) {
+	s := mustCompile(t, `{"anyOf": [{"type": "string"}, {"type": "null"}]}`)
+	if got := validate(t, s, `null`); len(got) != 0 {
+		t.Errorf("expected null to match anyOf, got %q", got)
+	}
+	if got := validate(t, s, `1`); len(got) != 1 {
+		t.Errorf("expected a number to fail anyOf, got %q", got)
+	}
+
+	s = mustCompile(t, `{"oneOf": [{"type": "number"}, {"type": "integer"}]}`)
+	if got := validate(t, s, `2`); len(got) != 1 || !strings.Contains(got[0], "matches 2") {
+		t.Errorf("expected an integer to match both oneOf schemas, got %q", got)
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	for _, src := range []string{
+		`"string"`,
+		`{"properties": {"a": 1}}`,
+		`{"$ref": "https://example.com/schema.json"}`,
+		`{"pattern": "("}`,
+	} {
+		if _, err := Compile([]byte(src)); err == nil {
+			t.Errorf("expected %s to be rejected", src)
+		}
+	}
+
+	s := mustCompile(t, `{"$ref": "#/$defs/Missing"}`)
+	if got := validate(t, s, `{}`); len(got) != 1 || !strings.Contains(got[0], "unresolvable") {
+		t.Errorf("expected an unresolvable $ref, got %q", got)
+	}
+}
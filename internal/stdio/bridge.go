@@ -0,0 +1,356 @@
+// Package stdio bridges MCP servers that only speak the stdio transport onto
+// Streamable HTTP. A Bridge is an http.RoundTripper: the proxy forwards
+// requests to it exactly as it would to an HTTP upstream, and the bridge
+// relays them as newline-delimited JSON-RPC to a child process. Every MCP
+// session gets its own process, started by the session's initialize request.
+package stdio
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/isitobservable/mcp-otel-proxy/internal/jsonrpc"
+	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
+)
+
+// Options configures a Bridge.
+type Options struct {
+	Name    string
+	Command string
+	Args    []string
+	Env     map[string]string
+	// IdleTTL stops processes whose session has been idle this long. Zero
+	// keeps processes until the session is terminated.
+	IdleTTL time.Duration
+	Logger  *slog.Logger
+	// OnRestart is called every time a crashed process is restarted.
+	OnRestart func()
+}
+
+// Bridge serves MCP requests from per-session stdio child processes.
+type Bridge struct {
+	opts   Options
+	env    []string
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	procs  map[string]*process
+	closed bool
+	done   chan struct{}
+}
+
+// NewBridge creates a bridge. Processes are started lazily on initialize.
+func NewBridge(opts Options) *Bridge {
+	env := os.Environ()
+	for k, v := range opts.Env {
+		env = append(env, k+"="+v)
+	}
+	b := &Bridge{
+		opts:   opts,
+		env:    env,
+		logger: opts.Logger.With("upstream.name", opts.Name, "upstream.command", opts.Command),
+		procs:  make(map[string]*process),
+		done:   make(chan struct{}),
+	}
+	if opts.IdleTTL > 0 {
+		go b.reapLoop()
+	}
+	return b
+}
+
+// Close stops every child process.
+func (b *Bridge) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	procs := b.procs
+	b.procs = make(map[string]*process)
+	b.mu.Unlock()
+
+	for _, p := range procs {
+		p.close()
+	}
+	return nil
+}
+
+// RoundTrip implements http.RoundTripper following the Streamable HTTP
+// transport: POST carries client messages, GET opens the server-initiated
+// message stream, DELETE terminates the session.
+func (b *Bridge) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		defer func() { _ = req.Body.Close() }()
+	}
+	switch req.Method {
+	case http.MethodPost:
+		return b.post(req)
+	case http.MethodGet:
+		return b.listen(req)
+	case http.MethodDelete:
+		return b.terminate(req)
+	default:
+		return textResponse(req, http.StatusMethodNotAllowed, "method not allowed"), nil
+	}
+}
+
+func (b *Bridge) post(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+	}
+	parsed, err := jsonrpc.ParseRequest(body)
+	if err != nil {
+		return textResponse(req, http.StatusBadRequest, "invalid JSON-RPC message"), nil
+	}
+
+	var line bytes.Buffer
+	if err := json.Compact(&line, body); err != nil {
+		return textResponse(req, http.StatusBadRequest, "invalid JSON-RPC message"), nil
+	}
+
+	sessionID := req.Header.Get("Mcp-Session-Id")
+	var p *process
+	if sessionID == "" && !parsed.IsBatch && parsed.Requests[0].Method == "initialize" {
+		p, err = b.spawn(line.Bytes())
+		if err != nil {
+			b.logger.Error("failed to start stdio MCP server", "error", err)
+			return textResponse(req, http.StatusBadGateway, "failed to start upstream process"), nil
+		}
+	} else {
+		if sessionID == "" {
+			return textResponse(req, http.StatusBadRequest, "missing Mcp-Session-Id header"), nil
+		}
+		if p = b.lookup(sessionID); p == nil {
+			return textResponse(req, http.StatusNotFound, "session not found"), nil
+		}
+	}
+
+	// Responses to server-initiated requests and notifications are
+	// fire-and-forget; only requests wait for a reply.
+	var ids []string
+	var tokens []string
+	for i := range parsed.Requests {
+		r := &parsed.Requests[i]
+		if r.Method == "" || r.IsNotification() {
+			continue
+		}
+		ids = append(ids, idKey(r.ID))
+		if tok := progressToken(r.Params); tok != "" {
+			tokens = append(tokens, tok)
+		}
+	}
+
+	c, err := p.send(req.Context(), line.Bytes(), ids, tokens)
+	if err != nil {
+		return textResponse(req, http.StatusBadGateway, err.Error()), nil
+	}
+	if c == nil {
+		resp := textResponse(req, http.StatusAccepted, "")
+		resp.Header.Set("Mcp-Session-Id", p.sessionID)
+		return resp, nil
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer p.release(c)
+		for {
+			select {
+			case msg, ok := <-c.msgs:
+				if !ok {
+					_ = pw.Close()
+					return
+				}
+				if err := writeEvent(pw, msg); err != nil {
+					return
+				}
+			case <-req.Context().Done():
+				_ = pw.CloseWithError(req.Context().Err())
+				return
+			}
+		}
+	}()
+	return sseResponse(req, p.sessionID, pr), nil
+}
+
+func (b *Bridge) listen(req *http.Request) (*http.Response, error) {
+	sessionID := req.Header.Get("Mcp-Session-Id")
+	if sessionID == "" {
+		return textResponse(req, http.StatusBadRequest, "missing Mcp-Session-Id header"), nil
+	}
+	p := b.lookup(sessionID)
+	if p == nil {
+		return textResponse(req, http.StatusNotFound, "session not found"), nil
+	}
+	ch, ok := p.attachListener()
+	if !ok {
+		return textResponse(req, http.StatusConflict, "stream already open for session"), nil
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer p.detachListener(ch)
+		for {
+			select {
+			case msg := <-ch:
+				if err := writeEvent(pw, msg); err != nil {
+					return
+				}
+			case <-p.done:
+				_ = pw.Close()
+				return
+			case <-req.Context().Done():
+				_ = pw.CloseWithError(req.Context().Err())
+				return
+			}
+		}
+	}()
+	return sseResponse(req, p.sessionID, pr), nil
+}
+
+func (b *Bridge) terminate(req *http.Request) (*http.Response, error) {
+	sessionID := req.Header.Get("Mcp-Session-Id")
+	b.mu.Lock()
+	p, ok := b.procs[sessionID]
+	delete(b.procs, sessionID)
+	b.mu.Unlock()
+	if !ok {
+		return textResponse(req, http.StatusNotFound, "session not found"), nil
+	}
+	p.close()
+	return textResponse(req, http.StatusOK, ""), nil
+}
+
+func (b *Bridge) spawn(initBody []byte) (*process, error) {
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+	if closed {
+		return nil, fmt.Errorf("bridge closed")
+	}
+
+	p := newProcess(b, mcp.NewSessionID(), initBody)
+	if err := p.start(); err != nil {
+		return nil, err
+	}
+	p.markReady()
+
+	b.mu.Lock()
+	b.procs[p.sessionID] = p
+	b.mu.Unlock()
+
+	go p.supervise()
+	return p, nil
+}
+
+func (b *Bridge) lookup(sessionID string) *process {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.procs[sessionID]
+}
+
+func (b *Bridge) reapLoop() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.done:
+			return
+		case <-ticker.C:
+			b.reap()
+		}
+	}
+}
+
+func (b *Bridge) reap() {
+	b.mu.Lock()
+	var idle []*process
+	for id, p := range b.procs {
+		if p.idleFor() > b.opts.IdleTTL {
+			idle = append(idle, p)
+			delete(b.procs, id)
+		}
+	}
+	b.mu.Unlock()
+
+	for _, p := range idle {
+		b.logger.Info("stopping idle stdio MCP server", "mcp.session.id", p.sessionID)
+		p.close()
+	}
+}
+
+// idKey normalizes a JSON-RPC ID for use as a map key.
+func idKey(id json.RawMessage) string {
+	return string(bytes.TrimSpace(id))
+}
+
+// progressToken returns params._meta.progressToken as a map key, if present.
+func progressToken(params json.RawMessage) string {
+	if len(params) == 0 {
+		return ""
+	}
+	var p struct {
+		Meta struct {
+			ProgressToken json.RawMessage `json:"progressToken"`
+		} `json:"_meta"`
+	}
+	if json.Unmarshal(params, &p) != nil {
+		return ""
+	}
+	return idKey(p.Meta.ProgressToken)
+}
+
+func writeEvent(w io.Writer, msg []byte) error {
+	_, err := fmt.Fprintf(w, "event: message\ndata: %s\n\n", msg)
+	return err
+}
+
+func sseResponse(req *http.Request, sessionID string, body io.ReadCloser) *http.Response {
+	header := make(http.Header)
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Mcp-Session-Id", sessionID)
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          body,
+		ContentLength: -1,
+		Request:       req,
+	}
+}
+
+func textResponse(req *http.Request, status int, text string) *http.Response {
+	header := make(http.Header)
+	if text != "" {
+		header.Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(text)),
+		ContentLength: int64(len(text)),
+		Request:       req,
+	}
+}
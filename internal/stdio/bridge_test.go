@@ -0,0 +1,298 @@
+package stdio
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// The test binary doubles as the stdio MCP server: with fakeServerEnv set it
+// runs fakeServer instead of the tests.
+const fakeServerEnv = "STDIO_BRIDGE_FAKE_SERVER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(fakeServerEnv) == "1" {
+		fakeServer()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+type fakeMessage struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params,omitempty"`
+}
+
+// fakeServer answers newline-delimited JSON-RPC on stdin and reports every
+// message it receives on stderr. Batches are answered in reverse order.
+//
+//   - initialize answers with a protocol version
+//   - echo answers with its params
+//   - progress sends a progress notification for its token, then answers
+//   - crash exits without answering
+//   - notifications/ping_me sends the server request "ping"
+func fakeServer() {
+	out := json.NewEncoder(os.Stdout)
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		var msgs []fakeMessage
+		if bytes.HasPrefix(line, []byte("[")) {
+			_ = json.Unmarshal(line, &msgs)
+			slices.Reverse(msgs)
+		} else {
+			var m fakeMessage
+			_ = json.Unmarshal(line, &m)
+			msgs = append(msgs, m)
+		}
+		for _, m := range msgs {
+			fmt.Fprintf(os.Stderr, "received %s %s\n", m.Method, m.ID)
+			respond := func(result any) {
+				_ = out.Encode(map[string]any{"jsonrpc": "2.0", "id": m.ID, "result": result})
+			}
+			switch m.Method {
+			case "initialize":
+				respond(map[string]string{"protocolVersion": "2025-03-26"})
+			case "echo":
+				respond(m.Params)
+			case "progress":
+				var p struct {
+					Meta struct {
+						ProgressToken json.RawMessage `json:"progressToken"`
+					} `json:"_meta"`
+				}
+				_ = json.Unmarshal(m.Params, &p)
+				_ = out.Encode(map[string]any{"jsonrpc": "2.0", "method": "notifications/progress",
+					"params": map[string]any{"progressToken": p.Meta.ProgressToken, "progress": 1}})
+				respond(map[string]bool{"done": true})
+			case "crash":
+				os.Exit(3)
+			case "notifications/ping_me":
+				_ = out.Encode(map[string]any{"jsonrpc": "2.0", "id": "srv-1", "method": "ping"})
+			}
+		}
+	}
+}
+
+// logBuffer collects log output written from several goroutines.
+type logBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (l *logBuffer) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.Write(p)
+}
+
+func (l *logBuffer) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.buf.String()
+}
+
+// waitFor fails the test unless every one of want shows up in the logs
+// shortly. Stderr is captured by its own goroutine, so it may lag behind
+// stdout.
+func (l *logBuffer) waitFor(t *testing.T, want ...string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		out := l.String()
+		missing := slices.IndexFunc(want, func(w string) bool { return !strings.Contains(out, w) })
+		if missing < 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected logs to contain %s, got:\n%s", want[missing], out)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func newTestBridge(t *testing.T, onRestart func()) (*Bridge, *logBuffer) {
+	t.Helper()
+	logs := &logBuffer{}
+	b := NewBridge(Options{
+		Name:      "fake",
+		Command:   os.Args[0],
+		Env:       map[string]string{fakeServerEnv: "1"},
+		Logger:    slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		OnRestart: onRestart,
+	})
+	t.Cleanup(func() { _ = b.Close() })
+	return b, logs
+}
+
+func roundTrip(t *testing.T, b *Bridge, method, sessionID, body string) *http.Response {
+	t.Helper()
+	req := httptest.NewRequest(method, "/mcp", strings.NewReader(body))
+	if sessionID != "" {
+		req.Header.Set("Mcp-Session-Id", sessionID)
+	}
+	resp, err := b.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+// events reads an SSE response to the end and returns its data payloads.
+func events(t *testing.T, resp *http.Response) []string {
+	t.Helper()
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	var data []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		if d, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			data = append(data, d)
+		}
+	}
+	return data
+}
+
+// initialize starts a session and returns its ID.
+func initialize(t *testing.T, b *Bridge) string {
+	t.Helper()
+	resp := roundTrip(t, b, http.MethodPost, "", `{"jsonrpc":"2.0","id":0,"method":"initialize","params":{}}`)
+	sessionID := resp.Header.Get("Mcp-Session-Id")
+	if got := events(t, resp); len(got) != 1 || !strings.Contains(got[0], "protocolVersion") {
+		t.Fatalf("expected the initialize result, got %v", got)
+	}
+	if sessionID == "" {
+		t.Fatal("expected a session ID on the initialize response")
+	}
+	return sessionID
+}
+
+func TestBridge_SessionLifecycle(t *testing.T) {
+	b, _ := newTestBridge(t, nil)
+	sessionID := initialize(t, b)
+
+	got := events(t, roundTrip(t, b, http.MethodPost, sessionID, `{"jsonrpc":"2.0","id":1,"method":"echo","params":{"x":1}}`))
+	if len(got) != 1 || got[0] != `{"id":1,"jsonrpc":"2.0","result":{"x":1}}` {
+		t.Errorf("expected the echo result, got %v", got)
+	}
+
+	if resp := roundTrip(t, b, http.MethodPost, sessionID, `{"jsonrpc":"2.0","method":"notifications/initialized"}`); resp.StatusCode != http.StatusAccepted {
+		t.Errorf("expected 202 for a notification, got %d", resp.StatusCode)
+	}
+	if resp := roundTrip(t, b, http.MethodPost, "", `{"jsonrpc":"2.0","id":2,"method":"echo"}`); resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 without a session, got %d", resp.StatusCode)
+	}
+	if resp := roundTrip(t, b, http.MethodPost, "unknown", `{"jsonrpc":"2.0","id":2,"method":"echo"}`); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 for an unknown session, got %d", resp.StatusCode)
+	}
+
+	if resp := roundTrip(t, b, http.MethodDelete, sessionID, ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected 200 terminating the session, got %d", resp.StatusCode)
+	}
+	if resp := roundTrip(t, b, http.MethodPost, sessionID, `{"jsonrpc":"2.0","id":3,"method":"echo"}`); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 after termination, got %d", resp.StatusCode)
+	}
+}
+
+func TestBridge_SessionsGetTheirOwnProcess(t *testing.T) {
+	b, logs := newTestBridge(t, nil)
+	first, second := initialize(t, b), initialize(t, b)
+	if first == second {
+		t.Fatalf("expected distinct session IDs, got %q twice", first)
+	}
+	if n := strings.Count(logs.String(), "started stdio MCP server"); n != 2 {
+		t.Errorf("expected two processes, got %d", n)
+	}
+}
+
+func TestBridge_BatchResponsesDemuxedByID(t *testing.T) {
+	b, _ := newTestBridge(t, nil)
+	sessionID := initialize(t, b)
+
+	got := events(t, roundTrip(t, b, http.MethodPost, sessionID,
+		`[{"jsonrpc":"2.0","id":1,"method":"echo","params":{"n":1}},{"jsonrpc":"2.0","method":"notifications/x"},{"jsonrpc":"2.0","id":"two","method":"echo","params":{"n":2}}]`))
+	want := []string{
+		`{"id":"two","jsonrpc":"2.0","result":{"n":2}}`,
+		`{"id":1,"jsonrpc":"2.0","result":{"n":1}}`,
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("expected both responses in the order written, got %v", got)
+	}
+}
+
+func TestBridge_ProgressRoutedToItsCall(t *testing.T) {
+	b, _ := newTestBridge(t, nil)
+	sessionID := initialize(t, b)
+
+	got := events(t, roundTrip(t, b, http.MethodPost, sessionID,
+		`{"jsonrpc":"2.0","id":7,"method":"progress","params":{"_meta":{"progressToken":"tok"}}}`))
+	if len(got) != 2 || !strings.Contains(got[0], `"progressToken":"tok"`) || !strings.Contains(got[1], `"id":7`) {
+		t.Errorf("expected the progress notification then the response, got %v", got)
+	}
+}
+
+func TestBridge_ServerRequestsGoToTheListener(t *testing.T) {
+	b, _ := newTestBridge(t, nil)
+	sessionID := initialize(t, b)
+
+	stream := roundTrip(t, b, http.MethodGet, sessionID, "")
+	defer func() { _ = stream.Body.Close() }()
+	if resp := roundTrip(t, b, http.MethodGet, sessionID, ""); resp.StatusCode != http.StatusConflict {
+		t.Errorf("expected 409 for a second stream, got %d", resp.StatusCode)
+	}
+
+	roundTrip(t, b, http.MethodPost, sessionID, `{"jsonrpc":"2.0","method":"notifications/ping_me"}`)
+	scanner := bufio.NewScanner(stream.Body)
+	for scanner.Scan() {
+		if d, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			if !strings.Contains(d, `"method":"ping"`) {
+				t.Errorf("expected the server's ping request, got %s", d)
+			}
+			return
+		}
+	}
+	t.Fatal("listener stream closed without a message")
+}
+
+func TestBridge_RestartReplaysInitialize(t *testing.T) {
+	restarted := make(chan struct{}, 1)
+	b, logs := newTestBridge(t, func() { restarted <- struct{}{} })
+	sessionID := initialize(t, b)
+
+	got := events(t, roundTrip(t, b, http.MethodPost, sessionID, `{"jsonrpc":"2.0","id":1,"method":"crash"}`))
+	if len(got) != 1 || !strings.Contains(got[0], `"id":1`) || !strings.Contains(got[0], "upstream process exited") {
+		t.Fatalf("expected the in-flight call to fail, got %v", got)
+	}
+
+	select {
+	case <-restarted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("process was not restarted")
+	}
+
+	// The call waits until the replayed handshake has finished
+	got = events(t, roundTrip(t, b, http.MethodPost, sessionID, `{"jsonrpc":"2.0","id":2,"method":"echo","params":{"ok":true}}`))
+	if len(got) != 1 || !strings.Contains(got[0], `"ok":true`) {
+		t.Errorf("expected the session to keep working after the restart, got %v", got)
+	}
+
+	logs.waitFor(t,
+		`stderr="received initialize 0"`,
+		`stderr="received initialize \"mcp-otel-proxy-replay-1\""`,
+		`stderr="received notifications/initialized`,
+		"stdio MCP server exited, restarting",
+	)
+}
@@ -0,0 +1,540 @@
+package stdio
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const (
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+	// A process that ran at least this long resets the restart backoff.
+	stableRun = time.Minute
+	// Grace period between closing stdin and killing the process.
+	stopGrace   = 2 * time.Second
+	replayLimit = 30 * time.Second
+
+	maxLineSize = 16 * 1024 * 1024
+)
+
+var errProcessClosed = errors.New("upstream process closed")
+
+// call is an in-flight client request waiting for responses on stdout.
+type call struct {
+	ctx       context.Context
+	remaining map[string]bool
+	tokens    map[string]bool
+	msgs      chan []byte
+}
+
+// deliver hands a message to the call's stream unless the client went away.
+func (c *call) deliver(msg []byte) {
+	select {
+	case c.msgs <- msg:
+	case <-c.ctx.Done():
+	}
+}
+
+// process supervises one child process serving a single MCP session.
+type process struct {
+	bridge    *Bridge
+	sessionID string
+	initBody  []byte
+
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	readers  sync.WaitGroup
+	started  time.Time
+	running  bool
+	ready    chan struct{}
+	closed   bool
+	done     chan struct{}
+	pending  map[string]*call
+	calls    []*call
+	listener chan []byte
+	lastUsed time.Time
+	replays  int
+}
+
+func newProcess(b *Bridge, sessionID string, initBody []byte) *process {
+	return &process{
+		bridge:    b,
+		sessionID: sessionID,
+		initBody:  initBody,
+		ready:     make(chan struct{}),
+		done:      make(chan struct{}),
+		pending:   make(map[string]*call),
+		lastUsed:  time.Now(),
+	}
+}
+
+// start launches the child process and its stdout/stderr readers.
+func (p *process) start() error {
+	opts := p.bridge.opts
+	cmd := exec.Command(opts.Command, opts.Args...)
+	cmd.Env = p.bridge.env
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.cmd = cmd
+	p.stdin = stdin
+	p.started = time.Now()
+	p.mu.Unlock()
+
+	p.bridge.logger.Info("started stdio MCP server",
+		"mcp.session.id", p.sessionID,
+		"process.pid", cmd.Process.Pid,
+	)
+
+	p.readers.Add(2)
+	go p.readStdout(stdout)
+	go p.readStderr(stderr, cmd.Process.Pid)
+	return nil
+}
+
+// supervise restarts the process with exponential backoff whenever it exits,
+// replaying the session's initialize handshake so the session stays valid.
+func (p *process) supervise() {
+	delay := minBackoff
+	for {
+		err := p.wait()
+		p.markDown()
+		if p.isClosed() {
+			return
+		}
+		if time.Since(p.startedAt()) >= stableRun {
+			delay = minBackoff
+		}
+		p.bridge.logger.Warn("stdio MCP server exited, restarting",
+			"mcp.session.id", p.sessionID,
+			"error", err,
+			"backoff", delay.String(),
+		)
+
+		for {
+			select {
+			case <-time.After(delay):
+			case <-p.done:
+				return
+			}
+			delay = min(delay*2, maxBackoff)
+
+			if err := p.start(); err != nil {
+				p.bridge.logger.Error("failed to restart stdio MCP server",
+					"mcp.session.id", p.sessionID, "error", err)
+				continue
+			}
+			if p.bridge.opts.OnRestart != nil {
+				p.bridge.opts.OnRestart()
+			}
+			if err := p.replayInit(); err != nil {
+				p.bridge.logger.Error("failed to replay initialize after restart",
+					"mcp.session.id", p.sessionID, "error", err)
+				p.kill()
+				break
+			}
+			p.markReady()
+			break
+		}
+	}
+}
+
+// wait blocks until the current process exits. Pipes must be drained before
+// calling cmd.Wait.
+func (p *process) wait() error {
+	p.readers.Wait()
+	p.mu.Lock()
+	cmd := p.cmd
+	p.mu.Unlock()
+	return cmd.Wait()
+}
+
+// replayInit re-sends the cached initialize request under a proxy-owned ID,
+// followed by notifications/initialized, on a freshly restarted process.
+func (p *process) replayInit() error {
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(p.initBody, &msg); err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.replays++
+	id := fmt.Sprintf(`"mcp-otel-proxy-replay-%d"`, p.replays)
+	p.mu.Unlock()
+	msg["id"] = json.RawMessage(id)
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), replayLimit)
+	defer cancel()
+	c := p.register(ctx, []string{id}, nil)
+	defer p.release(c)
+	if err := p.write(line); err != nil {
+		return err
+	}
+	// Other messages may be routed to this call while it is the only one in
+	// flight; the channel closes once the initialize response arrives.
+	for done := false; !done; {
+		select {
+		case _, ok := <-c.msgs:
+			done = !ok
+		case <-ctx.Done():
+			return fmt.Errorf("initialize replay: %w", ctx.Err())
+		}
+	}
+	return p.write([]byte(`{"jsonrpc":"2.0","method":"notifications/initialized"}`))
+}
+
+// send writes a client message to stdin. When ids is non-empty it returns a
+// call whose msgs channel yields related messages and is closed once every
+// response has arrived.
+func (p *process) send(ctx context.Context, line []byte, ids, tokens []string) (*call, error) {
+	if err := p.waitReady(ctx); err != nil {
+		return nil, err
+	}
+	p.touch()
+
+	var c *call
+	if len(ids) > 0 {
+		c = p.register(ctx, ids, tokens)
+	}
+	if err := p.write(line); err != nil {
+		if c != nil {
+			p.release(c)
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+func (p *process) register(ctx context.Context, ids, tokens []string) *call {
+	c := &call{
+		ctx:       ctx,
+		remaining: make(map[string]bool, len(ids)),
+		tokens:    make(map[string]bool, len(tokens)),
+		msgs:      make(chan []byte, 64),
+	}
+	for _, id := range ids {
+		c.remaining[id] = true
+	}
+	for _, tok := range tokens {
+		c.tokens[tok] = true
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id := range c.remaining {
+		p.pending[id] = c
+	}
+	p.calls = append(p.calls, c)
+	return c
+}
+
+// release forgets a call once its stream is done or abandoned.
+func (p *process) release(c *call) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for id, pc := range p.pending {
+		if pc == c {
+			delete(p.pending, id)
+		}
+	}
+	p.removeCall(c)
+	p.lastUsed = time.Now()
+}
+
+// removeCall drops c from the in-flight list; p.mu must be held.
+func (p *process) removeCall(c *call) {
+	for i, pc := range p.calls {
+		if pc == c {
+			p.calls = append(p.calls[:i], p.calls[i+1:]...)
+			return
+		}
+	}
+}
+
+func (p *process) write(line []byte) error {
+	p.mu.Lock()
+	stdin := p.stdin
+	p.mu.Unlock()
+	if stdin == nil {
+		return errProcessClosed
+	}
+
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	if _, err := stdin.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("write to upstream process: %w", err)
+	}
+	return nil
+}
+
+func (p *process) readStdout(r io.Reader) {
+	defer p.readers.Done()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		msg := make([]byte, len(line))
+		copy(msg, line)
+		p.dispatch(msg)
+	}
+	if err := scanner.Err(); err != nil {
+		p.bridge.logger.Warn("stdio MCP server stdout read failed",
+			"mcp.session.id", p.sessionID, "error", err)
+	}
+}
+
+func (p *process) readStderr(r io.Reader, pid int) {
+	defer p.readers.Done()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		p.bridge.logger.Info("stdio MCP server stderr",
+			"mcp.session.id", p.sessionID,
+			"process.pid", pid,
+			"stderr", scanner.Text(),
+		)
+	}
+}
+
+// dispatch routes one stdout message. Responses go to the call waiting for
+// their ID; notifications and server requests go to the call owning their
+// progress token, then the GET listener, then the oldest in-flight call.
+func (p *process) dispatch(msg []byte) {
+	if msg[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(msg, &batch); err != nil {
+			p.bridge.logger.Warn("invalid JSON-RPC batch from stdio MCP server", "error", err)
+			return
+		}
+		for _, m := range batch {
+			p.dispatch(bytes.TrimSpace(m))
+		}
+		return
+	}
+
+	var head struct {
+		ID     json.RawMessage `json:"id"`
+		Method string          `json:"method"`
+		Params json.RawMessage `json:"params"`
+	}
+	if err := json.Unmarshal(msg, &head); err != nil {
+		p.bridge.logger.Warn("invalid JSON-RPC message from stdio MCP server",
+			"mcp.session.id", p.sessionID, "error", err)
+		return
+	}
+
+	p.mu.Lock()
+	var target *call
+	last := false
+	var listener chan []byte
+	if head.Method == "" {
+		key := idKey(head.ID)
+		if c, ok := p.pending[key]; ok {
+			target = c
+			delete(p.pending, key)
+			delete(c.remaining, key)
+			if last = len(c.remaining) == 0; last {
+				p.removeCall(c)
+			}
+		}
+	} else {
+		if tok := progressToken(head.Params); tok != "" {
+			for _, c := range p.calls {
+				if c.tokens[tok] {
+					target = c
+					break
+				}
+			}
+		}
+		if target == nil && p.listener == nil && len(p.calls) > 0 {
+			target = p.calls[0]
+		}
+		if target == nil {
+			listener = p.listener
+		}
+	}
+	p.mu.Unlock()
+
+	switch {
+	case target != nil:
+		target.deliver(msg)
+		if last {
+			close(target.msgs)
+		}
+	case listener != nil:
+		select {
+		case listener <- msg:
+		default:
+			p.bridge.logger.Warn("dropping server message, listener stream is full",
+				"mcp.session.id", p.sessionID, "mcp.method.name", head.Method)
+		}
+	default:
+		p.bridge.logger.Debug("dropping unrouted message from stdio MCP server",
+			"mcp.session.id", p.sessionID, "mcp.method.name", head.Method)
+	}
+}
+
+// markDown fails every in-flight call with a JSON-RPC error after the
+// process exits. Readers have finished, so no dispatch races with this.
+func (p *process) markDown() {
+	p.mu.Lock()
+	p.running = false
+	p.stdin = nil
+	p.ready = make(chan struct{})
+	calls := p.calls
+	p.calls = nil
+	p.pending = make(map[string]*call)
+	p.mu.Unlock()
+
+	for _, c := range calls {
+		for id := range c.remaining {
+			c.deliver(exitError(id))
+		}
+		close(c.msgs)
+	}
+}
+
+func (p *process) markReady() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.running = true
+	close(p.ready)
+}
+
+func (p *process) waitReady(ctx context.Context) error {
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return errProcessClosed
+		}
+		if p.running {
+			p.mu.Unlock()
+			return nil
+		}
+		ready := p.ready
+		p.mu.Unlock()
+
+		select {
+		case <-ready:
+		case <-p.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (p *process) attachListener() (chan []byte, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.listener != nil {
+		return nil, false
+	}
+	p.listener = make(chan []byte, 64)
+	p.lastUsed = time.Now()
+	return p.listener, true
+}
+
+func (p *process) detachListener(ch chan []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.listener == ch {
+		p.listener = nil
+	}
+	p.lastUsed = time.Now()
+}
+
+func (p *process) touch() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.lastUsed = time.Now()
+}
+
+// idleFor reports how long the session has had no traffic or open streams.
+func (p *process) idleFor() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.calls) > 0 || p.listener != nil {
+		return 0
+	}
+	return time.Since(p.lastUsed)
+}
+
+func (p *process) startedAt() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.started
+}
+
+func (p *process) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+// close stops supervision, closes stdin and kills the process if it does not
+// exit within the grace period.
+func (p *process) close() {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.done)
+	stdin := p.stdin
+	p.mu.Unlock()
+
+	if stdin != nil {
+		_ = stdin.Close()
+	}
+	time.AfterFunc(stopGrace, p.kill)
+}
+
+func (p *process) kill() {
+	p.mu.Lock()
+	cmd := p.cmd
+	p.mu.Unlock()
+	if cmd != nil && cmd.Process != nil {
+		// Kill after exit returns os.ErrProcessDone, which is harmless.
+		_ = cmd.Process.Kill()
+	}
+}
+
+// exitError builds the JSON-RPC error returned for requests that were in
+// flight when the process exited.
+func exitError(id string) []byte {
+	return []byte(`{"jsonrpc":"2.0","id":` + id + `,"error":{"code":-32603,"message":"upstream process exited"}}`)
+}
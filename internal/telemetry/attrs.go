@@ -17,6 +17,40 @@ func MethodAttr(method string) metric.MeasurementOption {
 	return metric.WithAttributes(attribute.String("mcp.method.name", method))
 }
 
+// UpstreamAttr returns a metric option with the mcp.proxy.upstream.name attribute.
+func UpstreamAttr(name string) metric.MeasurementOption {
+	return metric.WithAttributes(attribute.String("mcp.proxy.upstream.name", name))
+}
+
+// CompressFormatAttr returns a metric option with the compression format used.
+func CompressFormatAttr(format string) metric.MeasurementOption {
+	return metric.WithAttributes(attribute.String("mcp.response.compression.format", format))
+}
+
+// TokenTypeAttr returns a metric option with gen_ai.token.type: original or
+// compressed.
+func TokenTypeAttr(tokenType string) metric.MeasurementOption {
+	return metric.WithAttributes(attribute.String("gen_ai.token.type", tokenType))
+}
+
+// ReplayResultAttr returns a metric option with the SSE replay result: hit
+// when the proxy replayed from its buffer, miss when it could not.
+func ReplayResultAttr(result string) metric.MeasurementOption {
+	return metric.WithAttributes(attribute.String("mcp.proxy.sse.replay.result", result))
+}
+
+// PageResultAttr returns a metric option with the page fetch result: hit
+// when the proxy had the page, miss when it had expired or never existed.
+func PageResultAttr(result string) metric.MeasurementOption {
+	return metric.WithAttributes(attribute.String("mcp.proxy.page.result", result))
+}
+
+// InputValidationAttr returns a metric option with the action taken on
+// tool arguments that fail their inputSchema: warn or reject.
+func InputValidationAttr(action string) metric.MeasurementOption {
+	return metric.WithAttributes(attribute.String("mcp.proxy.input_validation.action", action))
+}
+
 // DirectionAttr returns a metric option with direction attribute.
 func DirectionAttr(direction string) metric.MeasurementOption {
 	return metric.WithAttributes(attribute.String("direction", direction))
@@ -46,3 +80,14 @@ func MethodToolErrorAttrs(method, toolName string, respInfo *mcp.ResponseInfo) m
 	}
 	return metric.WithAttributes(attrs...)
 }
+
+// SessionEndAttrs returns metric options describing how a session ended.
+func SessionEndAttrs(session *mcp.Session, reason string) metric.MeasurementOption {
+	attrs := []attribute.KeyValue{
+		attribute.String("mcp.proxy.session.end_reason", reason),
+	}
+	if session.ProtocolVersion != "" {
+		attrs = append(attrs, attribute.String("mcp.protocol.version", session.ProtocolVersion))
+	}
+	return metric.WithAttributes(attrs...)
+}
@@ -0,0 +1,119 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ClientTransport records a CLIENT span for every request sent to an
+// upstream MCP server, following the HTTP semantic conventions. The span
+// ends when the response body is read to the end or closed, so it covers
+// streamed responses too. The span's context goes upstream in the
+// traceparent and tracestate headers, replacing any the client sent.
+type ClientTransport struct {
+	// Base sends the request; nil means http.DefaultTransport.
+	Base http.RoundTripper
+	Peer Peer
+}
+
+type resendKey struct{}
+
+// WithResendCount marks requests made with ctx as the nth resend of an
+// earlier request, such as the retry after a session reinit.
+func WithResendCount(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, resendKey{}, n)
+}
+
+func (t *ClientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attrs := []attribute.KeyValue{
+		attribute.String("http.request.method", req.Method),
+		attribute.String("url.full", redactURL(req.URL)),
+		attribute.String("network.transport", t.Peer.Transport),
+		attribute.String("mcp.proxy.upstream.name", t.Peer.Name),
+	}
+	if t.Peer.Transport != "pipe" {
+		attrs = append(attrs,
+			attribute.String("server.address", t.Peer.Address),
+			attribute.Int("server.port", t.Peer.Port),
+		)
+	}
+	if n, _ := req.Context().Value(resendKey{}).(int); n > 0 {
+		attrs = append(attrs, attribute.Int("http.request.resend_count", n))
+	}
+	ctx, span := otel.Tracer(tracerName).Start(req.Context(), req.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	// RoundTrippers must not modify the request they are given
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		span.SetAttributes(attribute.String("error.type", fmt.Sprintf("%T", err)))
+		span.SetStatus(codes.Error, err.Error())
+		span.End()
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode >= 400 {
+		span.SetAttributes(attribute.String("error.type", strconv.Itoa(resp.StatusCode)))
+		span.SetStatus(codes.Error, "")
+	}
+	resp.Body = &spanBody{ReadCloser: resp.Body, span: span}
+	return resp, nil
+}
+
+// redactURL hides credentials in the URL, as url.full requires.
+func redactURL(u *url.URL) string {
+	if u.User == nil {
+		return u.String()
+	}
+	redacted := *u
+	redacted.User = url.UserPassword("REDACTED", "REDACTED")
+	return redacted.String()
+}
+
+// spanBody ends the span of a response once its body is done with.
+type spanBody struct {
+	io.ReadCloser
+	span trace.Span
+	once sync.Once
+}
+
+func (b *spanBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		if err != io.EOF {
+			b.span.SetStatus(codes.Error, err.Error())
+		}
+		b.end()
+	}
+	return n, err
+}
+
+func (b *spanBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.end()
+	return err
+}
+
+func (b *spanBody) end() {
+	b.once.Do(func() { b.span.End() })
+}
@@ -0,0 +1,233 @@
+package telemetry
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"google.golang.org/grpc/credentials"
+	// Registers the gzip compressor used by WithCompressor("gzip").
+	_ "google.golang.org/grpc/encoding/gzip"
+)
+
+// ExporterOptions configures the OTLP exporters, mirroring the
+// OTEL_EXPORTER_OTLP_* environment variables.
+type ExporterOptions struct {
+	// Protocol is "grpc" or "http/protobuf".
+	Protocol string
+	// Endpoint is host:port or a URL. Over HTTP, a URL gets the signal
+	// path (/v1/traces, ...) appended.
+	Endpoint string
+	// TracesEndpoint, MetricsEndpoint and LogsEndpoint override Endpoint
+	// for one signal and are used as is.
+	TracesEndpoint  string
+	MetricsEndpoint string
+	LogsEndpoint    string
+	// Insecure disables TLS for host:port endpoints; a URL's scheme decides
+	// for itself.
+	Insecure    bool
+	Headers     map[string]string
+	Compression string
+	// CertificateFile verifies the collector; ClientCertificateFile and
+	// ClientKeyFile authenticate the proxy to it.
+	CertificateFile       string
+	ClientCertificateFile string
+	ClientKeyFile         string
+}
+
+const protocolHTTP = "http/protobuf"
+
+// target is where one signal is exported to.
+type target struct {
+	endpoint string
+	isURL    bool
+	insecure bool
+}
+
+// target resolves the endpoint for a signal ("traces", "metrics", "logs").
+func (o *ExporterOptions) target(signal, override string) (target, error) {
+	raw := override
+	if raw == "" {
+		raw = o.Endpoint
+	}
+	if !strings.Contains(raw, "://") {
+		return target{endpoint: raw, insecure: o.Insecure}, nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return target{}, fmt.Errorf("invalid %s endpoint %q: %w", signal, raw, err)
+	}
+	if override == "" && o.Protocol == protocolHTTP {
+		u.Path = strings.TrimRight(u.Path, "/") + "/v1/" + signal
+	}
+	return target{endpoint: u.String(), isURL: true, insecure: u.Scheme == "http"}, nil
+}
+
+// tlsConfig returns the TLS settings for the exporters, or nil when the
+// system defaults apply.
+func (o *ExporterOptions) tlsConfig() (*tls.Config, error) {
+	if o.CertificateFile == "" && o.ClientCertificateFile == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if o.CertificateFile != "" {
+		pem, err := os.ReadFile(o.CertificateFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", o.CertificateFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if o.ClientCertificateFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.ClientCertificateFile, o.ClientKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func (o *ExporterOptions) gzip() bool {
+	return o.Compression == "gzip"
+}
+
+func newTraceExporter(ctx context.Context, o *ExporterOptions, tlsCfg *tls.Config) (sdktrace.SpanExporter, error) {
+	t, err := o.target("traces", o.TracesEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Protocol == protocolHTTP {
+		opts := []otlptracehttp.Option{otlptracehttp.WithHeaders(o.Headers)}
+		if t.isURL {
+			opts = append(opts, otlptracehttp.WithEndpointURL(t.endpoint))
+		} else {
+			opts = append(opts, otlptracehttp.WithEndpoint(t.endpoint))
+		}
+		if t.insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		} else if tlsCfg != nil {
+			opts = append(opts, otlptracehttp.WithTLSClientConfig(tlsCfg))
+		}
+		if o.gzip() {
+			opts = append(opts, otlptracehttp.WithCompression(otlptracehttp.GzipCompression))
+		}
+		return otlptracehttp.New(ctx, opts...)
+	}
+
+	opts := []otlptracegrpc.Option{otlptracegrpc.WithHeaders(o.Headers)}
+	if t.isURL {
+		opts = append(opts, otlptracegrpc.WithEndpointURL(t.endpoint))
+	} else {
+		opts = append(opts, otlptracegrpc.WithEndpoint(t.endpoint))
+	}
+	if t.insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	} else if tlsCfg != nil {
+		opts = append(opts, otlptracegrpc.WithTLSCredentials(credentials.NewTLS(tlsCfg)))
+	}
+	if o.gzip() {
+		opts = append(opts, otlptracegrpc.WithCompressor("gzip"))
+	}
+	return otlptracegrpc.New(ctx, opts...)
+}
+
+func newMetricExporter(ctx context.Context, o *ExporterOptions, tlsCfg *tls.Config) (sdkmetric.Exporter, error) {
+	t, err := o.target("metrics", o.MetricsEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Protocol == protocolHTTP {
+		opts := []otlpmetrichttp.Option{otlpmetrichttp.WithHeaders(o.Headers)}
+		if t.isURL {
+			opts = append(opts, otlpmetrichttp.WithEndpointURL(t.endpoint))
+		} else {
+			opts = append(opts, otlpmetrichttp.WithEndpoint(t.endpoint))
+		}
+		if t.insecure {
+			opts = append(opts, otlpmetrichttp.WithInsecure())
+		} else if tlsCfg != nil {
+			opts = append(opts, otlpmetrichttp.WithTLSClientConfig(tlsCfg))
+		}
+		if o.gzip() {
+			opts = append(opts, otlpmetrichttp.WithCompression(otlpmetrichttp.GzipCompression))
+		}
+		return otlpmetrichttp.New(ctx, opts...)
+	}
+
+	opts := []otlpmetricgrpc.Option{otlpmetricgrpc.WithHeaders(o.Headers)}
+	if t.isURL {
+		opts = append(opts, otlpmetricgrpc.WithEndpointURL(t.endpoint))
+	} else {
+		opts = append(opts, otlpmetricgrpc.WithEndpoint(t.endpoint))
+	}
+	if t.insecure {
+		opts = append(opts, otlpmetricgrpc.WithInsecure())
+	} else if tlsCfg != nil {
+		opts = append(opts, otlpmetricgrpc.WithTLSCredentials(credentials.NewTLS(tlsCfg)))
+	}
+	if o.gzip() {
+		opts = append(opts, otlpmetricgrpc.WithCompressor("gzip"))
+	}
+	return otlpmetricgrpc.New(ctx, opts...)
+}
+
+func newLogExporter(ctx context.Context, o *ExporterOptions, tlsCfg *tls.Config) (sdklog.Exporter, error) {
+	t, err := o.target("logs", o.LogsEndpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Protocol == protocolHTTP {
+		opts := []otlploghttp.Option{otlploghttp.WithHeaders(o.Headers)}
+		if t.isURL {
+			opts = append(opts, otlploghttp.WithEndpointURL(t.endpoint))
+		} else {
+			opts = append(opts, otlploghttp.WithEndpoint(t.endpoint))
+		}
+		if t.insecure {
+			opts = append(opts, otlploghttp.WithInsecure())
+		} else if tlsCfg != nil {
+			opts = append(opts, otlploghttp.WithTLSClientConfig(tlsCfg))
+		}
+		if o.gzip() {
+			opts = append(opts, otlploghttp.WithCompression(otlploghttp.GzipCompression))
+		}
+		return otlploghttp.New(ctx, opts...)
+	}
+
+	opts := []otlploggrpc.Option{otlploggrpc.WithHeaders(o.Headers)}
+	if t.isURL {
+		opts = append(opts, otlploggrpc.WithEndpointURL(t.endpoint))
+	} else {
+		opts = append(opts, otlploggrpc.WithEndpoint(t.endpoint))
+	}
+	if t.insecure {
+		opts = append(opts, otlploggrpc.WithInsecure())
+	} else if tlsCfg != nil {
+		opts = append(opts, otlploggrpc.WithTLSCredentials(credentials.NewTLS(tlsCfg)))
+	}
+	if o.gzip() {
+		opts = append(opts, otlploggrpc.WithCompressor("gzip"))
+	}
+	return otlploggrpc.New(ctx, opts...)
+}
@@ -0,0 +1,231 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploghttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	sdklog "go.opentelemetry.io/otel/sdk/log"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	collogspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	colmetricpb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// FileOptions configures the file exporter.
+type FileOptions struct {
+	// Dir receives traces.jsonl, metrics.jsonl and logs.jsonl.
+	Dir string
+	// MaxBytes is the size at which a file is rotated; MaxBackups rotated
+	// files are kept as <name>.1 (newest) to <name>.N.
+	MaxBytes   int64
+	MaxBackups int
+}
+
+// The file and console exporters are the OTLP/HTTP exporters with a
+// transport that writes each export request as one OTLP-JSON line instead
+// of sending it, so the output matches what a collector would receive.
+
+func newFileTraceExporter(ctx context.Context, w io.Writer) (sdktrace.SpanExporter, error) {
+	return otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpointURL("http://localhost/v1/traces"),
+		otlptracehttp.WithHTTPClient(jsonlClient(w, func() proto.Message { return &coltracepb.ExportTraceServiceRequest{} })),
+		otlptracehttp.WithCompression(otlptracehttp.NoCompression),
+		otlptracehttp.WithRetry(otlptracehttp.RetryConfig{Enabled: false}),
+	)
+}
+
+func newFileMetricExporter(ctx context.Context, w io.Writer) (sdkmetric.Exporter, error) {
+	return otlpmetrichttp.New(ctx,
+		otlpmetrichttp.WithEndpointURL("http://localhost/v1/metrics"),
+		otlpmetrichttp.WithHTTPClient(jsonlClient(w, func() proto.Message { return &colmetricpb.ExportMetricsServiceRequest{} })),
+		otlpmetrichttp.WithCompression(otlpmetrichttp.NoCompression),
+		otlpmetrichttp.WithRetry(otlpmetrichttp.RetryConfig{Enabled: false}),
+	)
+}
+
+func newFileLogExporter(ctx context.Context, w io.Writer) (sdklog.Exporter, error) {
+	return otlploghttp.New(ctx,
+		otlploghttp.WithEndpointURL("http://localhost/v1/logs"),
+		otlploghttp.WithHTTPClient(jsonlClient(w, func() proto.Message { return &collogspb.ExportLogsServiceRequest{} })),
+		otlploghttp.WithCompression(otlploghttp.NoCompression),
+		otlploghttp.WithRetry(otlploghttp.RetryConfig{Enabled: false}),
+	)
+}
+
+func jsonlClient(w io.Writer, newRequest func() proto.Message) *http.Client {
+	return &http.Client{Transport: &jsonlTransport{w: w, newRequest: newRequest}}
+}
+
+// jsonlTransport answers OTLP/HTTP export requests locally by writing them
+// to w as OTLP-JSON lines.
+type jsonlTransport struct {
+	w          io.Writer
+	newRequest func() proto.Message
+}
+
+func (t *jsonlTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	_ = req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	msg := t.newRequest()
+	if err := proto.Unmarshal(body, msg); err != nil {
+		return nil, fmt.Errorf("decoding export request: %w", err)
+	}
+	line, err := otlpJSON(msg)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := t.w.Write(line); err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{"Content-Type": {"application/x-protobuf"}},
+		Body:       http.NoBody,
+		Request:    req,
+	}, nil
+}
+
+// otlpJSON encodes msg as a single line of OTLP-JSON. This differs from
+// plain protobuf JSON in two ways: enums are numbers and trace and span IDs
+// are hex rather than base64.
+func otlpJSON(msg proto.Message) ([]byte, error) {
+	raw, err := protojson.MarshalOptions{UseEnumNumbers: true}.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+	hexIDs(v)
+	line, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(line, '\n'), nil
+}
+
+var idFields = map[string]bool{"traceId": true, "spanId": true, "parentSpanId": true}
+
+func hexIDs(v any) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, child := range v {
+			if s, ok := child.(string); ok && idFields[k] {
+				if id, err := base64.StdEncoding.DecodeString(s); err == nil {
+					v[k] = hex.EncodeToString(id)
+				}
+				continue
+			}
+			hexIDs(child)
+		}
+	case []any:
+		for _, child := range v {
+			hexIDs(child)
+		}
+	}
+}
+
+// openSignalFile opens <dir>/<signal>.jsonl for appending.
+func openSignalFile(o FileOptions, signal string) (*rotatingFile, error) {
+	if err := os.MkdirAll(o.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	f := &rotatingFile{
+		path:       filepath.Join(o.Dir, signal+".jsonl"),
+		maxBytes:   o.MaxBytes,
+		maxBackups: o.MaxBackups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// rotatingFile appends to path. A write that would take the file past
+// maxBytes first moves it to path.1, shifting older backups up and
+// dropping the oldest.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64
+	maxBackups int
+	f          *os.File
+	size       int64
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	r.f, r.size = f, info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size > 0 && r.size+int64(len(p)) > r.maxBytes {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.f.Close(); err != nil {
+		return err
+	}
+	if r.maxBackups == 0 {
+		if err := os.Remove(r.path); err != nil {
+			return err
+		}
+		return r.open()
+	}
+	backup := func(i int) string { return fmt.Sprintf("%s.%d", r.path, i) }
+	_ = os.Remove(backup(r.maxBackups))
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		_ = os.Rename(backup(i), backup(i+1))
+	}
+	if err := os.Rename(r.path, backup(1)); err != nil {
+		return err
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.f.Close()
+}
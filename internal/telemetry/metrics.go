@@ -14,6 +14,20 @@ type Metrics struct {
 	ErrorsTotal      metric.Int64Counter
 	ActiveSessions   metric.Int64UpDownCounter
 	CompressionRatio metric.Float64Histogram
+	// CompressionTokens and TokensSaved count estimated LLM tokens.
+	CompressionTokens metric.Int64Histogram
+	TokensSaved       metric.Int64UpDownCounter
+	// Truncations counts tool results split into pages; PageFetches the
+	// pages clients fetched afterwards.
+	Truncations metric.Int64Counter
+	PageFetches metric.Int64Counter
+	// InputSchemaFailures counts tools/call requests whose arguments fail
+	// the tool's inputSchema.
+	InputSchemaFailures metric.Int64Counter
+	StdioRestarts       metric.Int64Counter
+	SessionDuration     metric.Float64Histogram
+	SSEReplays          metric.Int64Counter
+	SSEReplayEvents     metric.Int64Counter
 }
 
 // InitMetrics creates and registers all metric instruments.
@@ -89,13 +103,110 @@ func InitMetrics() (*Metrics, error) {
 		return nil, err
 	}
 
+	compressionTokens, err := meter.Int64Histogram(
+		"mcp.proxy.compression.token.usage",
+		metric.WithDescription("Estimated LLM tokens in compressed tool results, before and after compression"),
+		metric.WithUnit("{token}"),
+		metric.WithExplicitBucketBoundaries(
+			16, 64, 256, 1024, 4096, 16384, 65536, 262144, 1048576,
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// Not a counter: compression can cost tokens even when it saves bytes
+	tokensSaved, err := meter.Int64UpDownCounter(
+		"mcp.proxy.compression.tokens_saved",
+		metric.WithDescription("Estimated LLM tokens saved by response compression"),
+		metric.WithUnit("{token}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	truncations, err := meter.Int64Counter(
+		"mcp.proxy.response.truncations",
+		metric.WithDescription("Tool results over their response budget, truncated to a first page"),
+		metric.WithUnit("{response}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	pageFetches, err := meter.Int64Counter(
+		"mcp.proxy.response.page_fetches",
+		metric.WithDescription("Pages of truncated tool results fetched with the proxy's page tool, by whether the page was still stored"),
+		metric.WithUnit("{page}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	inputSchemaFailures, err := meter.Int64Counter(
+		"mcp.proxy.tool.input_schema_failures",
+		metric.WithDescription("Tool calls whose arguments failed the tool's inputSchema, by tool and by whether the proxy warned or rejected"),
+		metric.WithUnit("{request}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	stdioRestarts, err := meter.Int64Counter(
+		"mcp.proxy.stdio.restarts",
+		metric.WithDescription("Restarts of crashed stdio MCP server processes"),
+		metric.WithUnit("{restart}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionDuration, err := meter.Float64Histogram(
+		"mcp.server.session.duration",
+		metric.WithDescription("Lifetime of MCP sessions, from initialize to termination or expiry"),
+		metric.WithUnit("s"),
+		metric.WithExplicitBucketBoundaries(
+			1, 10, 60, 300, 900, 1800, 3600, 7200, 14400, 28800, 86400,
+		),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	sseReplays, err := meter.Int64Counter(
+		"mcp.proxy.sse.replays",
+		metric.WithDescription("Client SSE reconnects with Last-Event-ID, by whether the proxy could replay them"),
+		metric.WithUnit("{replay}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	sseReplayEvents, err := meter.Int64Counter(
+		"mcp.proxy.sse.replayed_events",
+		metric.WithDescription("SSE events replayed to reconnecting clients from the proxy buffer"),
+		metric.WithUnit("{event}"),
+	)
+	if err != nil {
+		return nil, err
+	}
+
 	return &Metrics{
-		RequestDuration:  requestDuration,
-		RequestCount:     requestCount,
-		UpstreamLatency:  upstreamLatency,
-		MessageSize:      messageSize,
-		ErrorsTotal:      errorsTotal,
-		ActiveSessions:   activeSessions,
-		CompressionRatio: compressionRatio,
+		RequestDuration:     requestDuration,
+		RequestCount:        requestCount,
+		UpstreamLatency:     upstreamLatency,
+		MessageSize:         messageSize,
+		ErrorsTotal:         errorsTotal,
+		ActiveSessions:      activeSessions,
+		CompressionRatio:    compressionRatio,
+		CompressionTokens:   compressionTokens,
+		TokensSaved:         tokensSaved,
+		Truncations:         truncations,
+		PageFetches:         pageFetches,
+		InputSchemaFailures: inputSchemaFailures,
+		StdioRestarts:       stdioRestarts,
+		SessionDuration:     sessionDuration,
+		SSEReplays:          sseReplays,
+		SSEReplayEvents:     sseReplayEvents,
 	}, nil
 }
@@ -117,15 +117,16 @@ func InjectContextIntoBody(ctx context.Context, body []byte) ([]byte, error) {
 	return json.Marshal(msg)
 }
 
-// InjectContextIntoBatchBody injects context into each request in a batch.
-func InjectContextIntoBatchBody(ctx context.Context, body []byte) ([]byte, error) {
+// InjectContextIntoBatchBody injects ctxs[i] into the i-th request of a
+// batch, so each request carries its own span's context.
+func InjectContextIntoBatchBody(ctxs []context.Context, body []byte) ([]byte, error) {
 	var msgs []json.RawMessage
-	if err := json.Unmarshal(body, &msgs); err != nil {
+	if err := json.Unmarshal(body, &msgs); err != nil || len(msgs) != len(ctxs) {
 		return body, nil
 	}
 
 	for i, msg := range msgs {
-		modified, err := InjectContextIntoBody(ctx, msg)
+		modified, err := InjectContextIntoBody(ctxs[i], msg)
 		if err == nil {
 			msgs[i] = modified
 		}
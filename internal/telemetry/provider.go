@@ -3,20 +3,16 @@ package telemetry
 import (
 	"context"
 	"fmt"
+	"io"
 	"log/slog"
+	"net/http"
+	"os"
 	"time"
 
 	"go.opentelemetry.io/otel"
-	"go.opentelemetry.io/otel/exporters/otlp/otlplog/otlploggrpc"
-	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
-	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
 	sdklog "go.opentelemetry.io/otel/sdk/log"
 	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
-	"go.opentelemetry.io/otel/sdk/resource"
 	sdktrace "go.opentelemetry.io/otel/sdk/trace"
-	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
-	
-	
 
 	"go.opentelemetry.io/contrib/bridges/otelslog"
 )
@@ -27,79 +23,159 @@ type Providers struct {
 	MeterProvider  *sdkmetric.MeterProvider
 	LoggerProvider *sdklog.LoggerProvider
 	Logger         *slog.Logger
+	// MetricsHandler serves the Prometheus scrape endpoint; nil unless the
+	// prometheus metrics exporter is enabled.
+	MetricsHandler http.Handler
+
+	// files are the file exporter outputs, closed after the providers.
+	files []io.Closer
+}
+
+// Exporter names, as in OTEL_TRACES_EXPORTER, OTEL_METRICS_EXPORTER and
+// OTEL_LOGS_EXPORTER.
+const (
+	exporterOTLP       = "otlp"
+	exporterPrometheus = "prometheus"
+	exporterFile       = "file"
+	exporterConsole    = "console"
+)
+
+// Options configures InitOTel.
+type Options struct {
+	ServiceName string
+	// ResourceAttributes uses the OTEL_RESOURCE_ATTRIBUTES format.
+	ResourceAttributes string
+	// TracesExporter and LogsExporter are "otlp", "file", "console" or
+	// "none". Spans are still created without an exporter, so metric
+	// exemplars keep their trace IDs.
+	TracesExporter string
+	LogsExporter   string
+	// MetricsExporters lists any of "otlp", "prometheus", "file" and
+	// "console"; empty disables metric export.
+	MetricsExporters []string
+	OTLP             ExporterOptions
+	File             FileOptions
 }
 
 // InitOTel initializes all OTel providers (traces, metrics, logs) and returns them.
-// Uses OTel SDK auto-configuration via OTEL_EXPORTER_OTLP_ENDPOINT env var.
-func InitOTel(ctx context.Context, endpoint, serviceName string, isInsecure bool) (*Providers, error) {
-	res, err := resource.New(ctx,
-		resource.WithAttributes(
-			semconv.ServiceNameKey.String(serviceName),
-		),
-	)
+func InitOTel(ctx context.Context, opts Options) (*Providers, error) {
+	res, err := newResource(ctx, opts.ServiceName, opts.ResourceAttributes)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create OTel resource: %w", err)
+		if res == nil {
+			return nil, fmt.Errorf("failed to create OTel resource: %w", err)
+		}
+		// A failed detector only leaves its own attributes out.
+		slog.Warn("some resource attributes could not be detected", "error", err)
 	}
 
-	// Trace exporter — let SDK read OTEL_EXPORTER_OTLP_ENDPOINT + OTEL_EXPORTER_OTLP_INSECURE
-	traceOpts := []otlptracegrpc.Option{
-		otlptracegrpc.WithEndpoint(endpoint),
+	tlsCfg, err := opts.OTLP.tlsConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OTLP TLS configuration: %w", err)
 	}
-	if isInsecure {
-		traceOpts = append(traceOpts, otlptracegrpc.WithInsecure())
+
+	providers := &Providers{}
+
+	// Trace exporter + provider
+	traceOpts := []sdktrace.TracerProviderOption{sdktrace.WithResource(res)}
+	var traceExporter sdktrace.SpanExporter
+	switch opts.TracesExporter {
+	case exporterOTLP:
+		traceExporter, err = newTraceExporter(ctx, &opts.OTLP, tlsCfg)
+	case exporterFile, exporterConsole:
+		var w io.Writer
+		if w, err = providers.output(opts, "traces", opts.TracesExporter); err == nil {
+			traceExporter, err = newFileTraceExporter(ctx, w)
+		}
 	}
-	traceExporter, err := otlptracegrpc.New(ctx, traceOpts...)
 	if err != nil {
+		providers.closeFiles()
 		return nil, fmt.Errorf("failed to create trace exporter: %w", err)
 	}
-	tp := sdktrace.NewTracerProvider(
-		sdktrace.WithBatcher(traceExporter),
-		sdktrace.WithResource(res),
-	)
+	if traceExporter != nil {
+		traceOpts = append(traceOpts, sdktrace.WithBatcher(traceExporter))
+	}
+	tp := sdktrace.NewTracerProvider(traceOpts...)
 	otel.SetTracerProvider(tp)
+	providers.TracerProvider = tp
 
-	// Metric exporter + provider
-	metricOpts := []otlpmetricgrpc.Option{
-		otlpmetricgrpc.WithEndpoint(endpoint),
-	}
-	if isInsecure {
-		metricOpts = append(metricOpts, otlpmetricgrpc.WithInsecure())
-	}
-	metricExporter, err := otlpmetricgrpc.New(ctx, metricOpts...)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create metric exporter: %w", err)
+	// Metric exporters + provider
+	metricOpts := []sdkmetric.Option{sdkmetric.WithResource(res)}
+	for _, name := range opts.MetricsExporters {
+		var metricExporter sdkmetric.Exporter
+		switch name {
+		case exporterOTLP:
+			metricExporter, err = newMetricExporter(ctx, &opts.OTLP, tlsCfg)
+		case exporterFile, exporterConsole:
+			var w io.Writer
+			if w, err = providers.output(opts, "metrics", name); err == nil {
+				metricExporter, err = newFileMetricExporter(ctx, w)
+			}
+		case exporterPrometheus:
+			var reader sdkmetric.Reader
+			if reader, providers.MetricsHandler, err = newPrometheusReader(); err == nil {
+				metricOpts = append(metricOpts, sdkmetric.WithReader(reader))
+			}
+		}
+		if err != nil {
+			providers.closeFiles()
+			return nil, fmt.Errorf("failed to create %s metric exporter: %w", name, err)
+		}
+		if metricExporter != nil {
+			metricOpts = append(metricOpts, sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter, sdkmetric.WithInterval(10*time.Second))))
+		}
 	}
-	mp := sdkmetric.NewMeterProvider(
-		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter, sdkmetric.WithInterval(10*time.Second))),
-		sdkmetric.WithResource(res),
-	)
+	mp := sdkmetric.NewMeterProvider(metricOpts...)
 	otel.SetMeterProvider(mp)
+	providers.MeterProvider = mp
 
 	// Log exporter + provider
-	logOpts := []otlploggrpc.Option{
-		otlploggrpc.WithEndpoint(endpoint),
-	}
-	if isInsecure {
-		logOpts = append(logOpts, otlploggrpc.WithInsecure())
+	logOpts := []sdklog.LoggerProviderOption{sdklog.WithResource(res)}
+	var logExporter sdklog.Exporter
+	switch opts.LogsExporter {
+	case exporterOTLP:
+		logExporter, err = newLogExporter(ctx, &opts.OTLP, tlsCfg)
+	case exporterFile, exporterConsole:
+		var w io.Writer
+		if w, err = providers.output(opts, "logs", opts.LogsExporter); err == nil {
+			logExporter, err = newFileLogExporter(ctx, w)
+		}
 	}
-	logExporter, err := otlploggrpc.New(ctx, logOpts...)
 	if err != nil {
+		providers.closeFiles()
 		return nil, fmt.Errorf("failed to create log exporter: %w", err)
 	}
-	lp := sdklog.NewLoggerProvider(
-		sdklog.WithProcessor(sdklog.NewBatchProcessor(logExporter)),
-		sdklog.WithResource(res),
-	)
+	if logExporter != nil {
+		logOpts = append(logOpts, sdklog.WithProcessor(sdklog.NewBatchProcessor(logExporter)))
+	}
+	lp := sdklog.NewLoggerProvider(logOpts...)
+	providers.LoggerProvider = lp
 
 	// Create slog logger with otelslog bridge
-	logger := slog.New(otelslog.NewHandler(serviceName, otelslog.WithLoggerProvider(lp)))
-
-	return &Providers{
-		TracerProvider: tp,
-		MeterProvider:  mp,
-		LoggerProvider: lp,
-		Logger:         logger,
-	}, nil
+	providers.Logger = slog.New(otelslog.NewHandler(opts.ServiceName, otelslog.WithLoggerProvider(lp)))
+
+	return providers, nil
+}
+
+// output returns where the file or console exporter writes a signal.
+func (p *Providers) output(opts Options, signal, exporter string) (io.Writer, error) {
+	if exporter == exporterConsole {
+		return os.Stdout, nil
+	}
+	f, err := openSignalFile(opts.File, signal)
+	if err != nil {
+		return nil, err
+	}
+	p.files = append(p.files, f)
+	return f, nil
+}
+
+func (p *Providers) closeFiles() {
+	for _, f := range p.files {
+		if err := f.Close(); err != nil {
+			slog.Error("failed to close telemetry file", "error", err)
+		}
+	}
+	p.files = nil
 }
 
 // Shutdown gracefully shuts down all OTel providers.
@@ -122,4 +198,5 @@ func (p *Providers) Shutdown(ctx context.Context) {
 			slog.Error("failed to shutdown logger provider", "error", err)
 		}
 	}
+	p.closeFiles()
 }
@@ -0,0 +1,75 @@
+package telemetry
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/resource"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// kubernetesEnv maps environment variables, typically set from the pod spec
+// with the downward API, to resource attributes.
+var kubernetesEnv = []struct {
+	env string
+	key attribute.Key
+}{
+	{"K8S_POD_NAME", semconv.K8SPodNameKey},
+	{"K8S_POD_UID", semconv.K8SPodUIDKey},
+	{"K8S_NAMESPACE_NAME", semconv.K8SNamespaceNameKey},
+	{"K8S_NODE_NAME", semconv.K8SNodeNameKey},
+	{"K8S_CONTAINER_NAME", semconv.K8SContainerNameKey},
+	{"K8S_DEPLOYMENT_NAME", semconv.K8SDeploymentNameKey},
+}
+
+// newResource describes the proxy process. Later sources win: detected
+// host, OS, process and container attributes, then Kubernetes downward-API
+// variables, then the configured resource attributes, then the service name.
+func newResource(ctx context.Context, serviceName, attrs string) (*resource.Resource, error) {
+	return resource.New(ctx,
+		resource.WithTelemetrySDK(),
+		resource.WithHost(),
+		resource.WithOS(),
+		// Command-line arguments are left out: they may carry secrets.
+		resource.WithProcessPID(),
+		resource.WithProcessExecutableName(),
+		resource.WithProcessRuntimeName(),
+		resource.WithProcessRuntimeVersion(),
+		resource.WithContainer(),
+		resource.WithAttributes(kubernetesAttrs()...),
+		resource.WithAttributes(parseResourceAttrs(attrs)...),
+		resource.WithAttributes(semconv.ServiceNameKey.String(serviceName)),
+	)
+}
+
+func kubernetesAttrs() []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	for _, k := range kubernetesEnv {
+		if v := os.Getenv(k.env); v != "" {
+			attrs = append(attrs, k.key.String(v))
+		}
+	}
+	return attrs
+}
+
+// parseResourceAttrs parses the OTEL_RESOURCE_ATTRIBUTES format: comma
+// separated key=value pairs with URL-encoded values. Malformed pairs are
+// skipped.
+func parseResourceAttrs(s string) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	for _, pair := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			continue
+		}
+		if decoded, err := url.PathUnescape(strings.TrimSpace(value)); err == nil {
+			value = decoded
+		}
+		attrs = append(attrs, attribute.String(key, value))
+	}
+	return attrs
+}
@@ -2,27 +2,63 @@ package telemetry
 
 import (
 	"context"
+	"encoding/json"
 
 	"go.opentelemetry.io/otel"
 	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/codes"
 	"go.opentelemetry.io/otel/trace"
 
+	"github.com/isitobservable/mcp-otel-proxy/internal/jsonrpc"
 	"github.com/isitobservable/mcp-otel-proxy/internal/mcp"
 )
 
 const tracerName = "mcp-otel-proxy"
 
+// Peer identifies the upstream MCP server a request is routed to.
+type Peer struct {
+	Name    string
+	Address string
+	Port    int
+	// Transport is the network.transport value: "tcp" for HTTP upstreams,
+	// "pipe" for stdio servers.
+	Transport string
+}
+
 // StartMCPSpan creates a new OTel span for an MCP request following semantic conventions.
-func StartMCPSpan(ctx context.Context, reqInfo *mcp.RequestInfo, session *mcp.Session, serverAddr string, serverPort int) (context.Context, trace.Span) {
-	tracer := otel.Tracer(tracerName)
+func StartMCPSpan(ctx context.Context, reqInfo *mcp.RequestInfo, session *mcp.Session, peer Peer) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, reqInfo.SpanName(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(mcpSpanAttrs(reqInfo, session, peer)...),
+	)
+}
 
+// StartServerMessageSpan creates a span for a request or notification the
+// upstream server sends to the client. sessionID attributes the message when
+// the session is not tracked in the store.
+func StartServerMessageSpan(ctx context.Context, reqInfo *mcp.RequestInfo, session *mcp.Session, sessionID string, peer Peer) (context.Context, trace.Span) {
+	attrs := mcpSpanAttrs(reqInfo, session, peer)
+	if session == nil && sessionID != "" {
+		attrs = append(attrs, attribute.String("mcp.session.id", sessionID))
+	}
+	return otel.Tracer(tracerName).Start(ctx, reqInfo.SpanName(),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+}
+
+func mcpSpanAttrs(reqInfo *mcp.RequestInfo, session *mcp.Session, peer Peer) []attribute.KeyValue {
 	attrs := []attribute.KeyValue{
 		attribute.String("mcp.method.name", reqInfo.Method),
-		attribute.String("network.transport", "tcp"),
-		attribute.String("network.protocol.name", "http"),
-		attribute.String("server.address", serverAddr),
-		attribute.Int("server.port", serverPort),
+		attribute.String("network.transport", peer.Transport),
+		attribute.String("mcp.proxy.upstream.name", peer.Name),
+	}
+	if peer.Transport != "pipe" {
+		attrs = append(attrs,
+			attribute.String("network.protocol.name", "http"),
+			attribute.String("server.address", peer.Address),
+			attribute.Int("server.port", peer.Port),
+		)
 	}
 
 	if reqInfo.ToolName != "" {
@@ -53,12 +89,7 @@ func StartMCPSpan(ctx context.Context, reqInfo *mcp.RequestInfo, session *mcp.Se
 		}
 	}
 
-	ctx, span := tracer.Start(ctx, reqInfo.SpanName(),
-		trace.WithSpanKind(trace.SpanKindServer),
-		trace.WithAttributes(attrs...),
-	)
-
-	return ctx, span
+	return attrs
 }
 
 // EndMCPSpan completes the span with response information.
@@ -95,3 +126,60 @@ func SetPayloadAttributes(span trace.Span, arguments, result string) {
 		span.SetAttributes(attribute.String("gen_ai.tool.call.result", result))
 	}
 }
+
+// AddMessageEvent records a notification or server request that arrived on a
+// response stream while the request was in flight. Progress and logging
+// notifications get their fields as event attributes; the log payload is only
+// captured when capturePayload is set.
+func AddMessageEvent(span trace.Span, msg *jsonrpc.Request, capturePayload bool) {
+	attrs := []attribute.KeyValue{
+		attribute.String("mcp.method.name", msg.Method),
+	}
+	if !msg.IsNotification() {
+		attrs = append(attrs, attribute.String("jsonrpc.request.id", jsonrpc.IDString(msg.ID)))
+	}
+
+	var params map[string]json.RawMessage
+	_ = json.Unmarshal(msg.Params, &params)
+
+	switch msg.Method {
+	case "notifications/progress":
+		if tok, ok := params["progressToken"]; ok {
+			attrs = append(attrs, attribute.String("mcp.progress.token", jsonrpc.IDString(tok)))
+		}
+		var progress struct {
+			Progress *float64 `json:"progress"`
+			Total    *float64 `json:"total"`
+			Message  string   `json:"message"`
+		}
+		if json.Unmarshal(msg.Params, &progress) == nil {
+			if progress.Progress != nil {
+				attrs = append(attrs, attribute.Float64("mcp.progress.value", *progress.Progress))
+			}
+			if progress.Total != nil {
+				attrs = append(attrs, attribute.Float64("mcp.progress.total", *progress.Total))
+			}
+			if progress.Message != "" {
+				attrs = append(attrs, attribute.String("mcp.progress.message", progress.Message))
+			}
+		}
+	case "notifications/message":
+		var logMsg struct {
+			Level  string `json:"level"`
+			Logger string `json:"logger"`
+		}
+		if json.Unmarshal(msg.Params, &logMsg) == nil {
+			if logMsg.Level != "" {
+				attrs = append(attrs, attribute.String("mcp.log.level", logMsg.Level))
+			}
+			if logMsg.Logger != "" {
+				attrs = append(attrs, attribute.String("mcp.log.logger", logMsg.Logger))
+			}
+		}
+		if data, ok := params["data"]; ok && capturePayload {
+			attrs = append(attrs, attribute.String("mcp.log.data", string(data)))
+		}
+	}
+
+	span.AddEvent(msg.Method, trace.WithAttributes(attrs...))
+}
@@ -0,0 +1,151 @@
+// Package tokens estimates how many LLM tokens a text costs, so response
+// compression can be accounted for in tokens rather than bytes.
+package tokens
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Counter counts the tokens in a text.
+type Counter interface {
+	Count(text string) int
+}
+
+// New returns a Counter that uses the BPE vocabulary in vocabFile, or the
+// built-in approximation when vocabFile is empty.
+func New(vocabFile string) (Counter, error) {
+	if vocabFile == "" {
+		return Approx{}, nil
+	}
+	f, err := os.Open(vocabFile)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	bpe, err := ReadBPE(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", vocabFile, err)
+	}
+	return bpe, nil
+}
+
+// pieces splits text the way GPT-style tokenizers do before applying BPE:
+// words with their leading space, runs of up to three digits, punctuation
+// runs and whitespace. RE2 has no lookahead, so trailing whitespace is not
+// split off the way tiktoken does.
+var pieces = regexp.MustCompile(`(?i:'s|'t|'re|'ve|'m|'ll|'d)|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^\s\p{L}\p{N}]+[\r\n]*|\s*[\r\n]+|\s+`)
+
+// Approx approximates a BPE tokenizer without a vocabulary: a word costs a
+// token per six letters, a run of digits one token, punctuation a token per
+// two characters and non-Latin letters a token each.
+type Approx struct{}
+
+func (Approx) Count(text string) int {
+	n := 0
+	for _, piece := range pieces.FindAllString(text, -1) {
+		n += approxPiece(piece)
+	}
+	return n
+}
+
+func approxPiece(piece string) int {
+	var letters, wide, symbols int
+	for _, r := range piece {
+		switch {
+		case r > unicode.MaxLatin1 && unicode.IsLetter(r):
+			wide++
+		case unicode.IsLetter(r):
+			letters++
+		case unicode.IsDigit(r), unicode.IsSpace(r):
+		default:
+			symbols++
+		}
+	}
+	n := wide + (letters+5)/6
+	if letters == 0 {
+		n += (symbols + 1) / 2
+	}
+	return max(n, 1)
+}
+
+// BPE counts tokens with a byte-level BPE vocabulary.
+type BPE struct {
+	ranks map[string]int
+}
+
+// ReadBPE reads a vocabulary in tiktoken format: one base64-encoded token
+// and its rank per line, as in cl100k_base.tiktoken.
+func ReadBPE(r io.Reader) (*BPE, error) {
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		token, rank, ok := strings.Cut(text, " ")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected a token and a rank", line)
+		}
+		b, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		n, err := strconv.Atoi(rank)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		ranks[string(b)] = n
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ranks) == 0 {
+		return nil, fmt.Errorf("empty vocabulary")
+	}
+	return &BPE{ranks: ranks}, nil
+}
+
+func (b *BPE) Count(text string) int {
+	n := 0
+	for _, piece := range pieces.FindAllString(text, -1) {
+		n += b.countPiece(piece)
+	}
+	return n
+}
+
+// countPiece merges the bytes of piece pairwise, always merging the pair
+// with the lowest rank, until no pair is in the vocabulary.
+func (b *BPE) countPiece(piece string) int {
+	if _, ok := b.ranks[piece]; ok {
+		return 1
+	}
+	// bounds holds the start of every part, then len(piece)
+	bounds := make([]int, len(piece)+1)
+	for i := range bounds {
+		bounds[i] = i
+	}
+	for len(bounds) > 2 {
+		best, bestRank := -1, math.MaxInt
+		for i := 0; i+2 < len(bounds); i++ {
+			if rank, ok := b.ranks[piece[bounds[i]:bounds[i+2]]]; ok && rank < bestRank {
+				best, bestRank = i, rank
+			}
+		}
+		if best < 0 {
+			break
+		}
+		bounds = slices.Delete(bounds, best+1, best+2)
+	}
+	return len(bounds) - 1
+}
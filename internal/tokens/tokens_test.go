@@ -0,0 +1,62 @@
+package tokens
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestApprox(t *testing.T) {
+	for _, tc := range []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"Hello world", 2},
+		{"internationalization", 4},
+		{"12345678", 3},
+		{"| a |", 3},
+		{"日本語", 3},
+	} {
+		if got := (Approx{}).Count(tc.text); got != tc.want {
+			t.Errorf("Count(%q) = %d, want %d", tc.text, got, tc.want)
+		}
+	}
+}
+
+func vocab(tokens ...string) string {
+	var b strings.Builder
+	for i, tok := range tokens {
+		fmt.Fprintf(&b, "%s %d\n", base64.StdEncoding.EncodeToString([]byte(tok)), i)
+	}
+	return b.String()
+}
+
+func TestBPE(t *testing.T) {
+	bpe, err := ReadBPE(strings.NewReader(vocab("l", "o", "w", "e", "r", " ", "lo", "low", "er", " low")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		text string
+		want int
+	}{
+		{"low", 1},
+		{"lower", 2},     // low + er
+		{"low lower", 3}, // low, " low" + er
+		{"xyz", 3},       // unknown bytes stay single
+	} {
+		if got := bpe.Count(tc.text); got != tc.want {
+			t.Errorf("Count(%q) = %d, want %d", tc.text, got, tc.want)
+		}
+	}
+}
+
+func TestReadBPE_Malformed(t *testing.T) {
+	for _, input := range []string{"", "bG93\n", "!!! 1\n", "bG93 x\n"} {
+		if _, err := ReadBPE(strings.NewReader(input)); err == nil {
+			t.Errorf("expected an error for %q", input)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// Package traceview renders the trace files written by the file exporter
+// as per-session timelines of MCP calls.
+package traceview
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// statusError is the OTLP status code for a failed span.
+const statusError = 2
+
+// Span is one span read from an OTLP-JSON trace file.
+type Span struct {
+	TraceID       string
+	SpanID        string
+	ParentSpanID  string
+	Name          string
+	Start         time.Time
+	End           time.Time
+	Attributes    map[string]string
+	Error         bool
+	StatusMessage string
+	Children      []*Span
+}
+
+// Duration is how long the span took.
+func (s *Span) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// Session is the MCP calls made within one MCP session, in start order.
+// Spans without a session ID end up in a session with an empty ID.
+type Session struct {
+	ID    string
+	Calls []*Span
+}
+
+// Errors counts the calls that failed.
+func (s *Session) Errors() int {
+	n := 0
+	for _, c := range s.Calls {
+		if c.Error {
+			n++
+		}
+	}
+	return n
+}
+
+// OTLP-JSON as written by the file exporter. Integers may be strings or
+// numbers, which json.Number accepts either way.
+type exportRequest struct {
+	ResourceSpans []struct {
+		ScopeSpans []struct {
+			Spans []struct {
+				TraceID           string      `json:"traceId"`
+				SpanID            string      `json:"spanId"`
+				ParentSpanID      string      `json:"parentSpanId"`
+				Name              string      `json:"name"`
+				StartTimeUnixNano json.Number `json:"startTimeUnixNano"`
+				EndTimeUnixNano   json.Number `json:"endTimeUnixNano"`
+				Attributes        []keyValue  `json:"attributes"`
+				Status            struct {
+					Code    int    `json:"code"`
+					Message string `json:"message"`
+				} `json:"status"`
+			} `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+type keyValue struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue *string      `json:"stringValue"`
+		IntValue    *json.Number `json:"intValue"`
+		BoolValue   *bool        `json:"boolValue"`
+		DoubleValue *float64     `json:"doubleValue"`
+	} `json:"value"`
+}
+
+func (kv keyValue) String() string {
+	v := kv.Value
+	switch {
+	case v.StringValue != nil:
+		return *v.StringValue
+	case v.IntValue != nil:
+		return v.IntValue.String()
+	case v.BoolValue != nil:
+		return strconv.FormatBool(*v.BoolValue)
+	case v.DoubleValue != nil:
+		return strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64)
+	}
+	return ""
+}
+
+// Read decodes the export requests in r, one JSON object per line, and
+// returns their spans.
+func Read(r io.Reader) ([]*Span, error) {
+	var spans []*Span
+	dec := json.NewDecoder(r)
+	for {
+		var req exportRequest
+		if err := dec.Decode(&req); err != nil {
+			if errors.Is(err, io.EOF) {
+				return spans, nil
+			}
+			return spans, fmt.Errorf("reading spans: %w", err)
+		}
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, s := range ss.Spans {
+					span := &Span{
+						TraceID:       s.TraceID,
+						SpanID:        s.SpanID,
+						ParentSpanID:  s.ParentSpanID,
+						Name:          s.Name,
+						Start:         unixNano(s.StartTimeUnixNano),
+						End:           unixNano(s.EndTimeUnixNano),
+						Attributes:    make(map[string]string, len(s.Attributes)),
+						Error:         s.Status.Code == statusError,
+						StatusMessage: s.Status.Message,
+					}
+					for _, kv := range s.Attributes {
+						span.Attributes[kv.Key] = kv.String()
+					}
+					spans = append(spans, span)
+				}
+			}
+		}
+	}
+}
+
+func unixNano(n json.Number) time.Time {
+	ns, _ := strconv.ParseInt(n.String(), 10, 64)
+	return time.Unix(0, ns)
+}
+
+// Sessions groups spans by mcp.session.id. Spans without one, such as
+// compression spans, join the session of another span in their trace and
+// are nested under their parent when it is present.
+func Sessions(spans []*Span) []*Session {
+	traceSession := make(map[string]string)
+	byID := make(map[string]*Span, len(spans))
+	for _, s := range spans {
+		byID[s.SpanID] = s
+		if id := s.Attributes["mcp.session.id"]; id != "" {
+			traceSession[s.TraceID] = id
+		}
+	}
+
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].Start.Before(spans[j].Start) })
+
+	bySession := make(map[string]*Session)
+	var sessions []*Session
+	for _, s := range spans {
+		if parent, ok := byID[s.ParentSpanID]; ok && s.ParentSpanID != "" {
+			parent.Children = append(parent.Children, s)
+			continue
+		}
+		id := s.Attributes["mcp.session.id"]
+		if id == "" {
+			id = traceSession[s.TraceID]
+		}
+		session, ok := bySession[id]
+		if !ok {
+			session = &Session{ID: id}
+			bySession[id] = session
+			sessions = append(sessions, session)
+		}
+		session.Calls = append(session.Calls, s)
+	}
+	return sessions
+}
+
+// Render writes each session as a timeline, with offsets from the
+// session's first call.
+func Render(w io.Writer, sessions []*Session) error {
+	for i, session := range sessions {
+		if i > 0 {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+		if err := renderSession(w, session); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func renderSession(w io.Writer, session *Session) error {
+	if len(session.Calls) == 0 {
+		return nil
+	}
+	first := session.Calls[0]
+	id := session.ID
+	if id == "" {
+		id = "(no session)"
+	}
+	header := fmt.Sprintf("session %s  %s  %d call(s), %d error(s)",
+		id, first.Start.UTC().Format(time.RFC3339), len(session.Calls), session.Errors())
+	if up := first.Attributes["mcp.proxy.upstream.name"]; up != "" {
+		header += "  upstream " + up
+	}
+	if _, err := fmt.Fprintln(w, header); err != nil {
+		return err
+	}
+
+	var render func(s *Span, depth int) error
+	render = func(s *Span, depth int) error {
+		line := fmt.Sprintf("%s+%-9s %8s  %s", strings.Repeat("  ", depth+1),
+			formatOffset(s.Start.Sub(first.Start)), formatDuration(s.Duration()), s.Name)
+		if s.Error {
+			line += "  ERROR"
+			if errType := s.Attributes["error.type"]; errType != "" {
+				line += " " + errType
+			}
+			if s.StatusMessage != "" {
+				line += ": " + s.StatusMessage
+			}
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+		for _, child := range s.Children {
+			if err := render(child, depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, call := range session.Calls {
+		if err := render(call, 0); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func formatOffset(d time.Duration) string {
+	return fmt.Sprintf("%.3fs", d.Seconds())
+}
+
+func formatDuration(d time.Duration) string {
+	switch {
+	case d < time.Millisecond:
+		return fmt.Sprintf("%dµs", d.Microseconds())
+	case d < time.Second:
+		return fmt.Sprintf("%dms", d.Milliseconds())
+	default:
+		return fmt.Sprintf("%.2fs", d.Seconds())
+	}
+}
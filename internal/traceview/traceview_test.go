@@ -0,0 +1,61 @@
+package traceview
+
+import (
+	"strings"
+	"testing"
+)
+
+const traces = `{"resourceSpans":[{"scopeSpans":[{"spans":[
+{"traceId":"t1","spanId":"a","name":"initialize","startTimeUnixNano":"1000000000","endTimeUnixNano":"1050000000","attributes":[{"key":"mcp.session.id","value":{"stringValue":"s1"}},{"key":"mcp.proxy.upstream.name","value":{"stringValue":"k8s"}}]},
+{"traceId":"t2","spanId":"b","parentSpanId":"remote","name":"tools/call get_pods","startTimeUnixNano":"2000000000","endTimeUnixNano":"2800000000","attributes":[{"key":"mcp.session.id","value":{"stringValue":"s1"}},{"key":"error.type","value":{"stringValue":"tool_error"}}],"status":{"code":2,"message":"tool execution failed"}}
+]}]}]}
+{"resourceSpans":[{"scopeSpans":[{"spans":[
+{"traceId":"t2","spanId":"c","parentSpanId":"b","name":"mcp.response.compress","startTimeUnixNano":2700000000,"endTimeUnixNano":2703000000},
+{"traceId":"t3","spanId":"d","name":"ping","startTimeUnixNano":"500000000","endTimeUnixNano":"500400000"}
+]}]}]}
+`
+
+func TestSessions(t *testing.T) {
+	spans, err := Read(strings.NewReader(traces))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := Sessions(spans)
+	if len(sessions) != 2 {
+		t.Fatalf("expected 2 sessions, got %d", len(sessions))
+	}
+	if sessions[0].ID != "" || sessions[1].ID != "s1" {
+		t.Errorf("expected sessions ordered by first call, got %q and %q", sessions[0].ID, sessions[1].ID)
+	}
+
+	s1 := sessions[1]
+	if len(s1.Calls) != 2 || s1.Errors() != 1 {
+		t.Fatalf("expected 2 calls with 1 error, got %d and %d", len(s1.Calls), s1.Errors())
+	}
+	if children := s1.Calls[1].Children; len(children) != 1 || children[0].Name != "mcp.response.compress" {
+		t.Errorf("expected the compress span nested under its parent, got %+v", children)
+	}
+}
+
+func TestRender(t *testing.T) {
+	spans, err := Read(strings.NewReader(traces))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := Render(&b, Sessions(spans)); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"session (no session)",
+		"session s1  1970-01-01T00:00:01Z  2 call(s), 1 error(s)  upstream k8s",
+		"+0.000s        50ms  initialize",
+		"+1.000s       800ms  tools/call get_pods  ERROR tool_error: tool execution failed",
+		"    +1.700s         3ms  mcp.response.compress",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in:\n%s", want, out)
+		}
+	}
+}